@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	nostr "github.com/nbd-wtf/go-nostr"
+)
+
+// fetchActivity asks each relay for the newest kind 1 note of each follow
+// created in the last windowDays and returns its timestamp per author. Every
+// author gets its own filter with limit 1, so prolific authors can't crowd
+// out the others; authors no relay had a note for are absent.
+func fetchActivity(ctx context.Context, relays []string, batches [][]string, windowDays, parallel int, timeout time.Duration) map[string]int64 {
+	since := nostr.Timestamp(time.Now().AddDate(0, 0, -windowDays).Unix())
+	latest := make(map[string]int64)
+	var mu sync.Mutex
+
+	semaphore := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for _, relayURL := range relays {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			connectCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			relay, err := nostr.RelayConnect(connectCtx, url)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "    ⚠ Activity: cannot connect to %s: %v\n", url, err)
+				return
+			}
+			defer relay.Close()
+
+			for _, batch := range batches {
+				for _, pks := range chunkAuthors(batch, defaultMaxFilters) {
+					batchCtx, batchCancel := context.WithTimeout(ctx, timeout)
+					filters := make(nostr.Filters, 0, len(pks))
+					for _, pk := range pks {
+						filters = append(filters, nostr.Filter{Kinds: []int{1}, Authors: []string{pk}, Since: &since, Limit: 1})
+					}
+					sub, err := relay.Subscribe(batchCtx, filters)
+					if err != nil {
+						batchCancel()
+						continue
+					}
+				events:
+					for {
+						select {
+						case <-batchCtx.Done():
+							break events
+						case <-sub.EndOfStoredEvents:
+							break events
+						case ev := <-sub.Events:
+							if ev == nil {
+								continue
+							}
+							pk := strings.ToLower(ev.PubKey)
+							mu.Lock()
+							if int64(ev.CreatedAt) > latest[pk] {
+								latest[pk] = int64(ev.CreatedAt)
+							}
+							mu.Unlock()
+						}
+					}
+					sub.Unsub()
+					batchCancel()
+				}
+			}
+		}(relayURL)
+	}
+	wg.Wait()
+	return latest
+}
+
+// writeActivity saves follow_activity.txt: a window header and "pubkey unix" lines
+func writeActivity(path string, windowDays int, latest map[string]int64) error {
+	lines := []string{fmt.Sprintf("# window_days %d", windowDays)}
+	pks := make([]string, 0, len(latest))
+	for pk := range latest {
+		pks = append(pks, pk)
+	}
+	sort.Strings(pks)
+	for _, pk := range pks {
+		lines = append(lines, fmt.Sprintf("%s %d", pk, latest[pk]))
+	}
+	return writeLines(path, lines)
+}
+
+// loadActivity reads follow_activity.txt. windowDays is 0 when the header is missing.
+func loadActivity(path string) (latest map[string]int64, windowDays int, err error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	latest = make(map[string]int64)
+	for _, l := range lines {
+		fields := strings.Fields(l)
+		if len(fields) == 3 && fields[0] == "#" && fields[1] == "window_days" {
+			windowDays, _ = strconv.Atoi(fields[2])
+			continue
+		}
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if ts, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			latest[strings.ToLower(fields[0])] = ts
+		}
+	}
+	return latest, windowDays, nil
+}
+
+// inactiveFollows returns the follows whose newest known note is older than
+// maxInactiveDays. Authors absent from the activity data are unknown, not
+// inactive: the relays asked may simply not carry their notes.
+func inactiveFollows(follows map[string]struct{}, latest map[string]int64, maxInactiveDays int) []string {
+	cutoff := time.Now().AddDate(0, 0, -maxInactiveDays).Unix()
+	var out []string
+	for pk := range follows {
+		if ts, ok := latest[pk]; ok && ts < cutoff {
+			out = append(out, pk)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	nostr "github.com/nbd-wtf/go-nostr"
+)
+
+func TestFetchActivity(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	now := time.Now().Unix()
+	old := signedEvent(t, sk, 1, now-3600, nil, "older")
+	newest := signedEvent(t, sk, 1, now-60, nil, "newer")
+	url, _ := fakeRelay(t, old, newest)
+
+	silent := strings.Repeat("0", 64)
+	latest := fetchActivity(context.Background(), []string{url}, [][]string{{pk, silent}}, 30, 1, 5*time.Second)
+	if want := map[string]int64{pk: now - 60}; !reflect.DeepEqual(latest, want) {
+		t.Errorf("latest %v, want %v", latest, want)
+	}
+}
+
+func TestInactiveFollows(t *testing.T) {
+	now := time.Now().Unix()
+	follows := map[string]struct{}{"active": {}, "stale": {}, "absent": {}}
+	latest := map[string]int64{"active": now - 86400, "stale": now - 100*86400}
+
+	// An author without activity data is unknown and kept
+	if got, want := inactiveFollows(follows, latest, 30), []string{"stale"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("inactive %v, want %v", got, want)
+	}
+}
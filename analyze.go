@@ -3,12 +3,15 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,6 +26,8 @@ type Event struct {
 	Tags      [][]string `json:"tags"`
 	Content   string     `json:"content"`
 	Sig       string     `json:"sig"`
+	// SeenOn lists the relays collect received the event from (optional)
+	SeenOn []string `json:"seen_on,omitempty"`
 }
 
 type set map[string]struct{}
@@ -30,16 +35,19 @@ type set map[string]struct{}
 func (s set) add(v string)      { s[v] = struct{}{} }
 func (s set) has(v string) bool { _, ok := s[v]; return ok }
 
+// urlToHost returns the canonical host of a relay URL or bare host name, the
+// same host normalizeURL keys the relay under
 func urlToHost(u string) string {
 	u = strings.ToLower(strings.TrimSpace(u))
-	u = strings.TrimPrefix(u, "wss://")
-	u = strings.TrimPrefix(u, "ws://")
-	u = strings.TrimSuffix(u, "/")
+	scheme := ""
+	if s, rest, ok := strings.Cut(u, "://"); ok {
+		scheme, u = s, rest
+	}
 	// strip any path/query/fragment
 	if i := strings.IndexAny(u, "/?#"); i >= 0 {
 		u = u[:i]
 	}
-	return u
+	return canonicalHost(u, scheme)
 }
 
 func readLines(path string) ([]string, error) {
@@ -77,20 +85,73 @@ func writeLines(path string, lines []string) error {
 	return w.Flush()
 }
 
-func analyzeCmd(args []string) {
+func analyzeCmd(ctx context.Context, args []string) {
 	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
 	dataDir := commonFlags(fs)
+	requireWSS := fs.Bool("require-wss", false, "drop plaintext ws:// relays from the write map")
+	regressionDelta := fs.Float64("regression-delta", 5, "warn when follow coverage drops by more than this many percentage points since the last run")
+	failOnRegression := fs.Bool("fail-on-regression", false, "exit non-zero (and keep the previous coverage snapshot) when coverage regresses")
 	checkMonitors := fs.Bool("check-monitors", false, "query NIP-66 relay monitors for liveness data")
+	pruneDead := fs.Bool("prune-dead", false, "with monitor data and a probe report (relay_latency.txt), only drop relays that are offline per monitors AND unreachable per probe (implies --check-monitors)")
 	monitorRelays := fs.String("monitor-relays", "wss://monitorlizard.nostr1.com", "comma-separated list of relays to query for NIP-66 events")
 	monitorTimeout := fs.Int("monitor-timeout", 10, "timeout in seconds for querying monitor relays")
-	inputJSONL := fs.String("input", "", "path to all_relay_lists.jsonl (default: data-dir/all_relay_lists.jsonl)")
+	strfryExport := fs.String("strfry-export", "", "analyze the kind 10002 events of a local 'strfry export' JSONL dump instead of --input, without any network calls")
+	inputJSONL := fs.String("input", "", "path or http(s) URL of all_relay_lists.jsonl, optionally gzipped (default: data-dir/all_relay_lists.jsonl)")
 	followsFile := fs.String("follows", "", "path to follows_list.txt (default: data-dir/follows_list.txt)")
+	countOnly := fs.Bool("count-only", false, "only print pair/relay/coverage counts; do not write any output files")
+	exportRelayAuthors := fs.String("export-relay-authors", "", "write per-relay author counts to data-dir/relay_authors.<format> (json or csv)")
+	exportIncludeAuthors := fs.Bool("export-include-authors", false, "include each relay's author list in --export-relay-authors output")
+	sqlitePath := fs.String("sqlite", "", "also add this run's write map, relay author counts and run metadata to an SQLite database at this path, for comparing runs")
+	excludeSoftware := fs.String("exclude-software", "", "comma-separated globs; drop relays whose NIP-11 software URL or name matches (e.g. '*nostr-rs-relay*')")
+	excludeNIP11 := fs.String("exclude-nip11", "", "comma-separated field=glob NIP-11 predicates; drop matching relays (e.g. 'version=0.8.*,payment_required=true')")
+	excludeNoNIP11 := fs.Bool("exclude-no-nip11", false, "drop relays that serve no NIP-11 document (a liveness heuristic; some live relays lack NIP-11)")
+	nip11Timeout := fs.Int("nip11-timeout", 5, "timeout in seconds for each NIP-11 fetch")
+	bootstrapExcludes := fs.String("bootstrap-excludes", "", "seed outbox_exclude.txt from known aggregator/broadcast relays: 'bundled' or an http(s) URL")
+	emptyMarkerMode := fs.String("empty-marker-mode", "write", "how r-tags without a read/write marker are classified: write, read or both")
+	readLegacy := fs.Bool("read-legacy", false, "count r-tags without a marker as read relays too (same as --empty-marker-mode both)")
+	flattenHosts := fs.String("flatten-paths", "", "comma-separated hosts whose path variants (wss://host/<npub>, ...) are merged into the bare host URL")
+	markerMapFlag := fs.String("marker-map", "", "comma-separated custom r-tag markers mapped to write, read or both before classification (e.g. 'outbox=write,inbox=read,rw=both')")
+	softwareReport := fs.Bool("software-breakdown", false, "write relay_software_breakdown.txt grouping outbox relays by NIP-11 software and version")
+	excludeMutedFlag := fs.Bool("exclude-muted", false, "drop authors in data-dir/mute_list.txt (from collect's kind 10000) from the write map, and relays left without authors")
+	mirrorTop := fs.Int("mirror-advice", 0, "write mirror_advice.txt: the N relays that, if you self-host mirrors of them, cover the most follows, with cumulative coverage (0 = off)")
+	followsByCoverage := fs.Bool("follows-by-coverage", false, "also write follows_by_coverage.txt: follows with their write relay count, fewest first, fragile ones flagged")
+	parallel := fs.Int("parallel", runtime.NumCPU(), "number of workers parsing input lines; output does not depend on it")
+	checkpointMB := fs.Int("checkpoint-mb", 0, "save scan progress to data-dir/analyze_checkpoint.json every N MB of local input, and resume from it after an interruption (0 = off)")
+	nip11CacheHours := fs.Int("nip11-cache-hours", 24, "reuse NIP-11 results in data-dir/relay_cache.json younger than this")
 	if err := fs.Parse(args); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to parse flags: %v\n", err)
 		os.Exit(1)
 	}
 
+	*exportRelayAuthors = strings.ToLower(*exportRelayAuthors)
+	if f := *exportRelayAuthors; f != "" && f != "json" && f != "csv" {
+		fmt.Fprintf(os.Stderr, "invalid --export-relay-authors %q (want json or csv)\n", f)
+		os.Exit(1)
+	}
+
 	dd := *dataDir
+	// A strfry export holds every author's events and must be read without
+	// touching the network
+	offline := *strfryExport != ""
+	if offline {
+		if *inputJSONL != "" {
+			fmt.Fprintln(os.Stderr, "--strfry-export and --input are mutually exclusive")
+			os.Exit(1)
+		}
+		if isHTTPInput(*strfryExport) {
+			fmt.Fprintln(os.Stderr, "--strfry-export must be a local file")
+			os.Exit(1)
+		}
+		if *checkMonitors || *pruneDead {
+			fmt.Fprintln(os.Stderr, "--check-monitors and --prune-dead query relays; they cannot be used with --strfry-export")
+			os.Exit(1)
+		}
+		if *bootstrapExcludes != "" && *bootstrapExcludes != "bundled" {
+			fmt.Fprintln(os.Stderr, "--strfry-export only accepts --bootstrap-excludes bundled")
+			os.Exit(1)
+		}
+		*inputJSONL = *strfryExport
+	}
 	if *inputJSONL == "" {
 		*inputJSONL = filepath.Join(dd, "all_relay_lists.jsonl")
 	}
@@ -98,82 +159,269 @@ func analyzeCmd(args []string) {
 		*followsFile = filepath.Join(dd, "follows_list.txt")
 	}
 	excludeFile := filepath.Join(dd, "outbox_exclude.txt")
+	*emptyMarkerMode = strings.ToLower(*emptyMarkerMode)
+	if *readLegacy {
+		explicit := false
+		fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "empty-marker-mode" })
+		if explicit && *emptyMarkerMode != "both" {
+			fmt.Fprintf(os.Stderr, "--read-legacy conflicts with --empty-marker-mode %s\n", *emptyMarkerMode)
+			os.Exit(1)
+		}
+		*emptyMarkerMode = "both"
+	}
+	switch *emptyMarkerMode {
+	case "write", "read", "both":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid --empty-marker-mode %q (want write, read or both)\n", *emptyMarkerMode)
+		os.Exit(1)
+	}
+	markerMap, err := parseMarkerMap(*markerMapFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--marker-map: %v\n", err)
+		os.Exit(1)
+	}
+	markersMapped := map[string]int{}
+	softwareGlobs := splitCSV(*excludeSoftware)
+	nip11Preds, err := parseNIP11Predicates(*excludeNIP11)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--exclude-nip11: %v\n", err)
+		os.Exit(1)
+	}
 	followSetsDir := filepath.Join(dd, "follow_sets")
 
-	// Merge follow sets from individual files if they exist
-	if err := mergeFollowSets(followSetsDir, *followsFile); err != nil {
-		fmt.Fprintf(os.Stderr, "warning: failed to merge follow sets: %v\n", err)
+	// Merge follow sets from individual files if they exist (skipped in count-only mode, which writes nothing)
+	if !*countOnly {
+		if err := mergeFollowSets(followSetsDir, *followsFile); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to merge follow sets: %v\n", err)
+		}
 	}
 
-	// Load excludes -> hosts set
-	exHosts := set{}
-	if lines, err := readLines(excludeFile); err == nil {
-		for _, l := range lines {
-			h := urlToHost(l)
-			if h != "" {
-				exHosts.add(h)
-			}
+	// Seed the exclude file from a bootstrap list, keeping existing entries.
+	// --count-only applies the new entries without writing them.
+	bootstrapAdded := -1
+	var bootstrapUnsaved []string
+	if *bootstrapExcludes != "" {
+		entries, err := loadBootstrapExcludes(ctx, *bootstrapExcludes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: --bootstrap-excludes: %v\n", err)
+		} else if *countOnly {
+			bootstrapUnsaved = newBootstrapExcludes(excludeFile, entries)
+		} else if added, err := mergeBootstrapExcludes(excludeFile, entries); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to update %s: %v\n", excludeFile, err)
+		} else {
+			bootstrapAdded = len(added)
 		}
 	}
 
-	// Parse JSONL 10002 events
-	in, err := os.Open(*inputJSONL)
+	// Load write- and read-scoped excludes -> hosts sets
+	exHosts, exReadHosts := loadExcludes(excludeFile)
+	unsavedWrite, unsavedRead := parseExcludes(bootstrapUnsaved)
+	for h := range unsavedWrite {
+		exHosts.add(h)
+	}
+	for h := range unsavedRead {
+		exReadHosts.add(h)
+	}
+
+	// Parse JSONL 10002 events (local file or http(s) URL, optionally gzipped)
+	in, err := openInput(ctx, *inputJSONL)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error opening %s: %v\n", *inputJSONL, err)
 		os.Exit(1)
 	}
-	defer in.Close()
+	defer func() { in.Close() }()
+
+	// A strfry export covers the whole store; keep only follows when known
+	var onlyAuthors map[string]struct{}
+	if offline {
+		if _, err := os.Stat(*followsFile); err == nil {
+			onlyAuthors = loadSetMust(*followsFile)
+			fmt.Printf("Reading relay lists of %d follows from strfry export %s\n", len(onlyAuthors), *strfryExport)
+		} else {
+			fmt.Fprintf(os.Stderr, "warning: %s not found; analyzing relay lists of every author in the export\n", *followsFile)
+		}
+	}
 
-	// Build WRITE map only (outbox): relay->set(pubkey)
-	writeMap := map[string]set{}
+	// Newest 10002 per author, and the older versions seen of it
+	lists := map[string]relayListVersion{}
+	older := map[string][]relayListVersion{}
+
+	// Resume an interrupted scan of a large local input from its checkpoint
+	var checkpoint *analyzeCheckpoint
+	var consumed int64
+	if *checkpointMB > 0 && *countOnly {
+		fmt.Fprintln(os.Stderr, "warning: --checkpoint-mb is ignored with --count-only")
+	} else if *checkpointMB > 0 && isHTTPInput(*inputJSONL) {
+		fmt.Fprintln(os.Stderr, "warning: --checkpoint-mb needs a local input; not checkpointing the download")
+	} else if *checkpointMB > 0 {
+		checkpointPath := filepath.Join(dd, "analyze_checkpoint.json")
+		settings := analyzeSettingsHash(*emptyMarkerMode, *markerMapFlag, exHosts, exReadHosts, onlyAuthors)
+		c, reason := loadAnalyzeCheckpoint(checkpointPath, *inputJSONL, settings)
+		if reason != "" {
+			fmt.Fprintf(os.Stderr, "warning: ignoring %s: %s\n", checkpointPath, reason)
+		}
+		if c != nil {
+			resumed, err := openInputAt(*inputJSONL, c.Offset)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: cannot resume from %s: %v\n", checkpointPath, err)
+				c = nil
+			} else {
+				in.Close()
+				in = resumed
+				c.restore(lists, older, markersMapped)
+				consumed = c.Offset
+				fmt.Printf("Resuming analyze at byte %d of %s (%d relay lists so far)\n",
+					c.Offset, *inputJSONL, len(lists))
+			}
+		}
+		if c == nil {
+			c = &analyzeCheckpoint{Input: *inputJSONL, Settings: settings, path: checkpointPath}
+		}
+		checkpoint = c
+	}
+	checkpointEvery := int64(*checkpointMB) << 20
 
+	parser := &relayListParser{onlyAuthors: onlyAuthors, exHosts: exHosts, exReadHosts: exReadHosts, emptyMarkerMode: *emptyMarkerMode, markerMap: markerMap}
+	scan := newRelayListScan(parser, *parallel, lists, older, markersMapped)
 	s := bufio.NewScanner(in)
+	s.Split(scanRawLines)
+	// Exports contain large events of other kinds (e.g. kind 3 follow lists)
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
 	for s.Scan() {
-		line := strings.TrimSpace(s.Text())
-		if line == "" || !strings.HasPrefix(line, "{") {
-			continue
-		}
-		var ev Event
-		if err := json.Unmarshal([]byte(line), &ev); err != nil {
-			continue
-		}
-		if ev.Kind != 10002 {
-			continue
+		if ctx.Err() != nil {
+			break
 		}
-		pk := strings.ToLower(ev.PubKey)
-		for _, tag := range ev.Tags {
-			if len(tag) >= 2 && tag[0] == "r" {
-				url := normalizeURL(tag[1])
-				if url == "" {
-					continue
-				}
-				host := urlToHost(url)
-				if exHosts.has(host) {
-					continue
-				}
-				// If the URL points to an inbox endpoint, skip it and prefer a different URL for outbox
-				if strings.Contains(url, "/inbox") {
-					continue
-				}
-				mode := ""
-				if len(tag) >= 3 {
-					mode = strings.ToLower(tag[2])
-				}
-				// Outbox rules:
-				// - mode=="write" => use url
-				// - mode==""      => use url (legacy implies outbox)
-				// - mode=="read"  => skip (inbox-only)
-				if mode == "write" || mode == "" {
-					if writeMap[url] == nil {
-						writeMap[url] = set{}
-					}
-					writeMap[url].add(pk)
+		raw := s.Bytes()
+		if checkpoint != nil {
+			// Everything before this line has been applied to the maps
+			if consumed-checkpoint.Offset >= checkpointEvery {
+				scan.wait()
+				if err := checkpoint.save(consumed, lists, older, markersMapped); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to save analyze checkpoint: %v\n", err)
 				}
 			}
+			consumed += int64(len(raw))
+			checkpoint.track(raw)
 		}
+		scan.add(raw)
 	}
+	scan.close()
+	// The checkpoint, if any, is kept so the next run resumes from it
+	exitIfInterrupted(ctx)
 	if err := s.Err(); err != nil {
 		fmt.Fprintf(os.Stderr, "scan error: %v\n", err)
+	} else if checkpoint != nil {
+		if err := checkpoint.remove(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove analyze checkpoint: %v\n", err)
+		}
+	}
+
+	// Build WRITE map only (outbox): relay->set(pubkey), from each author's newest 10002
+	writeMap := map[string]set{}
+	// Authors that published a 10002; relay hints are only used for the rest
+	haveRelayList := set{}
+	// First-listed write relay per author (NIP-65 order hints preference)
+	primary := map[string]string{}
+	for pk, v := range lists {
+		haveRelayList.add(pk)
+		for _, url := range v.URLs {
+			if writeMap[url] == nil {
+				writeMap[url] = set{}
+			}
+			writeMap[url].add(pk)
+		}
+		if len(v.URLs) > 0 {
+			primary[pk] = v.URLs[0]
+		}
+	}
+	// READ map (inbox): relay->set(pubkey), for notification streams
+	readMap := map[string]set{}
+	for pk, v := range lists {
+		for _, url := range v.ReadURLs {
+			if readMap[url] == nil {
+				readMap[url] = set{}
+			}
+			readMap[url].add(pk)
+		}
+	}
+
+	// Seed write relays from kind 3 p-tag hints for follows without a 10002
+	hintsUsed := applyRelayHints(filepath.Join(dd, "follow_relay_hints.txt"), writeMap, haveRelayList, exHosts)
+
+	// Drop muted authors, and relays that only served them
+	var mutedRemoved, mutedRelays []string
+	if *excludeMutedFlag {
+		mutePath := filepath.Join(dd, "mute_list.txt")
+		if muted, err := loadMuteList(mutePath); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: --exclude-muted set but %s is unreadable (run collect): %v\n", mutePath, err)
+		} else {
+			mutedRemoved, mutedRelays = excludeMuted(writeMap, primary, muted)
+			excludeMuted(readMap, map[string]string{}, muted)
+		}
+	}
+
+	// Collapse per-user paths of aggregators onto their bare host URL
+	flattened := 0
+	if *flattenHosts != "" {
+		hosts := make(set)
+		for _, h := range splitCSV(*flattenHosts) {
+			hosts.add(urlToHost(inferScheme(h)))
+		}
+		flattened = flattenPaths(writeMap, primary, hosts)
+	}
+
+	// Drop plaintext relays (from relay lists and hints alike)
+	var droppedWS []string
+	if *requireWSS {
+		for url := range writeMap {
+			if isPlaintextRelay(url) {
+				droppedWS = append(droppedWS, url)
+				delete(writeMap, url)
+			}
+		}
+		for pk, url := range primary {
+			if isPlaintextRelay(url) {
+				delete(primary, pk)
+			}
+		}
+		for url := range readMap {
+			if isPlaintextRelay(url) {
+				delete(readMap, url)
+			}
+		}
+		sort.Strings(droppedWS)
+	}
+
+	// Drop relays whose NIP-11 document matches --exclude-software / --exclude-nip11,
+	// or that serve none with --exclude-no-nip11
+	var nip11Excluded map[string]string
+	if len(softwareGlobs) > 0 || len(nip11Preds) > 0 || *excludeNoNIP11 {
+		cache := loadRelayInfoCache(filepath.Join(dd, "relay_cache.json"))
+		urls := make([]string, 0, len(writeMap))
+		for url := range writeMap {
+			urls = append(urls, url)
+		}
+		fetched := 0
+		if !offline {
+			fetched = cache.fetch(ctx, urls, 16, time.Duration(*nip11Timeout)*time.Second, time.Duration(*nip11CacheHours)*time.Hour)
+		}
+		// --count-only writes no files, the relay cache included
+		if fetched > 0 && !*countOnly {
+			if err := cache.save(); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to save relay cache: %v\n", err)
+			}
+		}
+		nip11Excluded = excludeByNIP11(writeMap, cache, softwareGlobs, nip11Preds, *excludeNoNIP11)
+		for pk, url := range primary {
+			if _, ok := nip11Excluded[url]; ok {
+				delete(primary, pk)
+			}
+		}
+	}
+
+	if *countOnly {
+		printWriteMapCounts(writeMap, *followsFile)
+		return
 	}
 
 	// Write pubkey_relays_map_write.txt (pubkey url pairs)
@@ -192,6 +440,90 @@ func analyzeCmd(args []string) {
 		panic(err)
 	}
 
+	// Write pubkey_relays_map_read.txt and inbox_relays.txt from the READ map
+	var readPairs []string
+	for url, users := range readMap {
+		for pk := range users {
+			readPairs = append(readPairs, fmt.Sprintf("%s %s", pk, url))
+		}
+	}
+	sort.Strings(readPairs)
+	if err := writeLines(filepath.Join(dd, "pubkey_relays_map_read.txt"), readPairs); err != nil {
+		panic(err)
+	}
+	inbox := uniqueByHost(readMap)
+	if err := writeLines(filepath.Join(dd, "inbox_relays.txt"), inbox); err != nil {
+		panic(err)
+	}
+
+	// Write pubkey_primary_relay.txt (pubkey url pairs, first-listed write relay)
+	var primaryPairs []string
+	for pk, url := range primary {
+		primaryPairs = append(primaryPairs, fmt.Sprintf("%s %s", pk, url))
+	}
+	sort.Strings(primaryPairs)
+	if err := writeLines(filepath.Join(dd, "pubkey_primary_relay.txt"), primaryPairs); err != nil {
+		panic(err)
+	}
+
+	// Write outbox_hints.json (follow -> ordered write relays, for clients)
+	hintsPath := filepath.Join(dd, "outbox_hints.json")
+	var hintFollows map[string]struct{}
+	if _, err := os.Stat(*followsFile); err == nil {
+		hintFollows = loadSetMust(*followsFile)
+	}
+	outboxHints := buildOutboxHints(writeMap, primary, hintFollows)
+	if err := writeOutboxHints(hintsPath, outboxHints); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write %s: %v\n", hintsPath, err)
+		hintsPath = ""
+	}
+
+	// Write relay_list_conflicts.txt (authors whose 10002 differed across source relays)
+	conflicts := relayListConflicts(lists, older)
+	conflictsPath := filepath.Join(dd, "relay_list_conflicts.txt")
+	if err := writeRelayListConflicts(conflictsPath, conflicts); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write %s: %v\n", conflictsPath, err)
+	}
+
+	// Write author_relay_count_histogram.txt (distribution of write relays per author)
+	histPath := filepath.Join(dd, "author_relay_count_histogram.txt")
+	singleRelay, histAuthors, err := writeRelayCountHistogram(histPath, writeMap, *followsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write relay count histogram: %v\n", err)
+		histPath = ""
+	}
+
+	// Companion to follows_list.txt for curation: fragile follows first
+	coveragePath := ""
+	fragile := 0
+	if *followsByCoverage {
+		coveragePath = filepath.Join(dd, "follows_by_coverage.txt")
+		names, _ := loadPubkeyNames(filepath.Join(dd, "pubkey_names.txt"))
+		if fragile, err = writeFollowsByCoverage(coveragePath, writeMap, *followsFile, names); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to write %s: %v\n", coveragePath, err)
+			coveragePath = ""
+		}
+	}
+
+	// Advisory: which few relays would cover the most follows if mirrored
+	mirrorPath := ""
+	var mirrorSteps []mirrorStep
+	mirrorFollows := 0
+	if *mirrorTop > 0 {
+		if _, err := os.Stat(*followsFile); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: --mirror-advice needs %s: %v\n", *followsFile, err)
+		} else {
+			follows := loadSetMust(*followsFile)
+			mirrorFollows = len(follows)
+			mirrorSteps = mirrorAdvice(writeMap, follows, *mirrorTop)
+			mirrorPath = filepath.Join(dd, "mirror_advice.txt")
+			if err := writeMirrorAdvice(mirrorPath, mirrorSteps, mirrorFollows); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to write %s: %v\n", mirrorPath, err)
+				mirrorPath = ""
+			}
+		}
+	}
+
 	// Derive outbox relays from WRITE map (unique URLs by host; excludes already applied)
 	outbox := uniqueByHost(writeMap)
 	if len(outbox) == 0 {
@@ -201,12 +533,119 @@ func analyzeCmd(args []string) {
 		panic(err)
 	}
 
+	// Group outbox relays by relay software (NIP-11, reusing relay_cache.json)
+	softwarePath := ""
+	var softwareBuckets []softwareBucket
+	if *softwareReport {
+		cache := loadRelayInfoCache(filepath.Join(dd, "relay_cache.json"))
+		if !offline && cache.fetch(ctx, outbox, 16, time.Duration(*nip11Timeout)*time.Second, time.Duration(*nip11CacheHours)*time.Hour) > 0 {
+			if err := cache.save(); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to save relay cache: %v\n", err)
+			}
+		}
+		softwareBuckets = softwareBreakdown(outbox, cache)
+		softwarePath = filepath.Join(dd, "relay_software_breakdown.txt")
+		if err := writeSoftwareBreakdown(softwarePath, softwareBuckets); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to write software breakdown: %v\n", err)
+			softwarePath = ""
+		}
+	}
+
+	exportPath := ""
+	if *exportRelayAuthors != "" {
+		exportPath = filepath.Join(dd, "relay_authors."+*exportRelayAuthors)
+		if err := writeRelayAuthorsExport(exportPath, *exportRelayAuthors, writeMap, *exportIncludeAuthors); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to export relay authors: %v\n", err)
+			exportPath = ""
+		}
+	}
+
+	sqliteRun := int64(0)
+	if *sqlitePath != "" {
+		run := analysisRun{at: time.Now(), input: *inputJSONL, emptyMarkerMode: *emptyMarkerMode,
+			writePairs: len(writePairs), readPairs: len(readPairs), outboxRelays: len(outbox), inboxRelays: len(inbox)}
+		if sqliteRun, err = writeAnalysisSQLite(*sqlitePath, run, writeMap); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to write %s: %v\n", *sqlitePath, err)
+		}
+	}
+
 	fmt.Println("Analyze complete.")
 	fmt.Printf(" - WRITE pairs: %d\n", len(writePairs))
 	fmt.Printf(" - Outbox relays: %d\n", len(outbox))
+	fmt.Printf(" - READ pairs: %d\n", len(readPairs))
+	fmt.Printf(" - Inbox relays: %d\n", len(inbox))
+	if bootstrapAdded >= 0 {
+		fmt.Printf(" - Bootstrap excludes added: %d\n", bootstrapAdded)
+	}
+	if hintsUsed > 0 {
+		fmt.Printf(" - Relay hints used: %d (follows without a 10002)\n", hintsUsed)
+	}
+	if len(markerMap) > 0 {
+		total := 0
+		for _, n := range markersMapped {
+			total += n
+		}
+		fmt.Printf(" - Custom markers mapped (--marker-map): %d\n", total)
+		for _, m := range sortedKeys(markerMap) {
+			fmt.Printf("    %s -> %s: %d\n", m, markerMap[m], markersMapped[m])
+		}
+	}
+	if *excludeMutedFlag {
+		fmt.Printf(" - Muted authors removed: %d (relays left empty and dropped: %d)\n", len(mutedRemoved), len(mutedRelays))
+		for _, url := range mutedRelays {
+			fmt.Printf("    ✗ %s\n", url)
+		}
+	}
+	if *flattenHosts != "" {
+		fmt.Printf(" - Path variants flattened to bare hosts: %d\n", flattened)
+	}
+	if hintsPath != "" {
+		fmt.Printf(" - Outbox hints: %d authors, %s\n", len(outboxHints), hintsPath)
+	}
+	if len(conflicts) > 0 {
+		fmt.Printf(" - Authors with conflicting relay lists: %d (newest used), details: %s\n", len(conflicts), conflictsPath)
+	}
+	if histPath != "" && histAuthors > 0 {
+		fmt.Printf(" - Single-relay authors: %d/%d (%.1f%%), histogram: %s\n",
+			singleRelay, histAuthors, float64(singleRelay)/float64(histAuthors)*100, histPath)
+	}
+	if mirrorPath != "" {
+		fmt.Printf(" - Self-hosting advice (%s): mirror these relays to cover your follows\n", mirrorPath)
+		for i, step := range mirrorSteps {
+			fmt.Printf("    %d. %s: +%d, if you mirror the top %d you'd cover %d/%d follows (%.1f%%)\n",
+				i+1, step.relay, step.added, i+1, step.cumulative, mirrorFollows, percent(step.cumulative, mirrorFollows))
+		}
+	}
+	if coveragePath != "" {
+		fmt.Printf(" - Follows with at most one write relay: %d, listed first in %s\n", fragile, coveragePath)
+	}
+	if exportPath != "" {
+		fmt.Printf(" - Relay authors export: %s\n", exportPath)
+	}
+	if sqliteRun > 0 {
+		fmt.Printf(" - SQLite: run %d added to %s\n", sqliteRun, *sqlitePath)
+	}
+	if softwarePath != "" {
+		fmt.Printf(" - Relay software buckets: %d, breakdown: %s\n", len(softwareBuckets), softwarePath)
+	}
+	if len(droppedWS) > 0 {
+		fmt.Printf(" - Dropped ws:// relays (--require-wss): %d\n", len(droppedWS))
+		for _, url := range droppedWS {
+			fmt.Printf("    ✗ %s\n", url)
+		}
+	}
+	if len(nip11Excluded) > 0 {
+		fmt.Printf(" - Excluded by NIP-11: %d relays\n", len(nip11Excluded))
+		for _, url := range sortedKeys(nip11Excluded) {
+			fmt.Printf("    ✗ %s (%s)\n", url, nip11Excluded[url])
+		}
+	}
+
+	// Relays dropped by the monitor check no longer count toward coverage
+	coverageMap := writeMap
 
 	// Optionally check relay monitors for liveness
-	if *checkMonitors {
+	if *checkMonitors || *pruneDead {
 		fmt.Println("\n==> Checking NIP-66 relay monitors...")
 		monitorRelayList := strings.Split(*monitorRelays, ",")
 		for i := range monitorRelayList {
@@ -219,7 +658,8 @@ func analyzeCmd(args []string) {
 			allRelays.add(normalizeURL(url))
 		}
 
-		monitorData := fetchNIP66MonitorData(monitorRelayList, allRelays, time.Duration(*monitorTimeout)*time.Second)
+		monitorData := fetchNIP66MonitorData(ctx, monitorRelayList, allRelays, time.Duration(*monitorTimeout)*time.Second)
+		exitIfInterrupted(ctx)
 
 		// Write monitoring report
 		reportPath := filepath.Join(dd, "relay_monitor_report.txt")
@@ -241,6 +681,22 @@ func analyzeCmd(args []string) {
 				onlineRelays.add(normalizeURL(url))
 			}
 		}
+		if *pruneDead {
+			// Combined rule: keep everything except relays both signals call dead
+			latencyPath := filepath.Join(dd, "relay_latency.txt")
+			probed, err := loadLatencyReport(latencyPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: no probe report at %s (run probe first); nothing will be pruned\n", latencyPath)
+			}
+			onlineRelays = pruneDeadRelays(writeMap, monitorData, probed)
+		}
+
+		coverageMap = make(map[string]set, len(onlineRelays))
+		for url, users := range writeMap {
+			if onlineRelays.has(normalizeURL(url)) {
+				coverageMap[url] = users
+			}
+		}
 
 		for _, pair := range writePairs {
 			fields := strings.Fields(pair)
@@ -257,10 +713,481 @@ func analyzeCmd(args []string) {
 		if err := writeLines(filteredMapPath, filteredPairs); err != nil {
 			fmt.Fprintf(os.Stderr, "warning: failed to write filtered relay map: %v\n", err)
 		} else {
-			fmt.Printf(" - Filtered map (online only): %s\n", filteredMapPath)
+			if *pruneDead {
+				fmt.Printf(" - Filtered map (dead relays pruned): %s\n", filteredMapPath)
+			} else {
+				fmt.Printf(" - Filtered map (online only): %s\n", filteredMapPath)
+			}
 			fmt.Printf(" - Filtered pairs: %d (from %d total)\n", len(filteredPairs), len(writePairs))
 		}
 	}
+
+	// Compare follow coverage after all filtering with the previous run
+	if hit, follows, err := followCoverage(coverageMap, *followsFile); err == nil && follows > 0 {
+		snapPath := filepath.Join(dd, "coverage_snapshot.json")
+		cur := coverageSnapshot{
+			Time:     time.Now().Unix(),
+			Follows:  follows,
+			Covered:  hit,
+			Coverage: percent(hit, follows),
+			Relays:   len(coverageMap),
+		}
+		printFollowCoverage(hit, follows)
+		regressed := false
+		if prev, err := loadCoverageSnapshot(snapPath); err == nil {
+			if drop := prev.Coverage - cur.Coverage; drop > *regressionDelta {
+				regressed = true
+				fmt.Fprintf(os.Stderr, "warning: follow coverage dropped %.1f points since %s (%.1f%% -> %.1f%%, relays %d -> %d)\n",
+					drop, time.Unix(prev.Time, 0).Format(time.RFC3339), prev.Coverage, cur.Coverage, prev.Relays, cur.Relays)
+			}
+		}
+		if regressed && *failOnRegression {
+			fmt.Fprintf(os.Stderr, "error: coverage regression; keeping %s from the previous run\n", snapPath)
+			os.Exit(2)
+		}
+		if err := saveCoverageSnapshot(snapPath, cur); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to save %s: %v\n", snapPath, err)
+		}
+	}
+}
+
+// pruneDeadRelays returns the relays of writeMap to keep: a relay is only
+// pruned when monitors report it offline and the local probe could not reach
+// it. Relays with one or no signal are kept. Decisions are printed.
+func pruneDeadRelays(writeMap map[string]set, monitorData map[string]*RelayMonitorInfo, probed map[string]bool) set {
+	keep := set{}
+	var pruned []string
+	noData, oneSignal, conflicting := 0, 0, 0
+	for url := range writeMap {
+		url = normalizeURL(url)
+		info := monitorData[url]
+		monitorKnown := info != nil && info.MonitorCount > 0
+		monitorOffline := monitorKnown && info.Status != "online"
+		reachable, probeKnown := probed[url]
+		switch {
+		case monitorOffline && probeKnown && !reachable:
+			pruned = append(pruned, url)
+			continue
+		case !monitorKnown && !probeKnown:
+			noData++
+		case !monitorKnown || !probeKnown:
+			oneSignal++
+		case monitorOffline != !reachable:
+			conflicting++
+		}
+		keep.add(url)
+	}
+	sort.Strings(pruned)
+	fmt.Printf(" - Prune dead: %d pruned, %d kept\n", len(pruned), len(keep))
+	for _, url := range pruned {
+		fmt.Printf("    ✗ %s (offline per %d monitors, unreachable per probe)\n", url, monitorData[url].MonitorCount)
+	}
+	if noData > 0 {
+		fmt.Printf("    kept %d relays with neither monitor nor probe data\n", noData)
+	}
+	if oneSignal > 0 {
+		fmt.Printf("    kept %d relays with only one liveness signal\n", oneSignal)
+	}
+	if conflicting > 0 {
+		fmt.Printf("    kept %d relays where monitors and probe disagree\n", conflicting)
+	}
+	return keep
+}
+
+// markerRoles classifies a NIP-65 r-tag marker as a write and/or read relay.
+// Markers other than "read"/"write" are treated as empty, which emptyMode
+// resolves: "write" (outbox only), "read" (inbox only) or "both".
+func markerRoles(marker, emptyMode string) (write, read bool) {
+	switch marker {
+	case "write":
+		return true, false
+	case "read":
+		return false, true
+	}
+	switch emptyMode {
+	case "read":
+		return false, true
+	case "both":
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// parseMarkerMap parses comma-separated marker=role pairs, where role is
+// write, read or both. Markers are matched case-insensitively.
+func parseMarkerMap(s string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, part := range splitCSV(s) {
+		marker, role, ok := strings.Cut(part, "=")
+		marker = strings.ToLower(strings.TrimSpace(marker))
+		role = strings.ToLower(strings.TrimSpace(role))
+		if !ok || marker == "" {
+			return nil, fmt.Errorf("invalid mapping %q (want marker=write|read|both)", part)
+		}
+		switch role {
+		case "write", "read", "both":
+		default:
+			return nil, fmt.Errorf("invalid role %q for marker %q (want write, read or both)", role, marker)
+		}
+		m[marker] = role
+	}
+	return m, nil
+}
+
+// classifyMarker applies markerMap to a custom marker, then classifies it
+// like markerRoles. mapped reports whether markerMap translated the marker.
+func classifyMarker(marker, emptyMode string, markerMap map[string]string) (write, read, mapped bool) {
+	role, ok := markerMap[marker]
+	if !ok {
+		write, read = markerRoles(marker, emptyMode)
+		return write, read, false
+	}
+	if role == "both" {
+		return true, true, true
+	}
+	write, read = markerRoles(role, emptyMode)
+	return write, read, true
+}
+
+// loadExcludes reads outbox_exclude.txt into write- and read-scoped host sets.
+// Each line is a relay URL or host, optionally followed by "write" or "read";
+// bare entries exclude the relay for both roles.
+func loadExcludes(path string) (write, read set) {
+	lines, err := readLines(path)
+	if err != nil {
+		return set{}, set{}
+	}
+	return parseExcludes(lines)
+}
+
+// parseExcludes reads exclude file lines (see loadExcludes) into host sets
+func parseExcludes(lines []string) (write, read set) {
+	write, read = set{}, set{}
+	for _, l := range lines {
+		// Allow trailing "# comment" after an entry
+		if i := strings.Index(l, "#"); i >= 0 {
+			l = l[:i]
+		}
+		fields := strings.Fields(l)
+		if len(fields) == 0 {
+			continue
+		}
+		h := urlToHost(fields[0])
+		if h == "" {
+			continue
+		}
+		scope := ""
+		if len(fields) >= 2 {
+			scope = strings.ToLower(fields[1])
+		}
+		switch scope {
+		case "write":
+			write.add(h)
+		case "read":
+			read.add(h)
+		case "":
+			write.add(h)
+			read.add(h)
+		default:
+			fmt.Fprintf(os.Stderr, "warning: unknown exclude scope %q for %s (want read or write), excluding both\n", scope, fields[0])
+			write.add(h)
+			read.add(h)
+		}
+	}
+	return write, read
+}
+
+// applyRelayHints adds "pubkey url" hints from collect to the write map for authors
+// that have no 10002 of their own. It returns the number of hints applied.
+func applyRelayHints(path string, writeMap map[string]set, haveRelayList, exHosts set) int {
+	lines, err := readLines(path)
+	if err != nil {
+		return 0
+	}
+	used := 0
+	for _, l := range lines {
+		fields := strings.Fields(l)
+		if len(fields) < 2 {
+			continue
+		}
+		pk := strings.ToLower(fields[0])
+		url := normalizeURL(fields[1])
+		if !isHex64(pk) || haveRelayList.has(pk) || !isValidRelayURL(url) {
+			continue
+		}
+		if exHosts.has(urlToHost(url)) || strings.Contains(url, "/inbox") {
+			continue
+		}
+		if writeMap[url] == nil {
+			writeMap[url] = set{}
+		}
+		writeMap[url].add(pk)
+		used++
+	}
+	return used
+}
+
+// relayAuthorsEntry is one row of the relay authors export
+type relayAuthorsEntry struct {
+	Relay       string   `json:"relay"`
+	AuthorCount int      `json:"author_count"`
+	Authors     []string `json:"authors,omitempty"`
+}
+
+// writeRelayAuthorsExport writes each relay with its author count (and optionally
+// its sorted authors) as JSON or CSV, most popular relays first
+func writeRelayAuthorsExport(path, format string, writeMap map[string]set, includeAuthors bool) error {
+	var entries []relayAuthorsEntry
+	for url, users := range writeMap {
+		e := relayAuthorsEntry{Relay: url, AuthorCount: len(users)}
+		if includeAuthors {
+			for pk := range users {
+				e.Authors = append(e.Authors, pk)
+			}
+			sort.Strings(e.Authors)
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].AuthorCount != entries[j].AuthorCount {
+			return entries[i].AuthorCount > entries[j].AuthorCount
+		}
+		return entries[i].Relay < entries[j].Relay
+	})
+
+	switch format {
+	case "json":
+		b, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, append(b, '\n'), 0o644)
+	case "csv":
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w := csv.NewWriter(f)
+		header := []string{"relay", "author_count"}
+		if includeAuthors {
+			header = append(header, "authors")
+		}
+		w.Write(header)
+		for _, e := range entries {
+			row := []string{e.Relay, strconv.Itoa(e.AuthorCount)}
+			if includeAuthors {
+				row = append(row, strings.Join(e.Authors, " "))
+			}
+			w.Write(row)
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		return fmt.Errorf("unknown export format %q (want json or csv)", format)
+	}
+}
+
+// printWriteMapCounts prints pair, relay and follow coverage counts for the write map
+func printWriteMapCounts(writeMap map[string]set, followsFile string) {
+	pairs := 0
+	covered := set{}
+	for _, users := range writeMap {
+		pairs += len(users)
+		for pk := range users {
+			covered.add(pk)
+		}
+	}
+	fmt.Println("Analyze (count only):")
+	fmt.Printf(" - WRITE pairs: %d\n", pairs)
+	fmt.Printf(" - Unique relays: %d\n", len(writeMap))
+	fmt.Printf(" - Authors with a write relay: %d\n", len(covered))
+
+	hit, follows, err := followCoverage(writeMap, followsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: cannot read %s, skipping coverage: %v\n", followsFile, err)
+		return
+	}
+	printFollowCoverage(hit, follows)
+}
+
+func printFollowCoverage(hit, follows int) {
+	fmt.Printf(" - Follow coverage: %d/%d (%.1f%%)\n", hit, follows, percent(hit, follows))
+}
+
+// followCoverage counts the follows that have at least one write relay
+func followCoverage(writeMap map[string]set, followsFile string) (hit, follows int, err error) {
+	lines, err := readLines(followsFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	covered := set{}
+	for _, users := range writeMap {
+		for pk := range users {
+			covered.add(pk)
+		}
+	}
+	for _, l := range lines {
+		l = strings.ToLower(l)
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
+		follows++
+		if covered.has(l) {
+			hit++
+		}
+	}
+	return hit, follows, nil
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
+
+// coverageSnapshot is the follow coverage of one analyze run, kept in
+// coverage_snapshot.json to detect regressions on the next run
+type coverageSnapshot struct {
+	Time     int64   `json:"time"`
+	Follows  int     `json:"follows"`
+	Covered  int     `json:"covered"`
+	Coverage float64 `json:"coverage_pct"`
+	Relays   int     `json:"relays"`
+}
+
+func loadCoverageSnapshot(path string) (*coverageSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap coverageSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+func saveCoverageSnapshot(path string, snap coverageSnapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFollowsByCoverage writes every follow with its number of write relays,
+// fewest first, flagging follows with none or one. names (may be nil) adds
+// display names from collect --fetch-profiles. It returns how many follows
+// have at most one relay.
+func writeFollowsByCoverage(path string, writeMap map[string]set, followsFile string, names map[string]string) (int, error) {
+	follows, err := readLines(followsFile)
+	if err != nil {
+		return 0, err
+	}
+	perAuthor := map[string]int{}
+	for _, users := range writeMap {
+		for pk := range users {
+			perAuthor[pk]++
+		}
+	}
+	var pks []string
+	for _, l := range follows {
+		l = strings.ToLower(strings.TrimSpace(l))
+		if l != "" && !strings.HasPrefix(l, "#") {
+			pks = append(pks, l)
+		}
+	}
+	pks = uniqueSorted(pks)
+	sort.SliceStable(pks, func(i, j int) bool { return perAuthor[pks[i]] < perAuthor[pks[j]] })
+
+	lines := []string{
+		"# Follows by number of write relays, fewest first",
+		"# Format: relays | pubkey | name | flag",
+		"",
+	}
+	fragile := 0
+	for _, pk := range pks {
+		flag := ""
+		switch perAuthor[pk] {
+		case 0:
+			flag = "no relay"
+		case 1:
+			flag = "single relay"
+		}
+		if flag != "" {
+			fragile++
+		}
+		lines = append(lines, fmt.Sprintf("%d | %s | %s | %s", perAuthor[pk], pk, names[pk], flag))
+	}
+	return fragile, writeLines(path, lines)
+}
+
+// relayCountBuckets are the histogram buckets for write relays per author
+var relayCountBuckets = []struct {
+	label    string
+	min, max int
+}{
+	{"0", 0, 0},
+	{"1", 1, 1},
+	{"2-3", 2, 3},
+	{"4-5", 4, 5},
+	{"6-10", 6, 10},
+	{"11+", 11, int(^uint(0) >> 1)},
+}
+
+// writeRelayCountHistogram groups the write map by author and writes how many
+// authors have 1, 2-3, ... write relays. Follows without any write relay are
+// counted in the 0 bucket when the follows file is readable. Returns the
+// number of single-relay authors and the total number of authors counted.
+func writeRelayCountHistogram(path string, writeMap map[string]set, followsFile string) (int, int, error) {
+	perAuthor := map[string]int{}
+	for _, users := range writeMap {
+		for pk := range users {
+			perAuthor[pk]++
+		}
+	}
+	if lines, err := readLines(followsFile); err == nil {
+		for _, l := range lines {
+			l = strings.ToLower(l)
+			if strings.HasPrefix(l, "#") {
+				continue
+			}
+			if _, ok := perAuthor[l]; !ok {
+				perAuthor[l] = 0
+			}
+		}
+	}
+
+	counts := make([]int, len(relayCountBuckets))
+	for _, n := range perAuthor {
+		for i, b := range relayCountBuckets {
+			if n >= b.min && n <= b.max {
+				counts[i]++
+				break
+			}
+		}
+	}
+
+	total := len(perAuthor)
+	lines := []string{
+		"# Write relays per author",
+		"# Format: relays | authors | percent",
+		"",
+	}
+	for i, b := range relayCountBuckets {
+		pct := 0.0
+		if total > 0 {
+			pct = float64(counts[i]) / float64(total) * 100
+		}
+		lines = append(lines, fmt.Sprintf("%s | %d | %.1f%%", b.label, counts[i], pct))
+	}
+	if err := writeLines(path, lines); err != nil {
+		return 0, 0, err
+	}
+	return counts[1], total, nil
 }
 
 // RelayMonitorInfo holds NIP-66 monitoring data for a relay
@@ -281,7 +1208,7 @@ type MonitorInfo struct {
 }
 
 // fetchMonitorInfo queries for kind 10166 monitor announcements to get check frequencies
-func fetchMonitorInfo(monitorRelays []string, timeout time.Duration) map[string]*MonitorInfo {
+func fetchMonitorInfo(ctx context.Context, monitorRelays []string, timeout time.Duration) map[string]*MonitorInfo {
 	monitors := make(map[string]*MonitorInfo)
 
 	fmt.Println("    Fetching monitor announcements (kind 10166)...")
@@ -292,7 +1219,7 @@ func fetchMonitorInfo(monitorRelays []string, timeout time.Duration) map[string]
 		}
 
 		fmt.Printf("      Querying %s for monitor info...\n", monitorRelay)
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		ctx, cancel := context.WithTimeout(ctx, timeout)
 
 		relay, err := nostr.RelayConnect(ctx, monitorRelay)
 		if err != nil {
@@ -363,7 +1290,7 @@ func fetchMonitorInfo(monitorRelays []string, timeout time.Duration) map[string]
 }
 
 // fetchNIP66MonitorData queries monitor relays for kind 30166 events
-func fetchNIP66MonitorData(monitorRelays []string, targetRelays set, timeout time.Duration) map[string]*RelayMonitorInfo {
+func fetchNIP66MonitorData(ctx context.Context, monitorRelays []string, targetRelays set, timeout time.Duration) map[string]*RelayMonitorInfo {
 	result := make(map[string]*RelayMonitorInfo)
 
 	// Initialize all target relays as unknown
@@ -375,7 +1302,7 @@ func fetchNIP66MonitorData(monitorRelays []string, targetRelays set, timeout tim
 	}
 
 	// First, fetch monitor info (kind 10166) to get frequencies
-	monitors := fetchMonitorInfo(monitorRelays, timeout)
+	monitors := fetchMonitorInfo(ctx, monitorRelays, timeout)
 	fmt.Printf("    Found %d monitors with frequency data\n", len(monitors))
 
 	// Convert target relays to slice for filter
@@ -401,7 +1328,7 @@ func fetchNIP66MonitorData(monitorRelays []string, targetRelays set, timeout tim
 		}
 
 		// Each monitor relay gets its own timeout
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		ctx, cancel := context.WithTimeout(ctx, timeout)
 
 		fmt.Printf("    Connecting to %s...\n", monitorRelay)
 		relay, err := nostr.RelayConnect(ctx, monitorRelay)
@@ -642,28 +1569,77 @@ func countByStatus(data map[string]*RelayMonitorInfo, status string) int {
 	return count
 }
 
+// flattenPaths merges every write map URL with a path on one of hosts into
+// its bare scheme://host URL, updating primary relays to match. Returns the
+// number of path URLs merged.
+func flattenPaths(writeMap map[string]set, primary map[string]string, hosts set) int {
+	bare := func(url string) string {
+		return strings.TrimSuffix(url, urlPath(url))
+	}
+	n := 0
+	for url, users := range writeMap {
+		if urlPath(url) == "" || !hosts.has(urlToHost(url)) {
+			continue
+		}
+		b := bare(url)
+		if writeMap[b] == nil {
+			writeMap[b] = set{}
+		}
+		for pk := range users {
+			writeMap[b].add(pk)
+		}
+		delete(writeMap, url)
+		n++
+	}
+	for pk, url := range primary {
+		if urlPath(url) != "" && hosts.has(urlToHost(url)) {
+			primary[pk] = bare(url)
+		}
+	}
+	return n
+}
+
 func uniqueByHost(relayMap map[string]set) []string {
-	have := set{}
-	var out []string
-	var urls []string
+	best := make(map[string]string)
 	for url := range relayMap {
-		urls = append(urls, url)
-	}
-	sort.Strings(urls)
-	for _, url := range urls {
 		h := urlToHost(url)
 		if h == "" {
 			continue
 		}
-		if have.has(h) {
-			continue
+		if cur, ok := best[h]; !ok || preferHostURL(url, cur) {
+			best[h] = url
 		}
-		have.add(h)
+	}
+	out := make([]string, 0, len(best))
+	for _, url := range best {
 		out = append(out, url)
 	}
+	sort.Strings(out)
 	return out
 }
 
+// preferHostURL reports whether a is a better representative for its host
+// than b: the secure wss:// scheme wins, then the shorter path (the bare host
+// URL first), then the lexically smaller URL so the choice is deterministic
+func preferHostURL(a, b string) bool {
+	if sa, sb := !isPlaintextRelay(a), !isPlaintextRelay(b); sa != sb {
+		return sa
+	}
+	if pa, pb := len(urlPath(a)), len(urlPath(b)); pa != pb {
+		return pa < pb
+	}
+	return a < b
+}
+
+// urlPath returns everything after the host part of a relay URL
+func urlPath(u string) string {
+	u = strings.TrimPrefix(strings.TrimPrefix(u, "wss://"), "ws://")
+	if i := strings.IndexAny(u, "/?#"); i >= 0 {
+		return u[i:]
+	}
+	return ""
+}
+
 // mergeFollowSets reads individual follow set files and merges them with the main follows list
 func mergeFollowSets(followSetsDir, followsFile string) error {
 	// Check if follow_sets directory exists
@@ -688,15 +1664,37 @@ func mergeFollowSets(followSetsDir, followsFile string) error {
 		return fmt.Errorf("failed to read follow_sets directory: %w", err)
 	}
 
+	// collect --only-follow-set leaves a marker naming the one set to merge
+	onlyStem := ""
+	if data, err := os.ReadFile(filepath.Join(followSetsDir, onlyFollowSetFile)); err == nil {
+		onlyStem = "follow_set_" + followSetName(string(data))
+	}
+
 	setsFound := 0
 	pubkeysAdded := 0
 	for _, entry := range entries {
-		if entry.IsDir() || !strings.HasPrefix(entry.Name(), "follow_set_") || !strings.HasSuffix(entry.Name(), ".txt") {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), "follow_set_") {
+			continue
+		}
+		if onlyStem != "" && strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())) != onlyStem {
 			continue
 		}
 
 		setPath := filepath.Join(followSetsDir, entry.Name())
-		lines, err := readLines(setPath)
+		var lines []string
+		var err error
+		switch filepath.Ext(entry.Name()) {
+		case ".txt":
+			lines, err = readLines(setPath)
+		case ".json":
+			// In "both" format the .txt twin already covers this set
+			if _, statErr := os.Stat(strings.TrimSuffix(setPath, ".json") + ".txt"); statErr == nil {
+				continue
+			}
+			lines, err = readFollowSetJSON(setPath)
+		default:
+			continue
+		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "warning: failed to read %s: %v\n", entry.Name(), err)
 			continue
@@ -732,3 +1730,16 @@ func mergeFollowSets(followSetsDir, followsFile string) error {
 
 	return nil
 }
+
+// readFollowSetJSON returns the pubkeys of a follow set saved in JSON form
+func readFollowSetJSON(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fs followSetJSON
+	if err := json.Unmarshal(data, &fs); err != nil {
+		return nil, err
+	}
+	return fs.Pubkeys, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// checkpointTailBytes is how much input at the start and before the offset is
+// fingerprinted, so a resume notices when the file was replaced or rewritten
+const checkpointTailBytes = 4096
+
+// analyzeCheckpoint holds the state of an interrupted analyze scan: the
+// relay lists read so far and the input offset they cover
+type analyzeCheckpoint struct {
+	Input    string                        `json:"input"`
+	Settings string                        `json:"settings"`
+	Offset   int64                         `json:"offset"`
+	HeadHash string                        `json:"head_hash"`
+	TailHash string                        `json:"tail_hash"`
+	Lists    map[string]relayListVersion   `json:"lists"`
+	Older    map[string][]relayListVersion `json:"older"`
+	Mapped   map[string]int                `json:"markers_mapped"`
+
+	path string
+	// head and tail hold the first and the latest input bytes scanned
+	head, tail []byte
+}
+
+// analyzeSettingsHash fingerprints the options that shape the write map, so a
+// checkpoint is only reused by a run that would build the same map
+func analyzeSettingsHash(emptyMarkerMode, markerMap string, exHosts, exReadHosts, onlyAuthors set) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", emptyMarkerMode, markerMap)
+	for _, s := range []set{exHosts, exReadHosts, onlyAuthors} {
+		keys := make([]string, 0, len(s))
+		for k := range s {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(h, "%d:%s\n", len(s), strings.Join(keys, ","))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// loadAnalyzeCheckpoint reads the checkpoint at path and checks it against the
+// input: same path and settings, and the bytes before the offset unchanged.
+// It returns nil (with the reason) when there is nothing valid to resume.
+func loadAnalyzeCheckpoint(path, input, settings string) (*analyzeCheckpoint, string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, ""
+	}
+	var c analyzeCheckpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Sprintf("unreadable checkpoint: %v", err)
+	}
+	c.path = path
+	if c.Input != input {
+		return nil, fmt.Sprintf("checkpoint is for input %s", c.Input)
+	}
+	if c.Settings != settings {
+		return nil, "checkpoint was written with different marker, exclude or follows settings"
+	}
+	if c.Offset <= 0 {
+		return nil, "checkpoint has no progress"
+	}
+	head, tail, err := inputHashes(input, c.Offset)
+	if err != nil {
+		return nil, fmt.Sprintf("cannot validate offset %d: %v", c.Offset, err)
+	}
+	if head != c.HeadHash || tail != c.TailHash {
+		return nil, fmt.Sprintf("input changed before offset %d", c.Offset)
+	}
+	return &c, ""
+}
+
+// inputHashes hashes the first checkpointTailBytes of input and those ending
+// at offset. The last byte must end a line, or the offset does not fall
+// between events.
+func inputHashes(input string, offset int64) (head, tail string, err error) {
+	readAt := func(start, n int64) ([]byte, error) {
+		in, err := openInputAt(input, start)
+		if err != nil {
+			return nil, err
+		}
+		defer in.Close()
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(in, buf); err != nil {
+			return nil, fmt.Errorf("input is shorter than the offset: %w", err)
+		}
+		return buf, nil
+	}
+	headLen := int64(checkpointTailBytes)
+	if offset < headLen {
+		headLen = offset
+	}
+	headBytes, err := readAt(0, headLen)
+	if err != nil {
+		return "", "", err
+	}
+	start := max(0, offset-checkpointTailBytes)
+	tailBytes, err := readAt(start, offset-start)
+	if err != nil {
+		return "", "", err
+	}
+	if !bytes.HasSuffix(tailBytes, []byte("\n")) {
+		return "", "", fmt.Errorf("offset is not at a line boundary")
+	}
+	return hashBytes(headBytes), hashBytes(tailBytes), nil
+}
+
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// track records a scanned line for the head and tail fingerprints
+func (c *analyzeCheckpoint) track(raw []byte) {
+	if len(c.head) < checkpointTailBytes {
+		n := min(len(raw), checkpointTailBytes-len(c.head))
+		c.head = append(c.head, raw[:n]...)
+	}
+	c.tail = append(c.tail, raw...)
+	if len(c.tail) > 2*checkpointTailBytes {
+		c.tail = append([]byte(nil), c.tail[len(c.tail)-checkpointTailBytes:]...)
+	}
+}
+
+// scanRawLines is bufio.ScanLines but keeps the line terminator, so the
+// caller can count input bytes and fingerprint them
+func scanRawLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, _, err := bufio.ScanLines(data, atEOF)
+	if advance == 0 {
+		return 0, nil, err
+	}
+	return advance, data[:advance], err
+}
+
+// openInputAt opens a local input positioned at offset. Plain files are
+// seeked; gzipped inputs are decompressed and skipped up to the offset.
+func openInputAt(path string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 2)
+	n, _ := io.ReadFull(f, magic)
+	if n < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &readCloser{Reader: bufio.NewReader(f), closers: []io.Closer{f}}, nil
+	}
+	f.Close()
+	in, err := openInput(context.Background(), path) // a local file, never fetched
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, in, offset); err != nil {
+		in.Close()
+		return nil, err
+	}
+	return in, nil
+}
+
+// save records the scan state up to offset, the end of the last tracked
+// line, atomically via a temp file
+func (c *analyzeCheckpoint) save(offset int64, lists map[string]relayListVersion, older map[string][]relayListVersion, mapped map[string]int) error {
+	// A resumed run keeps the head hash it was validated against
+	if len(c.head) > 0 {
+		c.HeadHash = hashBytes(c.head)
+	}
+	c.Offset, c.TailHash = offset, hashBytes(c.tail[max(0, len(c.tail)-checkpointTailBytes):])
+	c.Lists, c.Older, c.Mapped = lists, older, mapped
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, data)
+}
+
+// restore copies the checkpointed scan state into the analyze maps
+func (c *analyzeCheckpoint) restore(lists map[string]relayListVersion, older map[string][]relayListVersion, mapped map[string]int) {
+	for pk, v := range c.Lists {
+		lists[pk] = v
+	}
+	for pk, vs := range c.Older {
+		older[pk] = vs
+	}
+	for m, n := range c.Mapped {
+		mapped[m] = n
+	}
+}
+
+// remove deletes the checkpoint file after a complete scan
+func (c *analyzeCheckpoint) remove() error {
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// setSorted returns the members of s in sorted order
+func setSorted(s set) []string {
+	out := make([]string, 0, len(s))
+	for v := range s {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out
+}
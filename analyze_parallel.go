@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// parseBatchLines is how many input lines are handed to a worker at a time
+const parseBatchLines = 512
+
+// relayListParser turns input lines into relay list versions. It only reads
+// its fields, so the parse workers share one.
+type relayListParser struct {
+	onlyAuthors     map[string]struct{}
+	exHosts         set
+	exReadHosts     set
+	emptyMarkerMode string
+	markerMap       map[string]string
+}
+
+// parsedRelayList is one kind 10002 event reduced to its write and read relays
+type parsedRelayList struct {
+	pk      string
+	version relayListVersion
+}
+
+// parse reads one JSONL line. ok is false for blank lines, other kinds,
+// unparseable events and authors outside onlyAuthors. Custom markers that
+// were translated are counted in mapped.
+func (p *relayListParser) parse(raw []byte, mapped map[string]int) (parsedRelayList, bool) {
+	line := bytes.TrimSpace(raw)
+	if len(line) == 0 || line[0] != '{' {
+		return parsedRelayList{}, false
+	}
+	ev, err := parseEventLine(line)
+	if err != nil || ev.Kind != 10002 {
+		return parsedRelayList{}, false
+	}
+	pk := strings.ToLower(ev.PubKey)
+	if p.onlyAuthors != nil {
+		if _, ok := p.onlyAuthors[pk]; !ok {
+			return parsedRelayList{}, false
+		}
+	}
+	version := relayListVersion{ID: strings.ToLower(ev.ID), CreatedAt: ev.CreatedAt, SeenOn: uniqueSorted(ev.SeenOn)}
+	listed, readListed := set{}, set{}
+	for _, tag := range ev.Tags {
+		if len(tag) >= 2 && tag[0] == "r" {
+			// Same key collect and gen-router use; tags that are no ws(s) relay URL are skipped
+			url := normalizeURL(tag[1])
+			if !isValidRelayURL(url) {
+				continue
+			}
+			host := urlToHost(url)
+			mode := ""
+			if len(tag) >= 3 {
+				mode = strings.ToLower(tag[2])
+			}
+			// Marker rules:
+			// - mode=="write" => outbox
+			// - mode=="read"  => inbox
+			// - mode==""      => per --empty-marker-mode (write, read or both)
+			// - custom markers are first translated by --marker-map
+			isWrite, isRead, wasMapped := classifyMarker(mode, p.emptyMarkerMode, p.markerMap)
+			if wasMapped {
+				mapped[mode]++
+			}
+			// If the URL points to an inbox endpoint, skip it and prefer a different URL for outbox
+			if isWrite && !p.exHosts.has(host) && !strings.Contains(url, "/inbox") && !listed.has(url) {
+				listed.add(url)
+				version.URLs = append(version.URLs, url)
+			}
+			// Likewise skip outbox endpoints for inbox
+			if isRead && !p.exReadHosts.has(host) && !strings.Contains(url, "/outbox") && !readListed.has(url) {
+				readListed.add(url)
+				version.ReadURLs = append(version.ReadURLs, url)
+			}
+		}
+	}
+	return parsedRelayList{pk: pk, version: version}, true
+}
+
+// relayListBatch is what a worker made of one batch of lines
+type relayListBatch struct {
+	lists  []parsedRelayList
+	mapped map[string]int
+}
+
+// relayListScan parses input lines on several workers and merges the results
+// into the analyze maps from a single goroutine. addRelayListVersion does not
+// depend on the order versions arrive in, so the maps end up the same as with
+// one worker.
+type relayListScan struct {
+	parser  *relayListParser
+	jobs    chan [][]byte
+	results chan relayListBatch
+	batch   [][]byte
+	// pending counts batches handed out but not yet merged
+	pending sync.WaitGroup
+	workers sync.WaitGroup
+	merged  chan struct{}
+
+	lists  map[string]relayListVersion
+	older  map[string][]relayListVersion
+	mapped map[string]int
+}
+
+func newRelayListScan(parser *relayListParser, workers int, lists map[string]relayListVersion, older map[string][]relayListVersion, mapped map[string]int) *relayListScan {
+	workers = max(1, workers)
+	ps := &relayListScan{
+		parser:  parser,
+		jobs:    make(chan [][]byte, workers),
+		results: make(chan relayListBatch, workers),
+		merged:  make(chan struct{}),
+		lists:   lists,
+		older:   older,
+		mapped:  mapped,
+	}
+	for i := 0; i < workers; i++ {
+		ps.workers.Add(1)
+		go ps.work()
+	}
+	go ps.merge()
+	return ps
+}
+
+func (ps *relayListScan) work() {
+	defer ps.workers.Done()
+	for lines := range ps.jobs {
+		out := relayListBatch{mapped: map[string]int{}}
+		for _, raw := range lines {
+			if l, ok := ps.parser.parse(raw, out.mapped); ok {
+				out.lists = append(out.lists, l)
+			}
+		}
+		ps.results <- out
+	}
+}
+
+func (ps *relayListScan) merge() {
+	defer close(ps.merged)
+	for b := range ps.results {
+		for _, l := range b.lists {
+			addRelayListVersion(ps.lists, ps.older, l.pk, l.version)
+		}
+		for m, n := range b.mapped {
+			ps.mapped[m] += n
+		}
+		ps.pending.Done()
+	}
+}
+
+// add queues a line; raw is copied, so the caller may reuse its buffer
+func (ps *relayListScan) add(raw []byte) {
+	ps.batch = append(ps.batch, append([]byte(nil), raw...))
+	if len(ps.batch) >= parseBatchLines {
+		ps.flush()
+	}
+}
+
+func (ps *relayListScan) flush() {
+	if len(ps.batch) == 0 {
+		return
+	}
+	ps.pending.Add(1)
+	ps.jobs <- ps.batch
+	ps.batch = nil
+}
+
+// wait returns once every line added so far is merged into the maps, e.g.
+// before they are checkpointed
+func (ps *relayListScan) wait() {
+	ps.flush()
+	ps.pending.Wait()
+}
+
+// close merges the remaining lines and stops the workers
+func (ps *relayListScan) close() {
+	ps.wait()
+	close(ps.jobs)
+	ps.workers.Wait()
+	close(ps.results)
+	<-ps.merged
+}
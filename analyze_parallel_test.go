@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+// relayListTestLines returns n JSONL lines of kind 10002 events by a few
+// hundred authors, with repeated and older versions, events seen on several
+// relays, custom markers and lines analyze skips
+func relayListTestLines(n int) [][]byte {
+	rng := rand.New(rand.NewSource(1))
+	markers := []string{"", "write", "read", "outbox", "inbox"}
+	lines := make([][]byte, 0, n)
+	var events []string
+	for i := 0; i < n; i++ {
+		switch i % 97 {
+		case 0:
+			lines = append(lines, []byte(""))
+			continue
+		case 1:
+			lines = append(lines, []byte(`{"kind":3,"id":"x","pubkey":"p","created_at":1,"tags":[]}`))
+			continue
+		case 2:
+			lines = append(lines, []byte(`{not json`))
+			continue
+		}
+		var event string
+		if len(events) > 0 && rng.Intn(4) == 0 {
+			// The same event again, e.g. from another relay
+			event = events[rng.Intn(len(events))]
+		} else {
+			event = randomRelayList(rng, markers)
+			events = append(events, event)
+		}
+		line := event
+		if rng.Intn(3) == 0 {
+			// As received from a relay, in the envelope form
+			line = fmt.Sprintf(`{"relay":"wss://source%d.example.com","event":%s}`, rng.Intn(5), event)
+		}
+		lines = append(lines, []byte(line))
+	}
+	return lines
+}
+
+// randomRelayList returns a kind 10002 event whose ID is derived from its
+// content, like a real event ID
+func randomRelayList(rng *rand.Rand, markers []string) string {
+	pk := fmt.Sprintf("%064x", rng.Intn(300))
+	// Few distinct timestamps per author, so ties are common
+	createdAt := 1000 + rng.Intn(4)
+	tags := ""
+	for j, k := 0, 1+rng.Intn(4); j < k; j++ {
+		if j > 0 {
+			tags += ","
+		}
+		marker := markers[rng.Intn(len(markers))]
+		url := fmt.Sprintf("wss://relay%d.example.com", rng.Intn(40))
+		if marker == "" {
+			tags += fmt.Sprintf(`["r","%s"]`, url)
+		} else {
+			tags += fmt.Sprintf(`["r","%s","%s"]`, url, marker)
+		}
+	}
+	id := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s", pk, createdAt, tags)))
+	return fmt.Sprintf(`{"kind":10002,"id":"%x","pubkey":"%s","created_at":%d,"tags":[%s]}`, id, pk, createdAt, tags)
+}
+
+func scanRelayLists(lines [][]byte, workers int) (map[string]relayListVersion, map[string][]relayListVersion, map[string]int) {
+	parser := &relayListParser{
+		exHosts:         set{"relay3.example.com": {}},
+		exReadHosts:     set{"relay4.example.com": {}},
+		emptyMarkerMode: "both",
+		markerMap:       map[string]string{"outbox": "write", "inbox": "read"},
+	}
+	lists := map[string]relayListVersion{}
+	older := map[string][]relayListVersion{}
+	mapped := map[string]int{}
+	scan := newRelayListScan(parser, workers, lists, older, mapped)
+	for i, l := range lines {
+		scan.add(l)
+		// Checkpoints wait for the workers mid-scan
+		if i == len(lines)/2 {
+			scan.wait()
+		}
+	}
+	scan.close()
+	// Older versions are kept in arrival order; consumers sort them
+	for pk := range older {
+		vs := older[pk]
+		sort.Slice(vs, func(i, j int) bool { return vs[i].newer(vs[j]) })
+	}
+	return lists, older, mapped
+}
+
+func TestRelayListScanParallelMatchesSerial(t *testing.T) {
+	lines := relayListTestLines(5 * parseBatchLines)
+	lists1, older1, mapped1 := scanRelayLists(lines, 1)
+	if len(lists1) == 0 || len(older1) == 0 || len(mapped1) == 0 {
+		t.Fatalf("test input too thin: %d lists, %d with older versions, %d mapped markers", len(lists1), len(older1), len(mapped1))
+	}
+	for _, workers := range []int{2, 4, 8} {
+		lists, older, mapped := scanRelayLists(lines, workers)
+		if !reflect.DeepEqual(lists, lists1) {
+			t.Errorf("--parallel %d: newest relay lists differ from --parallel 1", workers)
+		}
+		if !reflect.DeepEqual(older, older1) {
+			t.Errorf("--parallel %d: older versions differ from --parallel 1", workers)
+		}
+		if !reflect.DeepEqual(mapped, mapped1) {
+			t.Errorf("--parallel %d: mapped markers %v, want %v", workers, mapped, mapped1)
+		}
+	}
+}
+
+// BenchmarkRelayListScan compares one worker with analyze's default
+// --parallel (one per CPU, at least 4 here) over the same generated input
+func BenchmarkRelayListScan(b *testing.B) {
+	lines := relayListTestLines(20 * parseBatchLines)
+	for _, workers := range []int{1, max(runtime.NumCPU(), 4)} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				scanRelayLists(lines, workers)
+			}
+		})
+	}
+}
+
+func TestRelayListParserCollapsesURLVariants(t *testing.T) {
+	p := &relayListParser{emptyMarkerMode: "write"}
+	line := `{"kind":10002,"id":"e1","pubkey":"P1","created_at":1,"tags":[` +
+		`["r","wss://Relay.Example.com"],["r","wss://relay.example.com/"],["r","WSS://RELAY.EXAMPLE.COM:443/"],` +
+		`["r","wss://relay.example.com","write"],["r","https://relay.example.com"],["r","wss://relay.example.com/?x=1"],["r","not a url"]]}`
+	l, ok := p.parse([]byte(line), map[string]int{})
+	if !ok {
+		t.Fatal("line not parsed")
+	}
+	if l.pk != "p1" {
+		t.Errorf("pubkey %q", l.pk)
+	}
+	if want := []string{"wss://relay.example.com"}; !reflect.DeepEqual(l.version.URLs, want) {
+		t.Errorf("write relays %v, want %v", l.version.URLs, want)
+	}
+
+	// And the author has one entry in the write map
+	lists := map[string]relayListVersion{}
+	addRelayListVersion(lists, map[string][]relayListVersion{}, l.pk, l.version)
+	if len(lists) != 1 || len(lists["p1"].URLs) != 1 {
+		t.Errorf("write map %v", lists)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	// Pure-Go driver, registered as "sqlite"; no cgo needed
+	_ "modernc.org/sqlite"
+)
+
+// analysisRun is the run metadata stored with each analyze run in SQLite
+type analysisRun struct {
+	at              time.Time
+	input           string
+	emptyMarkerMode string
+	writePairs      int
+	readPairs       int
+	outboxRelays    int
+	inboxRelays     int
+}
+
+// sqliteSchema creates the tables on first use. Every run adds rows keyed by
+// its run id, so relays gaining or losing authors can be compared across runs.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id                INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at        TEXT NOT NULL,
+	version           TEXT NOT NULL,
+	input             TEXT NOT NULL,
+	empty_marker_mode TEXT NOT NULL,
+	write_pairs       INTEGER NOT NULL,
+	read_pairs        INTEGER NOT NULL,
+	outbox_relays     INTEGER NOT NULL,
+	inbox_relays      INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS write_map (
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	pubkey TEXT NOT NULL,
+	relay  TEXT NOT NULL,
+	PRIMARY KEY (run_id, pubkey, relay)
+);
+CREATE TABLE IF NOT EXISTS relay_authors (
+	run_id       INTEGER NOT NULL REFERENCES runs(id),
+	relay        TEXT NOT NULL,
+	author_count INTEGER NOT NULL,
+	PRIMARY KEY (run_id, relay)
+);
+CREATE INDEX IF NOT EXISTS relay_authors_relay ON relay_authors (relay, run_id);
+`
+
+// writeAnalysisSQLite adds this run, its write map and each relay's author
+// count to the SQLite database at path, creating it if needed. The run is
+// written in one transaction, so an interrupted run leaves no partial rows.
+// It returns the new run id.
+func writeAnalysisSQLite(path string, run analysisRun, writeMap map[string]set) (int64, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`INSERT INTO runs (created_at, version, input, empty_marker_mode, write_pairs, read_pairs, outbox_relays, inbox_relays)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.at.UTC().Format(time.RFC3339), version, run.input, run.emptyMarkerMode,
+		run.writePairs, run.readPairs, run.outboxRelays, run.inboxRelays)
+	if err != nil {
+		return 0, err
+	}
+	runID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	pairStmt, err := tx.Prepare(`INSERT INTO write_map (run_id, pubkey, relay) VALUES (?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer pairStmt.Close()
+	countStmt, err := tx.Prepare(`INSERT INTO relay_authors (run_id, relay, author_count) VALUES (?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer countStmt.Close()
+	relays := make([]string, 0, len(writeMap))
+	for url := range writeMap {
+		relays = append(relays, url)
+	}
+	sort.Strings(relays)
+	for _, url := range relays {
+		users := writeMap[url]
+		if _, err := countStmt.Exec(runID, url, len(users)); err != nil {
+			return 0, err
+		}
+		pubkeys := make([]string, 0, len(users))
+		for pk := range users {
+			pubkeys = append(pubkeys, pk)
+		}
+		sort.Strings(pubkeys)
+		for _, pk := range pubkeys {
+			if _, err := pairStmt.Exec(runID, pk, url); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return runID, tx.Commit()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteAnalysisSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runs.db")
+	first := map[string]set{
+		"wss://a.example.com": {"p1": {}, "p2": {}, "p3": {}},
+		"wss://b.example.com": {"p1": {}},
+	}
+	second := map[string]set{
+		"wss://a.example.com": {"p1": {}},
+		"wss://c.example.com": {"p2": {}, "p3": {}},
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	id1, err := writeAnalysisSQLite(path, analysisRun{at: at, input: "all_relay_lists.jsonl", emptyMarkerMode: "write", writePairs: 4, outboxRelays: 2}, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := writeAnalysisSQLite(path, analysisRun{at: at.Add(time.Hour), input: "all_relay_lists.jsonl", emptyMarkerMode: "both", writePairs: 3, outboxRelays: 2}, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1 != 1 || id2 != 2 {
+		t.Fatalf("run ids %d and %d, want 1 and 2", id1, id2)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var createdAt, ver, mode string
+	var pairs int
+	if err := db.QueryRow(`SELECT created_at, version, empty_marker_mode, write_pairs FROM runs WHERE id = ?`, id1).Scan(&createdAt, &ver, &mode, &pairs); err != nil {
+		t.Fatal(err)
+	}
+	if createdAt != "2024-05-01T10:00:00Z" || ver != version || mode != "write" || pairs != 4 {
+		t.Errorf("run 1: %s %s %s %d", createdAt, ver, mode, pairs)
+	}
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM write_map WHERE run_id = ?`, id2).Scan(&n); err != nil || n != 3 {
+		t.Errorf("run 2 has %d write pairs (%v), want 3", n, err)
+	}
+
+	// The trend query from the README: relays gaining or losing authors
+	rows, err := db.Query(`SELECT relay,
+    SUM(CASE WHEN run_id = (SELECT MAX(id) FROM runs) THEN author_count ELSE -author_count END) AS delta
+  FROM relay_authors WHERE run_id IN (SELECT id FROM runs ORDER BY id DESC LIMIT 2)
+  GROUP BY relay ORDER BY ABS(delta) DESC, relay LIMIT 20`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := map[string]int{}
+	for rows.Next() {
+		var relay string
+		var delta int
+		if err := rows.Scan(&relay, &delta); err != nil {
+			t.Fatal(err)
+		}
+		got[relay] = delta
+	}
+	want := map[string]int{"wss://a.example.com": -2, "wss://b.example.com": -1, "wss://c.example.com": 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("author deltas %v, want %v", got, want)
+	}
+}
+
+func TestWriteAnalysisSQLiteBadPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "runs.db")
+	if _, err := writeAnalysisSQLite(path, analysisRun{at: time.Now()}, map[string]set{}); err == nil {
+		t.Error("no error for a database in a missing directory")
+	}
+}
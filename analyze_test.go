@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseMarkerMap(t *testing.T) {
+	got, err := parseMarkerMap(" Outbox=write, inbox = READ,both-ways=both ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"outbox": "write", "inbox": "read", "both-ways": "both"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, err := parseMarkerMap(""); err != nil || len(got) != 0 {
+		t.Errorf("empty: %v, %v", got, err)
+	}
+	for _, bad := range []string{"outbox", "=write", "outbox=publish", "outbox=write,inbox"} {
+		if _, err := parseMarkerMap(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestClassifyMarker(t *testing.T) {
+	markerMap := map[string]string{"outbox": "write", "inbox": "read", "relay": "both", "write": "read"}
+	tests := []struct {
+		marker, emptyMode   string
+		write, read, mapped bool
+	}{
+		{"outbox", "write", true, false, true},
+		{"inbox", "write", false, true, true},
+		{"relay", "write", true, true, true},
+		// The map wins over the standard meaning
+		{"write", "write", false, true, true},
+		{"read", "write", false, true, false},
+		{"", "write", true, false, false},
+		{"", "read", false, true, false},
+		{"", "both", true, true, false},
+		// Unmapped custom markers count like an empty one
+		{"publish", "write", true, false, false},
+		{"publish", "both", true, true, false},
+	}
+	for _, tt := range tests {
+		write, read, mapped := classifyMarker(tt.marker, tt.emptyMode, markerMap)
+		if write != tt.write || read != tt.read || mapped != tt.mapped {
+			t.Errorf("classifyMarker(%q, %s) = %t, %t, %t; want %t, %t, %t",
+				tt.marker, tt.emptyMode, write, read, mapped, tt.write, tt.read, tt.mapped)
+		}
+	}
+	// Without a map only the standard markers count
+	if write, read, mapped := classifyMarker("outbox", "write", nil); !write || read || mapped {
+		t.Errorf("unmapped outbox: %t, %t, %t", write, read, mapped)
+	}
+}
+
+func TestRelayListParserMappedMarkers(t *testing.T) {
+	p := &relayListParser{emptyMarkerMode: "write", markerMap: map[string]string{"outbox": "write", "inbox": "read"}}
+	line := `{"kind":10002,"id":"e1","pubkey":"p1","created_at":1,"tags":[` +
+		`["r","wss://a.example","OUTBOX"],["r","wss://b.example","inbox"],["r","wss://c.example","outbox"],["r","wss://d.example"]]}`
+	mapped := map[string]int{}
+	l, ok := p.parse([]byte(line), mapped)
+	if !ok {
+		t.Fatal("line not parsed")
+	}
+	if want := []string{"wss://a.example", "wss://c.example", "wss://d.example"}; !reflect.DeepEqual(l.version.URLs, want) {
+		t.Errorf("write relays %v, want %v", l.version.URLs, want)
+	}
+	if want := []string{"wss://b.example"}; !reflect.DeepEqual(l.version.ReadURLs, want) {
+		t.Errorf("read relays %v, want %v", l.version.ReadURLs, want)
+	}
+	if want := map[string]int{"outbox": 2, "inbox": 1}; !reflect.DeepEqual(mapped, want) {
+		t.Errorf("mapped %v, want %v", mapped, want)
+	}
+}
+
+func TestLoadExcludes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox_exclude.txt")
+	data := strings.Join([]string{
+		"# relays to keep out of the maps",
+		"",
+		"both.example.com",
+		"wss://Write.Example.com/ write   # bad outbox",
+		"read.example.com READ",
+		"ws://odd.example.com:7777 publish",
+		"   # indented comment",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var write, read set
+	stderr := captureOutput(t, &os.Stderr, func() { write, read = loadExcludes(path) })
+
+	wantWrite := set{"both.example.com": {}, "write.example.com": {}, "odd.example.com:7777": {}}
+	wantRead := set{"both.example.com": {}, "read.example.com": {}, "odd.example.com:7777": {}}
+	if !reflect.DeepEqual(write, wantWrite) {
+		t.Errorf("write excludes %v, want %v", write, wantWrite)
+	}
+	if !reflect.DeepEqual(read, wantRead) {
+		t.Errorf("read excludes %v, want %v", read, wantRead)
+	}
+	if !strings.Contains(stderr, `unknown exclude scope "publish"`) {
+		t.Errorf("no warning for the unknown scope: %q", stderr)
+	}
+
+	// A missing file excludes nothing
+	if write, read := loadExcludes(filepath.Join(t.TempDir(), "missing.txt")); len(write) != 0 || len(read) != 0 {
+		t.Errorf("missing file: %v, %v", write, read)
+	}
+}
+
+func TestRelayListParserExcludeScopes(t *testing.T) {
+	p := &relayListParser{
+		exHosts:         set{"both.example.com": {}, "write.example.com": {}},
+		exReadHosts:     set{"both.example.com": {}, "read.example.com": {}},
+		emptyMarkerMode: "both",
+	}
+	line := `{"kind":10002,"id":"e1","pubkey":"p1","created_at":1,"tags":[` +
+		`["r","wss://both.example.com"],["r","wss://write.example.com"],["r","wss://read.example.com"],["r","wss://ok.example.com"]]}`
+	l, ok := p.parse([]byte(line), map[string]int{})
+	if !ok {
+		t.Fatal("line not parsed")
+	}
+	if want := []string{"wss://read.example.com", "wss://ok.example.com"}; !reflect.DeepEqual(l.version.URLs, want) {
+		t.Errorf("write relays %v, want %v", l.version.URLs, want)
+	}
+	if want := []string{"wss://write.example.com", "wss://ok.example.com"}; !reflect.DeepEqual(l.version.ReadURLs, want) {
+		t.Errorf("read relays %v, want %v", l.version.ReadURLs, want)
+	}
+}
+
+func TestUniqueByHost(t *testing.T) {
+	relayMap := map[string]set{
+		// The bare secure URL wins over paths and plaintext
+		"wss://a.example.com/nostr": {},
+		"ws://a.example.com":        {},
+		"wss://a.example.com":       {},
+		// Without a bare URL, the shortest path
+		"wss://b.example.com/relay/v1": {},
+		"wss://b.example.com/v1":       {},
+		// Equal paths fall back to the lexically smaller URL
+		"wss://c.example.com/y": {},
+		"wss://c.example.com/x": {},
+		// wss:// with a path still beats bare ws://
+		"ws://d.example.com":       {},
+		"wss://d.example.com/feed": {},
+		// A plaintext-only host keeps its shortest URL
+		"ws://e.example.com/x": {},
+		"ws://e.example.com":   {},
+		// Another port is another host
+		"wss://a.example.com:7777/x": {},
+	}
+	want := []string{
+		"wss://a.example.com",
+		"wss://a.example.com:7777/x",
+		"wss://b.example.com/v1",
+		"wss://c.example.com/x",
+		"wss://d.example.com/feed",
+		"ws://e.example.com",
+	}
+	sort.Strings(want)
+	// Map order must not matter
+	for i := 0; i < 20; i++ {
+		if got := uniqueByHost(relayMap); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
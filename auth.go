@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	nostr "github.com/nbd-wtf/go-nostr"
+)
+
+// defaultSecEnv is the environment variable read for the private key when
+// --sec is not given, so it stays out of shell history and process listings
+const defaultSecEnv = "FEEDBUILDER_SEC"
+
+// relayAuth answers NIP-42 challenges with the key given by --sec. It is
+// only kept in memory: nothing in the data dir records it, and wipe zeroes
+// it once step 3 is done. go-nostr signs with a hex string, so each
+// signature briefly holds a copy that cannot be zeroed.
+type relayAuth struct {
+	sk            []byte
+	authenticated atomic.Int64
+	failed        atomic.Int64
+}
+
+// newRelayAuth parses a private key given as 64-char hex or nsec
+func newRelayAuth(sec string) (*relayAuth, error) {
+	sec = strings.ToLower(strings.TrimSpace(sec))
+	if isHex64(sec) {
+		sk, err := hex.DecodeString(sec)
+		if err != nil {
+			return nil, err
+		}
+		return &relayAuth{sk: sk}, nil
+	}
+	hrp, data, err := bech32Decode(sec)
+	if err != nil {
+		return nil, fmt.Errorf("not 64-hex or nsec: %w", err)
+	}
+	if hrp != "nsec" {
+		return nil, fmt.Errorf("unsupported bech32 prefix %q (want nsec)", hrp)
+	}
+	if len(data) != 32 {
+		return nil, fmt.Errorf("nsec carries %d bytes, want 32", len(data))
+	}
+	return &relayAuth{sk: data}, nil
+}
+
+// authRequired reports whether a CLOSED reason asks for NIP-42 auth
+func authRequired(reason string) bool {
+	return strings.HasPrefix(reason, "auth-required:")
+}
+
+// authenticate signs the relay's last challenge as a kind 22242 event and
+// waits for the relay to accept it
+func (a *relayAuth) authenticate(ctx context.Context, relay *nostr.Relay) error {
+	if a.sk == nil {
+		return errors.New("key already wiped")
+	}
+	err := relay.Auth(ctx, func(ev *nostr.Event) error {
+		return ev.Sign(hex.EncodeToString(a.sk))
+	})
+	if err != nil {
+		a.failed.Add(1)
+		return err
+	}
+	a.authenticated.Add(1)
+	return nil
+}
+
+// wipe zeroes the key; later challenges fail
+func (a *relayAuth) wipe() {
+	for i := range a.sk {
+		a.sk[i] = 0
+	}
+	a.sk = nil
+}
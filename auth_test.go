@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	nostr "github.com/nbd-wtf/go-nostr"
+)
+
+// NIP-19 test vector
+const (
+	testNsec   = "nsec1vl029mgpspedva04g90vltkh6fvh240zqtv9k0t9af8935ke9laqsnlfe5"
+	testSecHex = "67dea2ed018072d675f5415ecfaed7d2597555e202d85b3d65ea4e58d2d92ffa"
+)
+
+func TestNewRelayAuth(t *testing.T) {
+	for _, sec := range []string{testSecHex, strings.ToUpper(testSecHex), testNsec, " " + testNsec + "\n"} {
+		a, err := newRelayAuth(sec)
+		if err != nil {
+			t.Errorf("%q: %v", sec, err)
+			continue
+		}
+		if got := hex.EncodeToString(a.sk); got != testSecHex {
+			t.Errorf("%q: key %s", sec, got)
+		}
+	}
+	for _, sec := range []string{"", testSecHex[:63], testNpub, testNsec[:len(testNsec)-1] + "x", "nsec1qqqqqq"} {
+		if _, err := newRelayAuth(sec); err == nil {
+			t.Errorf("%q: no error", sec)
+		}
+	}
+}
+
+func TestRelayAuthWipe(t *testing.T) {
+	a, err := newRelayAuth(testNsec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := a.sk
+	a.wipe()
+	for _, b := range key {
+		if b != 0 {
+			t.Fatalf("key bytes not zeroed: %x", key)
+		}
+	}
+	if err := a.authenticate(context.Background(), nil); err == nil {
+		t.Error("authenticated with a wiped key")
+	}
+}
+
+func TestAuthRequired(t *testing.T) {
+	for reason, want := range map[string]bool{
+		"auth-required: we only serve members": true,
+		"auth-required:":                       true,
+		"restricted: no":                       false,
+		"":                                     false,
+		"error: auth-required: nested":         false,
+	} {
+		if got := authRequired(reason); got != want {
+			t.Errorf("authRequired(%q) = %t", reason, got)
+		}
+	}
+}
+
+// authRelay is a NIP-42 relay: it answers REQs with a challenge and an
+// auth-required CLOSED until a valid kind 22242 event for that challenge
+// arrives, and then serves events, followed by EOSE, or with serve false
+// closes the REQ again as restricted. It returns the relay URL and the
+// pubkeys it accepted.
+func authRelay(t *testing.T, serve bool, events ...*nostr.Event) (string, func() []string) {
+	t.Helper()
+	const challenge = "challenge-1"
+	var mu sync.Mutex
+	var accepted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _, err := ws.UpgradeHTTP(r, w)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		send := func(msg ...any) bool {
+			out, _ := json.Marshal(msg)
+			return wsutil.WriteServerText(conn, out) == nil
+		}
+		authed := false
+		for {
+			msg, err := wsutil.ReadClientText(conn)
+			if err != nil {
+				return
+			}
+			var req []json.RawMessage
+			if json.Unmarshal(msg, &req) != nil || len(req) < 2 {
+				continue
+			}
+			switch string(req[0]) {
+			case `"AUTH"`:
+				var ev nostr.Event
+				ok := json.Unmarshal(req[1], &ev) == nil
+				if ok {
+					valid, _ := ev.CheckSignature()
+					ok = valid && ev.Kind == nostr.KindClientAuthentication && ev.Tags.GetFirst([]string{"challenge", challenge}) != nil
+				}
+				if ok {
+					authed = true
+					mu.Lock()
+					accepted = append(accepted, ev.PubKey)
+					mu.Unlock()
+				}
+				send("OK", ev.ID, ok, "")
+			case `"REQ"`:
+				var subID string
+				json.Unmarshal(req[1], &subID)
+				if !authed {
+					// The challenge comes with the first refusal, which NIP-42
+					// allows at any time
+					send("AUTH", challenge)
+					send("CLOSED", subID, "auth-required: members only")
+					continue
+				}
+				if !serve {
+					send("CLOSED", subID, "restricted: not a member")
+					continue
+				}
+				for _, ev := range events {
+					send("EVENT", subID, ev)
+				}
+				send("EOSE", subID)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), accepted...)
+	}
+}
+
+func TestFetchBatchAnswersAuthRequired(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	list := signedEvent(t, sk, 10002, 1, nostr.Tags{{"r", "wss://relay.example.com"}}, "")
+	url, accepted := authRelay(t, true, list)
+
+	fetch := func(auth *relayAuth) (int, batchEnd, error) {
+		ctx := context.Background()
+		relay, err := nostr.RelayConnect(ctx, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer relay.Close()
+		opts := &batchOptions{batchTimeout: time.Second, seen: newSeenSet(nil), sources: newSourceLog(), auth: auth}
+		out := make(chan eventLine, 10)
+		received, _, end, err := fetchBatch(ctx, relay, url, []string{pk}, 0, 0, 0, nil, opts, out)
+		return received, end, err
+	}
+
+	// Without --sec the CLOSED is not answered and the batch times out empty
+	if received, end, err := fetch(nil); err != nil || received != 0 || end != endTimeout {
+		t.Errorf("without auth: %d events, end %v, %v", received, end, err)
+	}
+
+	a, err := newRelayAuth(testNsec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, end, err := fetch(a)
+	if err != nil || received != 1 || end != endEOSE {
+		t.Fatalf("with auth: %d events, end %v, %v", received, end, err)
+	}
+	if n := a.authenticated.Load(); n != 1 || a.failed.Load() != 0 {
+		t.Errorf("%d accepted, %d failed", n, a.failed.Load())
+	}
+	signer, _ := nostr.GetPublicKey(testSecHex)
+	if got := accepted(); len(got) != 1 || got[0] != signer {
+		t.Errorf("relay accepted %v, want %s", got, signer)
+	}
+}
+
+func TestFetchBatchAuthOnlyOnce(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	list := signedEvent(t, sk, 10002, 1, nostr.Tags{{"r", "wss://relay.example.com"}}, "")
+	url, accepted := authRelay(t, false, list)
+
+	ctx := context.Background()
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	a, err := newRelayAuth(testNsec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &batchOptions{batchTimeout: 5 * time.Second, seen: newSeenSet(nil), sources: newSourceLog(), auth: a}
+	out := make(chan eventLine, 10)
+
+	// The repeated subscription is closed too: the batch ends without
+	// waiting for its timeout, and without authenticating again
+	start := time.Now()
+	received, _, _, err := fetchBatch(ctx, relay, url, []string{pk}, 0, 0, 0, nil, opts, out)
+	if err == nil || !strings.Contains(err.Error(), "restricted") || received != 0 {
+		t.Fatalf("got %d events, %v; want the restricted CLOSED as error", received, err)
+	}
+	if time.Since(start) >= opts.batchTimeout {
+		t.Error("batch waited for its timeout")
+	}
+	if n := len(accepted()); n != 1 || a.authenticated.Load() != 1 {
+		t.Errorf("authenticated %d times (relay accepted %d), want once", a.authenticated.Load(), n)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// bundledExcludes is the default list for --bootstrap-excludes. Update it by
+// editing bootstrap_excludes.txt and rebuilding.
+//
+//go:embed bootstrap_excludes.txt
+var bundledExcludes string
+
+// loadBootstrapExcludes returns exclude entries from the bundled list
+// (source "bundled") or from an http(s) URL serving the same format
+func loadBootstrapExcludes(ctx context.Context, source string) ([]string, error) {
+	var r io.Reader
+	if source == "bundled" {
+		r = strings.NewReader(bundledExcludes)
+	} else if isHTTPInput(source) {
+		body, err := fetchHTTPInput(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		r = body
+	} else {
+		return nil, fmt.Errorf("unknown source %q (want bundled or an http(s) URL)", source)
+	}
+
+	var entries []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries, s.Err()
+}
+
+// newBootstrapExcludes returns the entries whose host is not yet listed in
+// the exclude file
+func newBootstrapExcludes(excludeFile string, entries []string) []string {
+	existing := set{}
+	if lines, err := readLines(excludeFile); err == nil {
+		for _, l := range lines {
+			if fields := strings.Fields(l); len(fields) > 0 && !strings.HasPrefix(fields[0], "#") {
+				existing.add(urlToHost(fields[0]))
+			}
+		}
+	}
+
+	var added []string
+	for _, e := range entries {
+		h := urlToHost(strings.Fields(e)[0])
+		if h == "" || existing.has(h) {
+			continue
+		}
+		existing.add(h)
+		added = append(added, e)
+	}
+	return added
+}
+
+// mergeBootstrapExcludes appends entries whose host is not yet listed in the
+// exclude file, keeping existing entries (and their scopes) untouched.
+// It returns the entries that were added.
+func mergeBootstrapExcludes(excludeFile string, entries []string) ([]string, error) {
+	added := newBootstrapExcludes(excludeFile, entries)
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	f, err := os.OpenFile(excludeFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "# added by --bootstrap-excludes")
+	for _, e := range added {
+		fmt.Fprintln(w, e)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return added, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// buildCmd runs collect, analyze and gen-router in sequence with a shared data dir.
+// Each stage exits the process on failure, so a failing stage stops the pipeline.
+func buildCmd(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	dataDir := commonFlags(fs)
+	pubkeySrc := pubkeyFlags(fs, "your pubkey to read kind-3 follows from")
+	relaysCSV := fs.String("relays", "", "comma-separated relay URLs to query for kind-10002 (default: collect's relay list)")
+	inferSchemeFlag := fs.Bool("infer-scheme", false, "accept relays without a scheme in --relays and assume wss://")
+	replicas := fs.Int("replicas", 1, "number of distinct relays to assign each author to (>=1)")
+	output := fs.String("output", "./strfry-router.config", "output router config path")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse flags: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Resolved here so collect runs in-process with the hex key, never in argv
+	pubkey, err := pubkeySrc.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	collectArgs := []string{"--data-dir", *dataDir, "--pubkey", pubkey}
+	if *relaysCSV != "" {
+		collectArgs = append(collectArgs, "--relays", *relaysCSV)
+	}
+	if *inferSchemeFlag {
+		collectArgs = append(collectArgs, "--infer-scheme")
+	}
+	analyzeArgs := []string{"--data-dir", *dataDir}
+	genRouterArgs := []string{"--data-dir", *dataDir, "--output", *output, "--replicas", strconv.Itoa(*replicas)}
+
+	fmt.Println("==> build 1/3: collect")
+	collectCmd(ctx, collectArgs)
+	exitIfInterrupted(ctx)
+	fmt.Println("\n==> build 2/3: analyze")
+	analyzeCmd(ctx, analyzeArgs)
+	exitIfInterrupted(ctx)
+	fmt.Println("\n==> build 3/3: gen-router")
+	genRouterCmd(ctx, genRouterArgs)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+)
+
+// collectCheckpoint records which (relay, batch) pairs have completed so an
+// interrupted collect can skip them on restart
+type collectCheckpoint struct {
+	FollowsHash string           `json:"follows_hash"`
+	BatchSize   int              `json:"batch_size"`
+	Completed   map[string][]int `json:"completed"`
+
+	path string
+	mu   sync.Mutex
+	done map[string]map[int]struct{}
+}
+
+// hashFollows returns a short fingerprint of the follows list so a checkpoint
+// is only reused when the batches it describes are the same
+func hashFollows(follows []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(follows, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// loadCheckpoint reads the checkpoint at path. A missing, unreadable or
+// mismatched checkpoint yields an empty one bound to the current run.
+func loadCheckpoint(path, followsHash string, batchSize int) *collectCheckpoint {
+	c := &collectCheckpoint{
+		FollowsHash: followsHash,
+		BatchSize:   batchSize,
+		path:        path,
+		done:        make(map[string]map[int]struct{}),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c
+	}
+	var prev collectCheckpoint
+	if err := json.Unmarshal(data, &prev); err != nil {
+		return c
+	}
+	if prev.FollowsHash != followsHash || prev.BatchSize != batchSize {
+		return c
+	}
+	for relay, idxs := range prev.Completed {
+		for _, idx := range idxs {
+			c.markDone(relay, idx)
+		}
+	}
+	return c
+}
+
+func (c *collectCheckpoint) isDone(relay string, batchIdx int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.done[relay][batchIdx]
+	return ok
+}
+
+func (c *collectCheckpoint) markDone(relay string, batchIdx int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done[relay] == nil {
+		c.done[relay] = make(map[int]struct{})
+	}
+	c.done[relay][batchIdx] = struct{}{}
+}
+
+// completedCount returns the number of (relay, batch) pairs marked done
+func (c *collectCheckpoint) completedCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, idxs := range c.done {
+		n += len(idxs)
+	}
+	return n
+}
+
+// covers reports whether every relay has completed all n batches
+func (c *collectCheckpoint) covers(relays []string, n int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, relay := range relays {
+		if len(c.done[relay]) < n {
+			return false
+		}
+	}
+	return true
+}
+
+// save writes the checkpoint atomically via a temp file and rename
+func (c *collectCheckpoint) save() error {
+	c.mu.Lock()
+	c.Completed = make(map[string][]int, len(c.done))
+	for relay, idxs := range c.done {
+		for idx := range idxs {
+			c.Completed[relay] = append(c.Completed[relay], idx)
+		}
+	}
+	data, err := json.Marshal(c)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, data)
+}
+
+// remove deletes the checkpoint file after a successful run
+func (c *collectCheckpoint) remove() error {
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -3,11 +3,16 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,10 +21,19 @@ import (
 	nostr "github.com/nbd-wtf/go-nostr"
 )
 
-// eventLine represents a relay list event for serialized JSONL writes
+// eventLine represents a relay list event for serialized JSONL writes.
+// A line with batchDone set carries no event; it tells the writer that all
+// events of that (relay, batch) pair have been sent.
 type eventLine struct {
-	id   string
-	line string
+	id        string
+	line      string
+	batchDone *batchKey
+}
+
+// batchKey identifies one batch queried against one relay
+type batchKey struct {
+	relay string
+	idx   int
 }
 
 // progressTracker tracks collection progress across goroutines
@@ -29,26 +43,215 @@ type progressTracker struct {
 	batchesTotal   int
 	batchesDone    atomic.Int64
 	relaysTotal    int
+	emptyBatches   atomic.Int64 // batches that returned no events
+	emptyRecovered atomic.Int64 // empty batches that returned events when retried with a limit
+	authorsSkipped atomic.Int64 // author lookups trimmed because a 10002 was already found
+	batchesSkipped atomic.Int64 // REQs not sent because every author was already found
+	duplicates     atomic.Int64 // events a relay resent within one subscription, not counted as received
+	// How batch subscriptions ended, and the relays whose batches timed out
+	endings          [endCap + 1]atomic.Int64
+	timeoutRecovered atomic.Int64 // timed-out batches whose retried halves both reached EOSE
+	timeoutMu        sync.Mutex
+	timeoutsByRelay  map[string]int
+}
+
+// batchEnd is why a batch subscription stopped
+type batchEnd int
+
+const (
+	endEOSE    batchEnd = iota // the relay sent EOSE
+	endTimeout                 // no EOSE before the batch timeout; what arrived is kept
+	endCap                     // the relay reached --events-per-relay-limit
+)
+
+// recordEnd counts how a batch on relayURL ended
+func (p *progressTracker) recordEnd(relayURL string, end batchEnd) {
+	p.endings[end].Add(1)
+	if end != endTimeout {
+		return
+	}
+	p.timeoutMu.Lock()
+	defer p.timeoutMu.Unlock()
+	if p.timeoutsByRelay == nil {
+		p.timeoutsByRelay = make(map[string]int)
+	}
+	p.timeoutsByRelay[relayURL]++
+}
+
+// batchOptions controls how step 3 queries each relay
+type batchOptions struct {
+	timeout      time.Duration // per relay connect
+	batchTimeout time.Duration // per batch subscription
+	// limit is the filter limit per batch; 0 uses the batch's author count, <0 omits it
+	limit int
+	// retryEmpty retries a batch that returned nothing without a limit, with an explicit one
+	retryEmpty bool
+	// retryTimeout retries a batch that ended without EOSE once, split in two halves
+	retryTimeout bool
+	// maxEvents caps the events accepted from one relay across all batches (<=0 means no cap)
+	maxEvents int
+	// seen deduplicates events by kind-aware key before they are queued for the writer
+	seen *seenSet
+	// adaptive scales batchTimeout per relay from its first batch, within [adaptiveMin, adaptiveMax]
+	adaptive    bool
+	adaptiveMin time.Duration
+	adaptiveMax time.Duration
+	// found, when set, records authors with a recent enough 10002 so later
+	// batches on other relays stop asking for them
+	found *foundSet
+	// stats, when set, records each relay's connect success and event yield
+	stats *relayInfoCache
+	// timeoutOverrides replaces timeout and batchTimeout for relays on these hosts
+	timeoutOverrides map[string]time.Duration
+	// connectRetries is how often a failed relay connect is retried, with backoff
+	connectRetries int
+	// auth, when set, answers a CLOSED "auth-required:" with NIP-42 AUTH and asks again
+	auth *relayAuth
+	// failFast returns a relay's first batch error instead of logging it and moving on
+	failFast bool
+	// sources records the relays each event was received from (seen_on)
+	sources *sourceLog
+	// annotateSource writes each event wrapped in a sourceEnvelope
+	annotateSource bool
+	// keepOlder also writes superseded versions the first time a relay
+	// delivers them, so analyze can report relay list conflicts
+	keepOlder bool
+}
+
+// foundSet tracks authors whose relay list has been found, across relays
+type foundSet struct {
+	mu      sync.Mutex
+	minAt   int64 // only 10002s created at or after this count as found
+	pubkeys map[string]struct{}
+}
+
+func newFoundSet(minAt int64) *foundSet {
+	return &foundSet{minAt: minAt, pubkeys: make(map[string]struct{})}
+}
+
+// add records pubkey as found if its 10002 is recent enough
+func (f *foundSet) add(pubkey string, createdAt int64) {
+	if createdAt < f.minAt {
+		return
+	}
+	f.mu.Lock()
+	f.pubkeys[pubkey] = struct{}{}
+	f.mu.Unlock()
+}
+
+// missing returns the authors not found yet
+func (f *foundSet) missing(authors []string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]string, 0, len(authors))
+	for _, a := range authors {
+		if _, ok := f.pubkeys[strings.ToLower(a)]; !ok {
+			out = append(out, a)
+		}
+	}
+	return out
 }
 
-func collectCmd(args []string) {
+func collectCmd(ctx context.Context, args []string) {
 	fs := flag.NewFlagSet("collect", flag.ExitOnError)
 	dataDir := commonFlags(fs)
-	pubkey := fs.String("pubkey", "", "your 64-hex pubkey to read kind-3 follows from")
+	pubkeySrc := pubkeyFlags(fs, "your pubkey to read kind-3 follows from")
 	relaysCSV := fs.String("relays", "wss://relay.damus.io,wss://nos.lol,wss://nostr.wine,wss://relay.snort.social,wss://wot.brainstorm.social,wss://profiles.nostr1.com", "comma-separated relay URLs to query for kind-10002")
 	followRelay := fs.String("follow-relay", "", "optional specific relay to query kind 3 (defaults to first in relays)")
+	nip05ID := fs.String("nip05", "", "resolve a NIP-05 identifier (name@domain) to your pubkey and add its advertised relays to --relays")
+	requireWSS := fs.Bool("require-wss", false, "never query plaintext ws:// relays")
+	maxRelays := fs.Int("max-relays", 0, "query at most this many relays for 10002, preferring relays reachable per relay_latency.txt from probe, then the given order (0 = unbounded)")
+	inferSchemeFlag := fs.Bool("infer-scheme", false, "accept relays without a scheme in --relays/--follow-relay and assume wss://")
 	batchSize := fs.Int("batch-size", 50, "number of authors per 10002 REQ batch")
 	timeoutSec := fs.Int("timeout", 12, "seconds to wait for REQ per relay/batch")
+	batchTimeoutSec := fs.Int("batch-timeout", 0, "seconds to wait for each 10002 batch subscription (0 = use --timeout)")
 	parallel := fs.Int("parallel", 4, "number of relays to query in parallel for 10002")
+	onlyFollowSet := fs.String("only-follow-set", "", "only fetch relay lists for members of this follow set (d-tag)")
+	withContacts := fs.Bool("with-contacts", false, "with --only-follow-set, also include your kind 3 follows")
+	followSetFormat := fs.String("follow-set-format", "text", "format for follow set files: text, json, or both")
+	batchLimit := fs.Int("batch-limit", 0, "filter limit per 10002 batch (0 = number of authors in the batch, -1 = omit limit)")
+	retryEmpty := fs.Bool("retry-empty", true, "when --batch-limit -1 yields no events for a batch, retry it once with an explicit limit")
+	retryTimeout := fs.Bool("retry-timeout", false, "retry a batch that hit its timeout without EOSE once, as two half-size batches (what arrived is kept either way)")
+	eventsPerRelayLimit := fs.Int("events-per-relay-limit", 100000, "stop reading from a relay after this many 10002 events (0 = no cap)")
+	writeQueue := fs.Int("write-queue", 1024, "events buffered between relay fetchers and the JSONL writer")
+	writeBufferKB := fs.Int("write-buffer-kb", 64, "size of the JSONL write buffer in KiB")
+	flushInterval := fs.Int("flush-interval", 5, "seconds between JSONL flushes (and checkpoint saves)")
+	annotateSource := fs.Bool("annotate-source", false, "write each event as {\"relay\":...,\"event\":{...}} instead of a plain event with a seen_on field")
+	keepOlderLists := fs.Bool("keep-older-lists", false, "also keep superseded 10002 versions that some relay still served, for analyze's relay_list_conflicts.txt (by default only the newest per author is kept)")
+	sec := fs.String("sec", "", "private key (64-hex or nsec) to answer NIP-42 AUTH challenges of relays in step 3; kept in memory only (visible in process listings; prefer --sec-from-env)")
+	secEnv := fs.String("sec-from-env", defaultSecEnv, "environment variable read for the private key when --sec is not set (empty = off)")
+	connectRetries := fs.Int("connect-retries", 2, "retry a failed relay connect this many times, waiting 1s, 2s, 4s (capped) in between")
+	failFast := fs.Bool("fail-fast", false, "stop step 3 at the first relay connect or subscription error and exit non-zero (for CI)")
+	relayTimeouts := fs.String("relay-timeout", "", "per-host timeout overrides for connect and batch subscriptions, e.g. 'slow.example.com=45s,abc.onion=2m' (other relays use --timeout/--batch-timeout)")
+	adaptiveTimeout := fs.Bool("adaptive-timeout", false, "scale each relay's batch timeout from its connect+EOSE time on the first batch")
+	adaptiveMin := fs.Int("adaptive-min", 2, "with --adaptive-timeout, lower bound in seconds for a relay's batch timeout")
+	adaptiveMax := fs.Int("adaptive-max", 0, "with --adaptive-timeout, upper bound in seconds (0 = --batch-timeout or --timeout)")
+	skipFound := fs.Bool("skip-found", false, "stop asking further relays for authors whose 10002 was already found (fewer REQs, may miss newer copies)")
+	foundMaxAgeDays := fs.Int("found-max-age", 0, "with --skip-found, only count a 10002 as found if created within this many days (0 = any age)")
+	authorShard := fs.String("author-shard", "", "only fetch relay lists for shard i of n (e.g. 2/4) of the follows, into all_relay_lists.shard-i-of-n.jsonl")
+	livenessSample := fs.Int("liveness-sample", 0, "check this many random follows' assigned relays (or newest write relays) for a recent kind 1 into relay_liveness_sample.txt (0 = off)")
+	livenessDays := fs.Int("liveness-days", 30, "with --liveness-sample, how recent a note must be to count")
+	fetchProfiles := fs.Bool("fetch-profiles", false, "also fetch follows' kind 0 profiles into pubkey_names.txt, used by gen-router to comment streams with a follow name (adds an extra pass)")
+	activityDays := fs.Int("activity-days", 0, "also fetch each follow's newest kind 1 note from the last N days into follow_activity.txt, for gen-router --max-inactive (0 = off; adds a full extra pass)")
+	cacheNIP11 := fs.Bool("cache-nip11", false, "after collecting, fetch NIP-11 documents for the query relays and all listed write relays into relay_cache.json (reused by analyze and gen-router)")
+	wotMin := fs.Int("wot-min", 0, "also collect relay lists for follows-of-follows followed by at least this many of your follows, adding them to follows_list.txt (0 = off; adds a kind 3 pass)")
+	wotMaxCandidates := fs.Int("wot-max-candidates", 1000, "with --wot-min, add at most this many follows-of-follows, most followed first (0 = no cap)")
+	resume := fs.Bool("resume", false, "append to the existing all_relay_lists.jsonl and only fetch relay lists of follows not in it yet")
+	useCheckpoint := fs.Bool("checkpoint", true, "record completed relay batches in collect_checkpoint.json and resume from it after an interruption")
 	if err := fs.Parse(args); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to parse flags: %v\n", err)
 		os.Exit(1)
 	}
+	if f := *followSetFormat; f != "text" && f != "json" && f != "both" {
+		fmt.Fprintf(os.Stderr, "invalid --follow-set-format %q (want text, json, or both)\n", f)
+		os.Exit(1)
+	}
 
-	if *pubkey == "" || !isHex64(strings.ToLower(*pubkey)) {
-		fmt.Fprintln(os.Stderr, "--pubkey (64-hex) is required and must be valid hex")
+	given, err := pubkeySrc.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	pubkey := &given
+	var nip05Relays []string
+	if *nip05ID != "" {
+		resolved, relays, err := resolveNIP05(ctx, *nip05ID, httpTimeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to resolve --nip05 %s: %v\n", *nip05ID, err)
+			os.Exit(1)
+		}
+		if given != "" && given != resolved {
+			fmt.Fprintf(os.Stderr, "--nip05 %s resolves to %s, which differs from --pubkey %s\n", *nip05ID, resolved, given)
+			os.Exit(1)
+		}
+		fmt.Printf("Resolved %s to %s (%d relays advertised)\n", *nip05ID, resolved, len(relays))
+		*pubkey = resolved
+		nip05Relays = relays
+	}
+	if *pubkey == "" {
+		fmt.Fprintf(os.Stderr, "--pubkey (hex, npub or nprofile), --pubkey-file, $%s or --nip05 is required\n", defaultPubkeyEnv)
+		os.Exit(1)
+	}
+
+	var auth *relayAuth
+	secRaw, secFrom := *sec, "--sec"
+	if secRaw == "" && *secEnv != "" {
+		secRaw, secFrom = os.Getenv(*secEnv), "$"+*secEnv
+	}
+	if secRaw != "" {
+		if auth, err = newRelayAuth(secRaw); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", secFrom, err)
+			os.Exit(1)
+		}
+	}
+
+	var shard authorShardSpec
+	if *authorShard != "" {
+		if shard, err = parseAuthorShard(*authorShard); err != nil {
+			fmt.Fprintf(os.Stderr, "--author-shard: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	dataDirectory := *dataDir
 	if err := os.MkdirAll(dataDirectory, 0o755); err != nil {
@@ -59,26 +262,74 @@ func collectCmd(args []string) {
 	followsPath := filepath.Join(dataDirectory, "follows_list.txt")
 	userRelayListPath := filepath.Join(dataDirectory, "user_relay_list.txt")
 	userPubkeyPath := filepath.Join(dataDirectory, "user_pubkey.txt")
+	relayHintsPath := filepath.Join(dataDirectory, "follow_relay_hints.txt")
+	userRelayMarkersPath := filepath.Join(dataDirectory, "user_relay_markers.txt")
+	muteListPath := filepath.Join(dataDirectory, "mute_list.txt")
+	wotPath := filepath.Join(dataDirectory, "wot_candidates.txt")
+	checkpointPath := filepath.Join(dataDirectory, "collect_checkpoint.json")
+	if shard.n > 0 {
+		jsonlPath = filepath.Join(dataDirectory, "all_relay_lists."+shard.suffix()+".jsonl")
+		checkpointPath = filepath.Join(dataDirectory, "collect_checkpoint."+shard.suffix()+".json")
+	}
 	followSetsDir := filepath.Join(dataDirectory, "follow_sets")
 
-	relays := splitCSV(*relaysCSV)
+	relays := parseRelayList(*relaysCSV, *inferSchemeFlag)
+	for _, r := range nip05Relays {
+		if !isValidRelayURL(r) {
+			continue
+		}
+		if !containsRelay(relays, r) {
+			relays = append(relays, r)
+		}
+	}
+	if *requireWSS {
+		var secure []string
+		for _, r := range relays {
+			if isPlaintextRelay(r) {
+				fmt.Fprintf(os.Stderr, "warning: --require-wss: skipping %s\n", r)
+				continue
+			}
+			secure = append(secure, r)
+		}
+		relays = secure
+	}
+	if *maxRelays > 0 && len(relays) > *maxRelays {
+		reachable, _ := loadLatencyReport(filepath.Join(*dataDir, "relay_latency.txt"))
+		var dropped []string
+		relays, dropped = capRelays(relays, *maxRelays, reachable)
+		fmt.Printf("Capped seed relays to %d (--max-relays), dropped %d:\n", len(relays), len(dropped))
+		for _, r := range dropped {
+			fmt.Printf("    ✗ %s\n", r)
+		}
+	}
 	if len(relays) == 0 {
 		fmt.Fprintln(os.Stderr, "no relays provided")
 		os.Exit(1)
 	}
 	followRelayURL := *followRelay
+	if *inferSchemeFlag {
+		followRelayURL = inferScheme(followRelayURL)
+	}
+	if *requireWSS && isPlaintextRelay(followRelayURL) {
+		fmt.Fprintf(os.Stderr, "--require-wss: --follow-relay %s is not wss://\n", followRelayURL)
+		os.Exit(1)
+	}
 	if followRelayURL == "" {
 		followRelayURL = relays[0]
 	}
 
-	ctx := context.Background()
 	timeout := time.Duration(*timeoutSec) * time.Second
+	timeoutOverrides, err := parseRelayTimeouts(*relayTimeouts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--relay-timeout: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Step 1: Fetch user's own relay list (kind 10002)
 	fmt.Println("\n==> Step 1: Fetching your relay list (kind 10002)")
 	fmt.Printf("    Connecting to %s...\n", followRelayURL)
 
-	userRelays, err := fetchUserRelayList(ctx, followRelayURL, *pubkey, timeout)
+	userRelays, userMarkers, err := fetchUserRelayList(ctx, followRelayURL, *pubkey, timeout, *connectRetries)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "warning: failed to get your relay list from %s: %v\n", followRelayURL, err)
 		// Continue anyway - not critical
@@ -88,6 +339,14 @@ func collectCmd(args []string) {
 		} else {
 			fmt.Printf("    ✓ Found %d relays in your relay list\n", len(userRelays))
 		}
+		// Keep the NIP-65 markers alongside so gen-router can pick read relays for notifications
+		var markerLines []string
+		for _, url := range userRelays {
+			markerLines = append(markerLines, strings.TrimSpace(fmt.Sprintf("%s %s", url, userMarkers[url])))
+		}
+		if err := writeLines(userRelayMarkersPath, markerLines); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to write user relay markers: %v\n", err)
+		}
 	} else {
 		fmt.Println("    ⚠ No relay list found for your pubkey")
 	}
@@ -96,22 +355,36 @@ func collectCmd(args []string) {
 	fmt.Println("\n==> Step 2: Fetching your follow list (kind 3)")
 	fmt.Printf("    Connecting to %s...\n", followRelayURL)
 
-	follows, err := fetchFollows(ctx, followRelayURL, *pubkey, timeout)
+	follows, hints, err := fetchFollows(ctx, followRelayURL, *pubkey, timeout)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to get follows from %s: %v\n", followRelayURL, err)
 		os.Exit(1)
 	}
 	fmt.Printf("    ✓ Found %d follows from kind 3\n", len(follows))
 
+	// Save per-follow relay hints from p-tags; analyze uses them for follows without a 10002
+	var hintPairs []string
+	for pk, url := range hints {
+		hintPairs = append(hintPairs, fmt.Sprintf("%s %s", pk, url))
+	}
+	sort.Strings(hintPairs)
+	if err := writeLines(relayHintsPath, hintPairs); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write relay hints: %v\n", err)
+	} else if len(hintPairs) > 0 {
+		fmt.Printf("    ✓ Saved %d follow relay hints\n", len(hintPairs))
+	}
+
 	// Step 2b: Fetch follow sets (kind 30000)
 	fmt.Println("\n==> Step 2b: Fetching your follow sets (kind 30000)")
 	fmt.Printf("    Connecting to %s...\n", followRelayURL)
 
 	// Create follow_sets directory
+	contactFollows := follows
+	var followSets map[string][]string
 	if err := os.MkdirAll(followSetsDir, 0o755); err != nil {
 		fmt.Fprintf(os.Stderr, "warning: failed to create follow_sets directory: %v\n", err)
 	} else {
-		followSets, err := fetchAndSaveFollowSets(ctx, followRelayURL, *pubkey, timeout, followSetsDir)
+		followSets, err = fetchAndSaveFollowSets(ctx, followRelayURL, *pubkey, timeout, followSetsDir, *followSetFormat)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "warning: failed to get follow sets from %s: %v\n", followRelayURL, err)
 		} else {
@@ -124,6 +397,30 @@ func collectCmd(args []string) {
 		}
 	}
 
+	// Optionally narrow the follows to a single follow set
+	if *onlyFollowSet != "" {
+		members, ok := followSets[*onlyFollowSet]
+		if !ok {
+			var names []string
+			for dTag := range followSets {
+				names = append(names, dTag)
+			}
+			sort.Strings(names)
+			fmt.Fprintf(os.Stderr, "follow set %q not found (or empty); available: %s\n", *onlyFollowSet, strings.Join(names, ", "))
+			os.Exit(1)
+		}
+		follows = append([]string(nil), members...)
+		if *withContacts {
+			follows = append(follows, contactFollows...)
+		}
+		follows = deduplicateAndSort(follows)
+		fmt.Printf("    ✓ Restricted to follow set %q: %d follows\n", *onlyFollowSet, len(follows))
+	}
+	// Record the restriction so analyze only merges the selected set back into the follows list
+	if err := writeOnlyFollowSet(followSetsDir, *onlyFollowSet); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to record selected follow set: %v\n", err)
+	}
+
 	if len(follows) == 0 {
 		fmt.Println("    No follows found; nothing to do")
 		if err := writeLines(followsPath, nil); err != nil {
@@ -138,6 +435,58 @@ func collectCmd(args []string) {
 	}
 	fmt.Printf("    ✓ Total unique follows: %d\n", len(follows))
 
+	// Step 2c: Fetch the mute list (kind 10000) for analyze --exclude-muted
+	fmt.Println("\n==> Step 2c: Fetching your mute list (kind 10000)")
+	mutes, err := fetchMuteList(ctx, followRelayURL, *pubkey, timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to get your mute list from %s: %v\n", followRelayURL, err)
+	} else if err := writeLines(muteListPath, mutes.pubkeys); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write mute list: %v\n", err)
+	} else {
+		fmt.Printf("    ✓ Found %d muted pubkeys\n", len(mutes.pubkeys))
+		if mutes.invalid > 0 {
+			fmt.Printf("    ⚠ Skipped %d invalid p tags\n", mutes.invalid)
+		}
+		if mutes.encrypted {
+			fmt.Println("    ⚠ Private (encrypted) mute entries are not read; only public p tags are used")
+		}
+	}
+
+	// Step 2d: Optionally add follows-of-follows that enough follows trust
+	if *wotMin > 0 {
+		fmt.Printf("\n==> Step 2d: Fetching follows' follow lists (kind 3) for --wot-min %d\n", *wotMin)
+		contactLists := fetchContactLists(ctx, relays, chunkAuthors(follows, *batchSize), *parallel, timeout)
+		exitIfInterrupted(ctx)
+		exclude := set{strings.ToLower(*pubkey): {}}
+		for _, pk := range follows {
+			exclude.add(pk)
+		}
+		if mutes != nil {
+			for _, pk := range mutes.pubkeys {
+				exclude.add(pk)
+			}
+		}
+		candidates, qualified := wotCandidates(contactLists, exclude, *wotMin, *wotMaxCandidates)
+		fmt.Printf("    ✓ Follow lists of %d of %d follows\n", len(contactLists), len(follows))
+		if len(candidates) < qualified {
+			fmt.Printf("    ⚠ %d authors are followed by >= %d follows; keeping the %d most followed (--wot-max-candidates)\n",
+				qualified, *wotMin, len(candidates))
+		}
+		if err := writeWoTCandidates(wotPath, candidates); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to write %s: %v\n", wotPath, err)
+		}
+		direct := len(follows)
+		for _, c := range candidates {
+			follows = append(follows, c.pubkey)
+		}
+		follows = deduplicateAndSort(follows)
+		if err := writeLines(followsPath, follows); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write follows file: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("    ✓ WoT-expanded authors: %d (%d follows + %d follows-of-follows)\n", len(follows), direct, len(candidates))
+	}
+
 	// Save user pubkey for later use
 	if err := writeLines(userPubkeyPath, []string{strings.ToLower(*pubkey)}); err != nil {
 		fmt.Fprintf(os.Stderr, "warning: failed to write user pubkey file: %v\n", err)
@@ -146,48 +495,133 @@ func collectCmd(args []string) {
 	// Step 3: Fetch kind 10002 relay-list events for follows in batches across relays
 	fmt.Println("\n==> Step 3: Fetching kind 10002 relay lists for follows")
 
-	// Prepare output file for JSONL writes
-	jsonlFile, err := os.Create(jsonlPath)
+	// Relays that cap the filter limit below the batch size may silently drop relay lists
+	relayCache := loadRelayInfoCache(filepath.Join(dataDirectory, "relay_cache.json"))
+	if *cacheNIP11 {
+		relayCache.fetch(ctx, relays, *parallel, timeout, 24*time.Hour)
+		for _, r := range relays {
+			if e := relayCache.get(r); e != nil && e.Info != nil && e.Info.Limitation != nil {
+				if maxLimit := e.Info.Limitation.MaxLimit; maxLimit > 0 && maxLimit < *batchSize {
+					fmt.Fprintf(os.Stderr, "warning: %s caps filter limit at %d (< --batch-size %d); lower --batch-size to avoid missing relay lists\n", r, maxLimit, *batchSize)
+				}
+			}
+		}
+	}
+
+	// With --author-shard only this machine's share of the follows is fetched
+	if shard.n > 0 {
+		total := len(follows)
+		follows = shard.filter(follows)
+		fmt.Printf("    Author shard %d/%d: %d of %d follows (fnv32a(pubkey) %% %d == %d)\n",
+			shard.i, shard.n, len(follows), total, shard.n, shard.i-1)
+	}
+
+	// With --resume, keep the existing JSONL and only ask for follows without a relay list in it
+	fetchAuthors := follows
+	appendExisting := false
+	if *resume {
+		if _, err := os.Stat(jsonlPath); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: --resume: no %s to resume from; fetching all follows\n", jsonlPath)
+		} else {
+			fetchAuthors = followsWithoutRelayList(follows, jsonlPath)
+			appendExisting = true
+			fmt.Printf("    Resuming %s: %d of %d follows already have a relay list there, fetching the other %d\n",
+				jsonlPath, len(follows)-len(fetchAuthors), len(follows), len(fetchAuthors))
+		}
+	}
+
+	// Create batches and load any checkpoint from an interrupted run over the same batches
+	batches := chunkAuthors(follows, *batchSize)
+	fetchBatches := chunkAuthors(fetchAuthors, *batchSize)
+	var checkpoint *collectCheckpoint
+	if *useCheckpoint {
+		checkpoint = loadCheckpoint(checkpointPath, hashFollows(fetchAuthors), *batchSize)
+	}
+	resuming := checkpoint != nil && checkpoint.completedCount() > 0
+
+	// Prepare output file for JSONL writes; a resumed run appends to the existing file
+	var seenEvents *seenSet
+	if resuming || appendExisting {
+		seenEvents = newSeenSet(loadSeenEvents(jsonlPath))
+		if resuming {
+			fmt.Printf("    Resuming from checkpoint: %d relay batches already done, %d events on disk\n",
+				checkpoint.completedCount(), seenEvents.len())
+		}
+	} else {
+		seenEvents = newSeenSet(nil)
+	}
+	jsonlFile, err := openJSONL(jsonlPath, resuming || appendExisting)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create JSONL file: %v\n", err)
 		os.Exit(1)
 	}
 	defer jsonlFile.Close()
-	jsonlWriter := bufio.NewWriter(jsonlFile)
+	if *writeBufferKB < 4 {
+		*writeBufferKB = 4
+	}
+	jsonlWriter := bufio.NewWriterSize(jsonlFile, *writeBufferKB*1024)
 	defer jsonlWriter.Flush()
 
-	// Create batches and initialize progress tracking
-	batches := chunkAuthors(follows, *batchSize)
+	// Initialize progress tracking
 	progress := &progressTracker{
-		batchesTotal: len(batches),
+		batchesTotal: len(fetchBatches),
 		relaysTotal:  len(relays),
 	}
 
 	fmt.Printf("    Querying %d relays with %d batches of ~%d authors each\n",
-		len(relays), len(batches), *batchSize)
+		len(relays), len(fetchBatches), *batchSize)
 	fmt.Printf("    Parallel workers: %d\n", *parallel)
 	fmt.Println()
 
-	// Channel to serialize JSONL writes and deduplicate by event ID
-	eventChan := make(chan eventLine, 1024)
+	// Channel to serialize JSONL writes; fetchers drop duplicate events before queueing
+	if *writeQueue < 1 {
+		*writeQueue = 1
+	}
+	if *flushInterval < 1 {
+		*flushInterval = 1
+	}
+	eventChan := make(chan eventLine, *writeQueue)
 	writerDone := make(chan struct{})
-	seenEvents := make(map[string]struct{})
-	var seenMutex sync.Mutex
 
 	// Start writer goroutine
 	go func() {
-		for event := range eventChan {
-			progress.eventsReceived.Add(1)
-			seenMutex.Lock()
-			if _, exists := seenEvents[event.id]; !exists {
-				seenEvents[event.id] = struct{}{}
+		flush := time.NewTicker(time.Duration(*flushInterval) * time.Second)
+		defer flush.Stop()
+		dirty := false
+		for {
+			select {
+			case event, ok := <-eventChan:
+				if !ok {
+					jsonlWriter.Flush()
+					if dirty {
+						if err := checkpoint.save(); err != nil {
+							fmt.Fprintf(os.Stderr, "    ⚠ Failed to save checkpoint: %v\n", err)
+						}
+					}
+					close(writerDone)
+					return
+				}
+				if event.batchDone != nil {
+					// All events of this batch are already in the buffer, so the batch
+					// is recorded and saved together with the next flush
+					if checkpoint != nil {
+						checkpoint.markDone(event.batchDone.relay, event.batchDone.idx)
+						dirty = true
+					}
+					continue
+				}
 				fmt.Fprintln(jsonlWriter, event.line)
 				progress.eventsWritten.Add(1)
+			case <-flush.C:
+				jsonlWriter.Flush()
+				if dirty {
+					if err := checkpoint.save(); err != nil {
+						fmt.Fprintf(os.Stderr, "    ⚠ Failed to save checkpoint: %v\n", err)
+					}
+					dirty = false
+				}
 			}
-			seenMutex.Unlock()
 		}
-		jsonlWriter.Flush()
-		close(writerDone)
 	}()
 
 	// Start progress reporter
@@ -211,22 +645,80 @@ func collectCmd(args []string) {
 		}
 	}()
 
+	batchTimeout := timeout
+	if *batchTimeoutSec > 0 {
+		batchTimeout = time.Duration(*batchTimeoutSec) * time.Second
+	}
+	opts := &batchOptions{
+		timeout:      timeout,
+		batchTimeout: batchTimeout,
+		limit:        *batchLimit,
+		retryEmpty:   *retryEmpty,
+		retryTimeout: *retryTimeout,
+		maxEvents:    *eventsPerRelayLimit,
+		seen:         seenEvents,
+		stats:        relayCache,
+
+		timeoutOverrides: timeoutOverrides,
+		connectRetries:   *connectRetries,
+		auth:             auth,
+		failFast:         *failFast,
+		sources:          newSourceLog(),
+		annotateSource:   *annotateSource,
+		keepOlder:        *keepOlderLists,
+	}
+	reportTimeoutOverrides(relays, timeoutOverrides)
+	if *adaptiveTimeout {
+		opts.adaptive = true
+		opts.adaptiveMin = time.Duration(*adaptiveMin) * time.Second
+		opts.adaptiveMax = batchTimeout
+		if *adaptiveMax > 0 {
+			opts.adaptiveMax = time.Duration(*adaptiveMax) * time.Second
+		}
+	}
+	if *skipFound {
+		var minAt int64
+		if *foundMaxAgeDays > 0 {
+			minAt = time.Now().AddDate(0, 0, -*foundMaxAgeDays).Unix()
+		}
+		opts.found = newFoundSet(minAt)
+	}
+
 	// Process relays with semaphore for parallelism control
 	// Each relay gets one connection that handles all batches
 	semaphore := make(chan struct{}, *parallel)
 	var wg sync.WaitGroup
 
+	// With --fail-fast the first relay error cancels every other relay
+	fetchCtx, cancelFetch := context.WithCancel(ctx)
+	defer cancelFetch()
+	var failOnce sync.Once
+	var failErr error
+
 	for _, relayURL := range relays {
 		semaphore <- struct{}{}
+		if fetchCtx.Err() != nil {
+			<-semaphore
+			break
+		}
 		wg.Add(1)
 		go func(url string) {
 			defer wg.Done()
 			defer func() { <-semaphore }()
 
-			if err := fetchAllBatches(ctx, url, batches, timeout, eventChan, progress); err != nil {
-				// Log errors but continue with other relays
-				fmt.Fprintf(os.Stderr, "    ⚠ Error from %s: %v\n", url, err)
+			err := fetchAllBatches(fetchCtx, url, fetchBatches, opts, eventChan, progress, checkpoint)
+			if err == nil {
+				return
+			}
+			if *failFast {
+				failOnce.Do(func() {
+					failErr = fmt.Errorf("%s: %w", url, err)
+					cancelFetch()
+				})
+				return
 			}
+			// Log errors but continue with other relays
+			fmt.Fprintf(os.Stderr, "    ⚠ Error from %s: %v\n", url, err)
 		}(relayURL)
 	}
 
@@ -234,16 +726,230 @@ func collectCmd(args []string) {
 	close(eventChan)
 	<-writerDone
 	close(progressDone)
+	if auth != nil {
+		auth.wipe()
+	}
+
+	// Events received so far are flushed and the checkpoint kept, so a rerun resumes.
+	// Relay stats are not saved: cancelled batches would count as relay failures.
+	if ctx.Err() != nil {
+		jsonlFile.Close()
+		fmt.Fprintf(os.Stderr, "    %d events written to %s before stopping\n", progress.eventsWritten.Load(), jsonlPath)
+		exitIfInterrupted(ctx)
+	}
+	if failErr != nil {
+		if err := relayCache.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to save relay stats to relay cache: %v\n", err)
+		}
+		jsonlFile.Close()
+		fmt.Fprintf(os.Stderr, "error: --fail-fast: %v\n", failErr)
+		fmt.Fprintf(os.Stderr, "    %d events written to %s before stopping\n", progress.eventsWritten.Load(), jsonlPath)
+		os.Exit(1)
+	}
+
+	// Keep what this run observed about each relay for gen-router --learned-scores
+	if err := relayCache.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to save relay stats to relay cache: %v\n", err)
+	}
+
+	// Record every relay an event came from in seen_on, and drop superseded
+	// versions (with --keep-older-lists, only those no relay reported in
+	// this run, e.g. from an earlier run)
+	dropped, older, err := compactJSONL(jsonlPath, opts.sources, opts.keepOlder)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to annotate and compact %s: %v\n", jsonlPath, err)
+	} else {
+		if dropped > 0 {
+			fmt.Printf("    Dropped %d duplicate or superseded events from %s\n", dropped, jsonlPath)
+		}
+		if older > 0 {
+			fmt.Printf("    ⚠ Kept %d older relay list versions still served by some relays (see analyze's relay_list_conflicts.txt)\n", older)
+		}
+	}
+
+	// The checkpoint is only dropped once every relay finished every batch;
+	// otherwise a rerun retries the batches that failed or never ran
+	if checkpoint != nil && ctx.Err() == nil {
+		if checkpoint.covers(relays, len(fetchBatches)) {
+			if err := checkpoint.remove(); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to remove checkpoint: %v\n", err)
+			}
+		} else {
+			fmt.Printf("    Kept %s: some relay batches did not complete; rerun to retry them\n", checkpointPath)
+		}
+	}
+
+	// Optional extra pass: when did each follow last post?
+	activityPath := filepath.Join(dataDirectory, "follow_activity.txt")
+	if *activityDays > 0 {
+		fmt.Printf("\n==> Step 4: Fetching notes from the last %d days to measure follow activity\n", *activityDays)
+		latest := fetchActivity(ctx, relays, batches, *activityDays, *parallel, batchTimeout)
+		exitIfInterrupted(ctx)
+		if err := writeActivity(activityPath, *activityDays, latest); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to write %s: %v\n", activityPath, err)
+		} else {
+			fmt.Printf("    ✓ %d of %d follows posted in the last %d days\n", len(latest), len(follows), *activityDays)
+		}
+	}
+
+	// Optional extra pass: names for annotating router streams
+	namesPath := filepath.Join(dataDirectory, "pubkey_names.txt")
+	if *fetchProfiles {
+		fmt.Println("\n==> Fetching follows' profiles for stream annotations")
+		names := fetchProfileNames(ctx, relays, batches, *parallel, batchTimeout)
+		exitIfInterrupted(ctx)
+		if err := writePubkeyNames(namesPath, names); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to write %s: %v\n", namesPath, err)
+		} else {
+			fmt.Printf("    ✓ Names for %d of %d follows\n", len(names), len(follows))
+		}
+	}
+
+	// Optional sampling pass: do follows' relays still carry their recent notes?
+	livenessPath := filepath.Join(dataDirectory, "relay_liveness_sample.txt")
+	if *livenessSample > 0 {
+		source := "author_assignments.txt"
+		relaysByAuthor := make(map[string][]string)
+		for relay, authors := range loadAssignments(filepath.Join(dataDirectory, source)) {
+			for _, a := range authors {
+				relaysByAuthor[a] = append(relaysByAuthor[a], relay)
+			}
+		}
+		if len(relaysByAuthor) == 0 {
+			source = "relay lists (no author_assignments.txt yet)"
+			relaysByAuthor = writeRelaysByAuthor(jsonlPath)
+		}
+		sample := sampleAuthors(relaysByAuthor, *livenessSample)
+		fmt.Printf("\n==> Checking %d sampled follows for notes from the last %d days on their relays (%s)\n", len(sample), *livenessDays, source)
+		results := checkLiveness(ctx, relaysByAuthor, sample, *livenessDays, *parallel, batchTimeout, relayCache)
+		exitIfInterrupted(ctx)
+		counts := map[string]int{}
+		for _, r := range results {
+			counts[r.status]++
+		}
+		if err := writeLivenessSample(livenessPath, *livenessDays, source, results); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to write %s: %v\n", livenessPath, err)
+		} else {
+			fmt.Printf("    ✓ %d found, %d missing, %d unknown, %d unreachable of %d author/relay pairs\n",
+				counts[livenessFound], counts[livenessMissing], counts[livenessUnknown], counts[livenessUnreachable], len(results))
+		}
+	}
+
+	// Fetch NIP-11 for the write relays found, so later stages don't refetch
+	if *cacheNIP11 {
+		listed := relaysInJSONL(jsonlPath)
+		fmt.Printf("\n==> Caching NIP-11 documents for %d relays\n", len(listed))
+		fetched := relayCache.fetch(ctx, listed, 16, 5*time.Second, 24*time.Hour)
+		exitIfInterrupted(ctx)
+		if err := relayCache.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to save relay cache: %v\n", err)
+		} else {
+			fmt.Printf("    ✓ Fetched %d (others still fresh in cache)\n", fetched)
+		}
+	}
 
 	// Final summary
 	fmt.Println()
 	fmt.Println("==> Collection complete")
 	fmt.Printf("    ✓ Total events received: %d\n", progress.eventsReceived.Load())
 	fmt.Printf("    ✓ Unique events written: %d\n", progress.eventsWritten.Load())
+	if dups := progress.duplicates.Load(); dups > 0 {
+		fmt.Printf("    ⚠ Duplicate events resent within a subscription: %d (not counted as received)\n", dups)
+	}
+	if empty := progress.emptyBatches.Load(); empty > 0 {
+		fmt.Printf("    ⚠ Empty relay batches: %d (recovered by retrying with a limit: %d)\n", empty, progress.emptyRecovered.Load())
+	}
+	fmt.Printf("    ✓ Batch endings: %d EOSE, %d timeout without EOSE (partial results kept), %d event cap\n",
+		progress.endings[endEOSE].Load(), progress.endings[endTimeout].Load(), progress.endings[endCap].Load())
+	if auth != nil {
+		fmt.Printf("    ✓ NIP-42 AUTH: %d accepted, %d failed\n", auth.authenticated.Load(), auth.failed.Load())
+	}
+	if len(progress.timeoutsByRelay) > 0 {
+		if *retryTimeout {
+			fmt.Printf("    ✓ Timed-out batches fully recovered by --retry-timeout: %d\n", progress.timeoutRecovered.Load())
+		}
+		timedOut := make([]string, 0, len(progress.timeoutsByRelay))
+		for r := range progress.timeoutsByRelay {
+			timedOut = append(timedOut, r)
+		}
+		sort.Slice(timedOut, func(i, j int) bool {
+			a, b := progress.timeoutsByRelay[timedOut[i]], progress.timeoutsByRelay[timedOut[j]]
+			if a != b {
+				return a > b
+			}
+			return timedOut[i] < timedOut[j]
+		})
+		for _, r := range timedOut {
+			fmt.Printf("    ⏱ %s: %d batches timed out (slow relay, not an empty one)\n", r, progress.timeoutsByRelay[r])
+		}
+	}
+	if opts.found != nil {
+		fmt.Printf("    ✓ Skipped %d author lookups and %d REQs (relay list already found)\n",
+			progress.authorsSkipped.Load(), progress.batchesSkipped.Load())
+	}
 	fmt.Printf("    ✓ JSONL file: %s\n", jsonlPath)
+	if *activityDays > 0 {
+		fmt.Printf("    ✓ Follow activity: %s\n", activityPath)
+	}
+	if *fetchProfiles {
+		fmt.Printf("    ✓ Follow names: %s\n", namesPath)
+	}
+	if *livenessSample > 0 {
+		fmt.Printf("    ✓ Relay liveness sample: %s\n", livenessPath)
+	}
 	fmt.Printf("    ✓ Follows file: %s\n", followsPath)
 	fmt.Printf("    ✓ User relay list: %s\n", userRelayListPath)
 	fmt.Printf("    ✓ User pubkey: %s\n", userPubkeyPath)
+	fmt.Printf("    ✓ Follow relay hints: %s\n", relayHintsPath)
+}
+
+// parseRelayTimeouts parses "host=duration,..." into per-host timeouts.
+// Hosts may be given as relay URLs.
+func parseRelayTimeouts(s string) (map[string]time.Duration, error) {
+	m := map[string]time.Duration{}
+	for _, part := range splitCSV(s) {
+		host, value, ok := strings.Cut(part, "=")
+		host = urlToHost(strings.TrimSpace(host))
+		if !ok || host == "" {
+			return nil, fmt.Errorf("invalid override %q (want host=duration)", part)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration for %s: %v", host, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("duration for %s must be positive, got %s", host, d)
+		}
+		m[host] = d
+	}
+	return m, nil
+}
+
+// reportTimeoutOverrides prints the relays that use a per-host timeout and
+// warns about overrides that match none of them
+func reportTimeoutOverrides(relays []string, overrides map[string]time.Duration) {
+	if len(overrides) == 0 {
+		return
+	}
+	used := set{}
+	fmt.Println("    Per-host timeouts (--relay-timeout):")
+	for _, r := range relays {
+		host := urlToHost(r)
+		if d, ok := overrides[host]; ok {
+			used.add(host)
+			fmt.Printf("    ⏱ %s: %s\n", r, d)
+		}
+	}
+	var unused []string
+	for host := range overrides {
+		if !used.has(host) {
+			unused = append(unused, host)
+		}
+	}
+	sort.Strings(unused)
+	for _, host := range unused {
+		fmt.Fprintf(os.Stderr, "warning: --relay-timeout %s matches no queried relay\n", host)
+	}
 }
 
 func splitCSV(s string) []string {
@@ -258,17 +964,82 @@ func splitCSV(s string) []string {
 	return out
 }
 
-// fetchUserRelayList retrieves the user's own relay list (kind 10002) from a relay
-func fetchUserRelayList(ctx context.Context, relayURL, pubkey string, timeout time.Duration) ([]string, error) {
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+// authorShardSpec selects shard i (1-based) of n; n == 0 means no sharding
+type authorShardSpec struct {
+	i, n int
+}
 
-	relay, err := nostr.RelayConnect(ctx, relayURL)
+// parseAuthorShard parses "i/n" with 1 <= i <= n
+func parseAuthorShard(s string) (authorShardSpec, error) {
+	is, ns, ok := strings.Cut(s, "/")
+	i, errI := strconv.Atoi(strings.TrimSpace(is))
+	n, errN := strconv.Atoi(strings.TrimSpace(ns))
+	if !ok || errI != nil || errN != nil || n < 1 || i < 1 || i > n {
+		return authorShardSpec{}, fmt.Errorf("invalid shard %q (want i/n with 1 <= i <= n)", s)
+	}
+	return authorShardSpec{i: i, n: n}, nil
+}
+
+// suffix names the shard's output files, e.g. "shard-2-of-4"
+func (sh authorShardSpec) suffix() string {
+	return fmt.Sprintf("shard-%d-of-%d", sh.i, sh.n)
+}
+
+// filter keeps the pubkeys hashing to this shard. The hash only depends on
+// the pubkey, so the n shards are disjoint and together cover every follow.
+func (sh authorShardSpec) filter(pubkeys []string) []string {
+	var out []string
+	for _, pk := range pubkeys {
+		h := fnv.New32a()
+		h.Write([]byte(strings.ToLower(pk)))
+		if int(h.Sum32()%uint32(sh.n)) == sh.i-1 {
+			out = append(out, pk)
+		}
+	}
+	return out
+}
+
+// capRelays keeps at most n distinct relays. Relays the last probe reached
+// come first, relays it could not reach last; otherwise the given order is
+// kept. It returns the kept and the dropped relays.
+func capRelays(relays []string, n int, reachable map[string]bool) ([]string, []string) {
+	rank := func(r string) int {
+		up, probed := reachable[normalizeURL(r)]
+		switch {
+		case probed && up:
+			return 0
+		case probed:
+			return 2
+		}
+		return 1
+	}
+	seen := make(set)
+	var unique []string
+	for _, r := range relays {
+		if k := normalizeURL(r); !seen.has(k) {
+			seen.add(k)
+			unique = append(unique, r)
+		}
+	}
+	sort.SliceStable(unique, func(i, j int) bool { return rank(unique[i]) < rank(unique[j]) })
+	if len(unique) <= n {
+		return unique, nil
+	}
+	return unique[:n], unique[n:]
+}
+
+// fetchUserRelayList retrieves the user's own relay list (kind 10002) from a relay.
+// It also returns the NIP-65 marker ("read", "write" or "" for both) per relay URL.
+func fetchUserRelayList(ctx context.Context, relayURL, pubkey string, timeout time.Duration, connectRetries int) ([]string, map[string]string, error) {
+	relay, _, err := connectWithRetry(ctx, relayURL, timeout, connectRetries)
 	if err != nil {
-		return nil, fmt.Errorf("relay connect: %w", err)
+		return nil, nil, fmt.Errorf("relay connect: %w", err)
 	}
 	defer relay.Close()
 
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
 	filters := nostr.Filters{
 		nostr.Filter{
 			Kinds:   []int{10002},
@@ -279,18 +1050,19 @@ func fetchUserRelayList(ctx context.Context, relayURL, pubkey string, timeout ti
 
 	subscription, err := relay.Subscribe(ctx, filters)
 	if err != nil {
-		return nil, fmt.Errorf("subscribe: %w", err)
+		return nil, nil, fmt.Errorf("subscribe: %w", err)
 	}
 	defer subscription.Unsub()
 
 	var relays []string
+	markers := make(map[string]string)
 	for {
 		select {
 		case <-ctx.Done():
-			return deduplicateAndSort(relays), nil
+			return deduplicateAndSort(relays), markers, nil
 		case <-subscription.EndOfStoredEvents:
 			// Relay finished sending stored events
-			return deduplicateAndSort(relays), nil
+			return deduplicateAndSort(relays), markers, nil
 		case event := <-subscription.Events:
 			if event == nil {
 				continue
@@ -305,6 +1077,11 @@ func fetchUserRelayList(ctx context.Context, relayURL, pubkey string, timeout ti
 					// Only include valid relay URLs (no query params, etc)
 					if isValidRelayURL(relayURL) {
 						relays = append(relays, relayURL)
+						marker := ""
+						if len(tag) >= 3 {
+							marker = strings.ToLower(tag[2])
+						}
+						markers[relayURL] = marker
 					}
 				}
 			}
@@ -312,14 +1089,15 @@ func fetchUserRelayList(ctx context.Context, relayURL, pubkey string, timeout ti
 	}
 }
 
-// fetchFollows retrieves the follow list (kind 3) for a given pubkey from a relay
-func fetchFollows(ctx context.Context, relayURL, pubkey string, timeout time.Duration) ([]string, error) {
+// fetchFollows retrieves the follow list (kind 3) for a given pubkey from a relay.
+// It also returns the optional relay hint (p-tag index 2) per follow, keyed by pubkey.
+func fetchFollows(ctx context.Context, relayURL, pubkey string, timeout time.Duration) ([]string, map[string]string, error) {
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	relay, err := nostr.RelayConnect(ctx, relayURL)
 	if err != nil {
-		return nil, fmt.Errorf("relay connect: %w", err)
+		return nil, nil, fmt.Errorf("relay connect: %w", err)
 	}
 	defer relay.Close()
 
@@ -332,18 +1110,19 @@ func fetchFollows(ctx context.Context, relayURL, pubkey string, timeout time.Dur
 
 	subscription, err := relay.Subscribe(ctx, filters)
 	if err != nil {
-		return nil, fmt.Errorf("subscribe: %w", err)
+		return nil, nil, fmt.Errorf("subscribe: %w", err)
 	}
 	defer subscription.Unsub()
 
 	var follows []string
+	hints := make(map[string]string)
 	for {
 		select {
 		case <-ctx.Done():
-			return deduplicateAndSort(follows), nil
+			return deduplicateAndSort(follows), hints, nil
 		case <-subscription.EndOfStoredEvents:
 			// Relay finished sending stored events
-			return deduplicateAndSort(follows), nil
+			return deduplicateAndSort(follows), hints, nil
 		case event := <-subscription.Events:
 			if event == nil {
 				continue
@@ -351,28 +1130,50 @@ func fetchFollows(ctx context.Context, relayURL, pubkey string, timeout time.Dur
 			if event.Kind != 3 {
 				continue
 			}
-			// Extract p-tags (pubkeys being followed)
-			for _, tag := range event.Tags {
-				if len(tag) >= 2 && tag[0] == "p" {
-					pubkeyHex := strings.ToLower(tag[1])
-					if isHex64(pubkeyHex) {
-						follows = append(follows, pubkeyHex)
-					}
-				}
-			}
+			follows = append(follows, followsFromTags(event.Tags, hints)...)
+		}
+	}
+}
+
+// followsFromTags returns the pubkeys of the p-tags in tags as lowercase hex,
+// in tag order. When hints is not nil, a p-tag's relay hint (index 2) is
+// recorded in it under the pubkey.
+func followsFromTags(tags nostr.Tags, hints map[string]string) []string {
+	var follows []string
+	for _, tag := range tags {
+		if len(tag) < 2 || tag[0] != "p" {
+			continue
+		}
+		pubkeyHex, ok := pTagPubkey(tag[1])
+		if !ok {
+			continue
+		}
+		follows = append(follows, pubkeyHex)
+		if hints != nil && len(tag) >= 3 && isValidRelayURL(tag[2]) {
+			hints[pubkeyHex] = normalizeURL(tag[2])
 		}
 	}
+	return follows
 }
 
-// followSet represents a kind 30000 follow set with its identifier and pubkeys
+// followSet represents a kind 30000 follow set with its identifier and pubkeys.
+// dTag is the original identifier; name is its filename-safe form.
 type followSet struct {
 	dTag    string
+	name    string
 	title   string
 	pubkeys []string
 }
 
+// followSetJSON is the JSON form of a saved follow set
+type followSetJSON struct {
+	DTag    string   `json:"d_tag"`
+	Title   string   `json:"title,omitempty"`
+	Pubkeys []string `json:"pubkeys"`
+}
+
 // fetchAndSaveFollowSets retrieves follow sets (kind 30000) and saves each to a separate file
-func fetchAndSaveFollowSets(ctx context.Context, relayURL, pubkey string, timeout time.Duration, outputDir string) (map[string][]string, error) {
+func fetchAndSaveFollowSets(ctx context.Context, relayURL, pubkey string, timeout time.Duration, outputDir, format string) (map[string][]string, error) {
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
@@ -399,9 +1200,9 @@ func fetchAndSaveFollowSets(ctx context.Context, relayURL, pubkey string, timeou
 	for {
 		select {
 		case <-ctx.Done():
-			return saveFollowSets(sets, outputDir)
+			return saveFollowSets(sets, outputDir, format)
 		case <-subscription.EndOfStoredEvents:
-			return saveFollowSets(sets, outputDir)
+			return saveFollowSets(sets, outputDir, format)
 		case event := <-subscription.Events:
 			if event == nil {
 				continue
@@ -410,15 +1211,13 @@ func fetchAndSaveFollowSets(ctx context.Context, relayURL, pubkey string, timeou
 				continue
 			}
 
-			// Extract d-tag identifier
-			dTag := "unnamed"
+			// Extract d-tag identifier; sets are keyed by the original d-tag so that
+			// identifiers which sanitize to the same name stay separate
+			dTag := ""
 			title := ""
 			for _, tag := range event.Tags {
 				if len(tag) >= 2 && tag[0] == "d" {
-					dTag = sanitizeFilename(tag[1])
-					if dTag == "" {
-						dTag = "unnamed"
-					}
+					dTag = tag[1]
 				} else if len(tag) >= 2 && tag[0] == "title" {
 					title = tag[1]
 				}
@@ -428,30 +1227,45 @@ func fetchAndSaveFollowSets(ctx context.Context, relayURL, pubkey string, timeou
 			if sets[dTag] == nil {
 				sets[dTag] = &followSet{
 					dTag:    dTag,
+					name:    followSetName(dTag),
 					title:   title,
 					pubkeys: []string{},
 				}
 			}
 
 			// Extract p-tags (pubkeys in follow sets)
-			for _, tag := range event.Tags {
-				if len(tag) >= 2 && tag[0] == "p" {
-					pubkeyHex := strings.ToLower(tag[1])
-					if isHex64(pubkeyHex) {
-						sets[dTag].pubkeys = append(sets[dTag].pubkeys, pubkeyHex)
-					}
-				}
-			}
+			sets[dTag].pubkeys = append(sets[dTag].pubkeys, followsFromTags(event.Tags, nil)...)
 		}
 	}
 }
 
-// saveFollowSets writes each follow set to a separate file
-func saveFollowSets(sets map[string]*followSet, outputDir string) (map[string][]string, error) {
+// saveFollowSets writes each follow set to a separate file. format selects
+// plaintext (.txt), JSON (.json) or both.
+func saveFollowSets(sets map[string]*followSet, outputDir, format string) (map[string][]string, error) {
+	writeText, writeJSON := false, false
+	switch format {
+	case "", "text":
+		writeText = true
+	case "json":
+		writeJSON = true
+	case "both":
+		writeText, writeJSON = true, true
+	default:
+		return nil, fmt.Errorf("unknown follow set format %q (want text, json, or both)", format)
+	}
+
 	result := make(map[string][]string)
 	usedFilenames := make(map[string]bool)
 
-	for dTag, set := range sets {
+	// Visit sets in d-tag order so collision suffixes are stable across runs
+	var dTags []string
+	for dTag := range sets {
+		dTags = append(dTags, dTag)
+	}
+	sort.Strings(dTags)
+
+	for _, dTag := range dTags {
+		set := sets[dTag]
 		// Deduplicate and sort pubkeys
 		set.pubkeys = deduplicateAndSort(set.pubkeys)
 
@@ -460,13 +1274,13 @@ func saveFollowSets(sets map[string]*followSet, outputDir string) (map[string][]
 		}
 
 		// Create filename from d-tag with collision detection
-		baseFilename := fmt.Sprintf("follow_set_%s.txt", dTag)
+		baseFilename := fmt.Sprintf("follow_set_%s", set.name)
 		filename := baseFilename
 		counter := 1
 
 		// Handle filename collisions
 		for usedFilenames[filename] {
-			filename = fmt.Sprintf("follow_set_%s_%d.txt", dTag, counter)
+			filename = fmt.Sprintf("follow_set_%s_%d", set.name, counter)
 			counter++
 			if counter > 100 {
 				return nil, fmt.Errorf("too many filename collisions for d-tag: %s", dTag)
@@ -489,27 +1303,75 @@ func saveFollowSets(sets map[string]*followSet, outputDir string) (map[string][]
 			return nil, fmt.Errorf("security: attempted path traversal with d-tag: %s", set.dTag)
 		}
 
-		// Prepare file content with header
-		lines := []string{}
-		if set.title != "" {
-			lines = append(lines, fmt.Sprintf("# %s", set.title))
+		if writeText {
+			// Prepare file content with header
+			lines := []string{}
+			if set.title != "" {
+				lines = append(lines, fmt.Sprintf("# %s", headerLine(set.title)))
+			}
+			lines = append(lines, fmt.Sprintf("# d-tag: %s", headerLine(set.dTag)))
+			lines = append(lines, fmt.Sprintf("# pubkeys: %d", len(set.pubkeys)))
+			lines = append(lines, "#")
+			lines = append(lines, set.pubkeys...)
+
+			if err := writeLines(filePath+".txt", lines); err != nil {
+				return nil, fmt.Errorf("failed to write %s.txt: %w", filename, err)
+			}
+			fmt.Printf("      - %s.txt (%d pubkeys)\n", filename, len(set.pubkeys))
 		}
-		lines = append(lines, fmt.Sprintf("# d-tag: %s", set.dTag))
-		lines = append(lines, fmt.Sprintf("# pubkeys: %d", len(set.pubkeys)))
-		lines = append(lines, "#")
-		lines = append(lines, set.pubkeys...)
 
-		if err := writeLines(filePath, lines); err != nil {
-			return nil, fmt.Errorf("failed to write %s: %w", filename, err)
+		if writeJSON {
+			b, err := json.MarshalIndent(followSetJSON{DTag: set.dTag, Title: set.title, Pubkeys: set.pubkeys}, "", "  ")
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode %s.json: %w", filename, err)
+			}
+			if err := os.WriteFile(filePath+".json", append(b, '\n'), 0o644); err != nil {
+				return nil, fmt.Errorf("failed to write %s.json: %w", filename, err)
+			}
+			fmt.Printf("      - %s.json (%d pubkeys)\n", filename, len(set.pubkeys))
 		}
 
-		fmt.Printf("      - %s (%d pubkeys)\n", filename, len(set.pubkeys))
 		result[dTag] = set.pubkeys
 	}
 
 	return result, nil
 }
 
+// onlyFollowSetFile names the marker in follow_sets/ holding the d-tag selected by --only-follow-set
+const onlyFollowSetFile = "only_follow_set"
+
+// writeOnlyFollowSet records dTag as the selected follow set, or clears the marker when dTag is empty
+func writeOnlyFollowSet(followSetsDir, dTag string) error {
+	path := filepath.Join(followSetsDir, onlyFollowSetFile)
+	if dTag == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(path, []byte(dTag), 0o644)
+}
+
+// followSetName returns the filename stem for a follow set d-tag. When sanitizing
+// loses information (unicode, case, length), a short hash of the original d-tag
+// is appended so distinct sets get distinct, stable names.
+func followSetName(dTag string) string {
+	name := sanitizeFilename(dTag)
+	if name == "" {
+		name = "unnamed"
+	}
+	if dTag != "" && name != dTag {
+		sum := sha256.Sum256([]byte(dTag))
+		name = fmt.Sprintf("%s_%s", name, hex.EncodeToString(sum[:4]))
+	}
+	return name
+}
+
+// headerLine flattens a value onto one line for use in a "#" file header
+func headerLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
 // sanitizeFilename removes or replaces characters that are unsafe for filenames
 func sanitizeFilename(s string) string {
 	s = strings.TrimSpace(s)
@@ -587,24 +1449,146 @@ func sanitizeFilename(s string) string {
 }
 
 // fetchAllBatches opens one connection to a relay and processes all batches sequentially
-func fetchAllBatches(ctx context.Context, relayURL string, batches [][]string, timeout time.Duration,
-	out chan<- eventLine, progress *progressTracker) error {
+// Batches already recorded in checkpoint (may be nil) are skipped.
+func fetchAllBatches(ctx context.Context, relayURL string, batches [][]string, opts *batchOptions,
+	out chan<- eventLine, progress *progressTracker, checkpoint *collectCheckpoint) error {
+
+	// Skip batches finished by a previous run; don't connect at all if none are left
+	var pending []int
+	for batchIdx := range batches {
+		if checkpoint != nil && checkpoint.isDone(relayURL, batchIdx) {
+			progress.batchesDone.Add(1)
+			continue
+		}
+		pending = append(pending, batchIdx)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
 
-	// Connect once to the relay
-	connectCtx, connectCancel := context.WithTimeout(ctx, timeout)
-	defer connectCancel()
+	// Timeouts may be overridden or adapted for this relay, so work on a copy
+	relayOpts := *opts
+	opts = &relayOpts
+	adapted := false
+	if d, ok := opts.timeoutOverrides[urlToHost(relayURL)]; ok {
+		opts.timeout, opts.batchTimeout = d, d
+		// A fixed override is what the operator asked for; don't adapt it
+		opts.adaptive = false
+	}
 
-	relay, err := nostr.RelayConnect(connectCtx, relayURL)
+	// Connect once to the relay
+	relay, connectTime, err := connectWithRetry(ctx, relayURL, opts.timeout, opts.connectRetries)
 	if err != nil {
+		if opts.stats != nil {
+			opts.stats.recordRun(relayURL, false, 0, 0, 0)
+		}
 		return fmt.Errorf("relay connect: %w", err)
 	}
 	defer relay.Close()
 
+	// Batches actually queried, for the relay's stats in relay_cache.json
+	queried, yielded, relayEvents := 0, 0, 0
+	if opts.stats != nil {
+		defer func() { opts.stats.recordRun(relayURL, true, queried, yielded, relayEvents) }()
+	}
+
 	// Process each batch with a new subscription on the same connection
-	for batchIdx, authors := range batches {
-		if err := fetchBatch(ctx, relay, relayURL, authors, batchIdx, timeout, out); err != nil {
+	for i, batchIdx := range pending {
+		// Stop early on relays flooding us with events (spam or misconfiguration)
+		budget := 0
+		if opts.maxEvents > 0 {
+			budget = opts.maxEvents - relayEvents
+			if budget <= 0 {
+				fmt.Fprintf(os.Stderr, "    ⚠ %s reached the cap of %d events; skipping its remaining %d batches\n",
+					relayURL, opts.maxEvents, len(pending)-i)
+				progress.batchesDone.Add(int64(len(pending) - i))
+				return nil
+			}
+		}
+
+		authors := batches[batchIdx]
+		if opts.found != nil {
+			missing := opts.found.missing(authors)
+			progress.authorsSkipped.Add(int64(len(authors) - len(missing)))
+			if len(missing) == 0 {
+				// Everyone in the batch already has a relay list; nothing to ask this relay
+				progress.batchesSkipped.Add(1)
+				out <- eventLine{batchDone: &batchKey{relay: relayURL, idx: batchIdx}}
+				progress.batchesDone.Add(1)
+				continue
+			}
+			authors = missing
+		}
+		batchStart := time.Now()
+		// Retries of this batch only count events the earlier attempts missed
+		got := make(map[string]struct{})
+		n, dups, end, err := fetchBatch(ctx, relay, relayURL, authors, batchIdx, opts.limit, budget, got, opts, out)
+		progress.duplicates.Add(int64(dups))
+		if err == nil && n == 0 {
+			progress.emptyBatches.Add(1)
+			// Some relays return no stored events unless the filter has a limit
+			if opts.limit < 0 && opts.retryEmpty {
+				n, dups, end, err = fetchBatch(ctx, relay, relayURL, authors, batchIdx, len(authors), budget, got, opts, out)
+				progress.duplicates.Add(int64(dups))
+				if err == nil && n > 0 {
+					progress.emptyRecovered.Add(1)
+				}
+			}
+		}
+		if err == nil && ctx.Err() == nil {
+			// A batch cancelled by --fail-fast did not end on its own
+			progress.recordEnd(relayURL, end)
+		}
+		// A relay too slow to finish the whole batch may finish smaller ones;
+		// events from the first attempt are kept, repeats are deduplicated
+		if err == nil && end == endTimeout && opts.retryTimeout && len(authors) > 1 && ctx.Err() == nil {
+			recovered := true
+			for _, half := range [][]string{authors[:len(authors)/2], authors[len(authors)/2:]} {
+				halfBudget := 0
+				if budget > 0 {
+					if halfBudget = budget - n; halfBudget <= 0 {
+						recovered = false
+						break
+					}
+				}
+				m, d, halfEnd, halfErr := fetchBatch(ctx, relay, relayURL, half, batchIdx, opts.limit, halfBudget, got, opts, out)
+				n += m
+				progress.duplicates.Add(int64(d))
+				if halfErr != nil || halfEnd != endEOSE {
+					recovered = false
+				}
+			}
+			if recovered {
+				progress.timeoutRecovered.Add(1)
+			}
+		}
+		relayEvents += n
+		queried++
+		if n > 0 {
+			yielded++
+		}
+		progress.eventsReceived.Add(int64(n))
+		if opts.adaptive && !adapted {
+			adaptBatchTimeout(opts, relayURL, connectTime, time.Since(batchStart), err)
+			adapted = true
+		}
+		if budget > 0 && n >= budget {
+			// The batch was cut short; leave it out of the checkpoint
+			progress.batchesDone.Add(1)
+			continue
+		}
+		if ctx.Err() != nil {
+			// Cancelled mid-batch (--fail-fast); the batch is incomplete
+			return ctx.Err()
+		}
+		if err != nil && opts.failFast {
+			return fmt.Errorf("batch %d: %w", batchIdx+1, err)
+		}
+		if err != nil {
 			// Log error but continue with next batch
 			fmt.Fprintf(os.Stderr, "    ⚠ Error from %s batch %d: %v\n", relayURL, batchIdx+1, err)
+		} else {
+			out <- eventLine{batchDone: &batchKey{relay: relayURL, idx: batchIdx}}
 		}
 		progress.batchesDone.Add(1)
 	}
@@ -612,9 +1596,43 @@ func fetchAllBatches(ctx context.Context, relayURL string, batches [][]string, t
 	return nil
 }
 
-// fetchBatch retrieves kind 10002 events for a batch of authors using an existing relay connection
+// adaptiveFactor is the headroom given over a relay's observed connect+EOSE time
+const adaptiveFactor = 3
+
+// adaptBatchTimeout sets opts.batchTimeout from the first batch's latency,
+// clamped to [adaptiveMin, adaptiveMax]. A first batch that failed or ran
+// into its timeout (no EOSE) keeps the fixed timeout.
+func adaptBatchTimeout(opts *batchOptions, relayURL string, connect, firstBatch time.Duration, err error) {
+	if err != nil || firstBatch >= opts.batchTimeout {
+		fmt.Printf("    ⏱ %s: keeping batch timeout %s (first batch did not finish early)\n", relayURL, opts.batchTimeout)
+		return
+	}
+	t := adaptiveFactor * (connect + firstBatch)
+	if t < opts.adaptiveMin {
+		t = opts.adaptiveMin
+	}
+	if opts.adaptiveMax > 0 && t > opts.adaptiveMax {
+		t = opts.adaptiveMax
+	}
+	t = t.Round(100 * time.Millisecond)
+	fmt.Printf("    ⏱ %s: batch timeout %s (connect %s, first batch %s)\n",
+		relayURL, t, connect.Round(time.Millisecond), firstBatch.Round(time.Millisecond))
+	opts.batchTimeout = t
+}
+
+// fetchBatch retrieves kind 10002 events for a batch of authors using an existing relay connection.
+// limit 0 sets the filter limit to the author count, a negative limit omits it.
+// The subscription is closed once maxEvents events arrived (<=0 means no cap).
+// The subscription is bounded by opts.batchTimeout. Events already in opts.seen
+// (by ID, or an equal or newer version of a replaceable event) are counted but
+// not queued; authors are recorded in opts.found.
+// Events whose ID is already in got (from an earlier attempt at the same
+// batch) are skipped; new IDs are added to it. got may be nil.
+// It returns the number of unique events received, how many events the
+// relay resent within the subscription (ignored before counting), and why the
+// subscription ended. Events received before a timeout are kept.
 func fetchBatch(ctx context.Context, relay *nostr.Relay, relayURL string, authors []string, batchIdx int,
-	timeout time.Duration, out chan<- eventLine) error {
+	limit, maxEvents int, got map[string]struct{}, opts *batchOptions, out chan<- eventLine) (received, duplicates int, end batchEnd, err error) {
 
 	// Validate and normalize authors to ensure all are 64-char hex
 	validAuthors := make([]string, 0, len(authors))
@@ -626,11 +1644,15 @@ func fetchBatch(ctx context.Context, relay *nostr.Relay, relayURL string, author
 	}
 
 	if len(validAuthors) == 0 {
-		return nil
+		return 0, 0, endEOSE, nil
+	}
+	if limit == 0 {
+		// One replaceable 10002 per author
+		limit = len(validAuthors)
 	}
 
 	// Create a timeout context for this batch
-	batchCtx, cancel := context.WithTimeout(ctx, timeout)
+	batchCtx, cancel := context.WithTimeout(ctx, opts.batchTimeout)
 	defer cancel()
 
 	filters := nostr.Filters{
@@ -639,20 +1661,54 @@ func fetchBatch(ctx context.Context, relay *nostr.Relay, relayURL string, author
 			Authors: validAuthors,
 		},
 	}
+	if limit > 0 {
+		filters[0].Limit = limit
+	}
 
 	subscription, err := relay.Subscribe(batchCtx, filters)
 	if err != nil {
-		return fmt.Errorf("subscribe: %w", err)
+		return 0, 0, endEOSE, fmt.Errorf("subscribe: %w", err)
 	}
-	defer subscription.Unsub()
+	defer func() { subscription.Unsub() }()
+
+	// Without --sec a CLOSED is not watched for, and the batch waits for its
+	// timeout as before. With it, an auth-required CLOSED is answered once;
+	// a CLOSED of the repeated subscription ends the batch.
+	var closed chan string
+	if opts.auth != nil {
+		closed = subscription.ClosedReason
+	}
+	authed := false
 
+	// Some relays resend an event within one subscription; opts.seen still
+	// guards the output across subscriptions and relays
+	subSeen := make(map[string]struct{})
 	for {
 		select {
 		case <-batchCtx.Done():
-			return nil
+			return received, duplicates, endTimeout, nil
+		case reason := <-closed:
+			closed = nil
+			if authed {
+				return received, duplicates, endEOSE, fmt.Errorf("closed after NIP-42 auth: %s", reason)
+			}
+			if !authRequired(reason) {
+				continue
+			}
+			if err := opts.auth.authenticate(batchCtx, relay); err != nil {
+				return received, duplicates, endEOSE, fmt.Errorf("NIP-42 auth: %w", err)
+			}
+			resub, err := relay.Subscribe(batchCtx, filters)
+			if err != nil {
+				return received, duplicates, endEOSE, fmt.Errorf("subscribe after auth: %w", err)
+			}
+			subscription.Unsub()
+			subscription = resub
+			closed = resub.ClosedReason
+			authed = true
 		case <-subscription.EndOfStoredEvents:
 			// Relay finished sending stored events, exit early
-			return nil
+			return received, duplicates, endEOSE, nil
 		case event := <-subscription.Events:
 			if event == nil {
 				continue
@@ -660,10 +1716,43 @@ func fetchBatch(ctx context.Context, relay *nostr.Relay, relayURL string, author
 			if event.Kind != 10002 {
 				continue
 			}
-			line := event.String()
-			out <- eventLine{
-				id:   strings.ToLower(event.ID),
-				line: line,
+			id := strings.ToLower(event.ID)
+			if _, dup := subSeen[id]; dup {
+				duplicates++
+				continue
+			}
+			subSeen[id] = struct{}{}
+			if _, ok := got[id]; ok {
+				continue
+			}
+			if got != nil {
+				got[id] = struct{}{}
+			}
+			received++
+			if opts.found != nil {
+				opts.found.add(strings.ToLower(event.PubKey), int64(event.CreatedAt))
+			}
+			// With --keep-older-lists, older versions are written too when
+			// first seen: a relay still serving one is what analyze reports
+			// as a relay list conflict
+			firstSeen := opts.sources.record(id, relayURL)
+			key := dedupKey(id, event.PubKey, event.Kind, event.Tags.GetD())
+			if opts.seen.add(key, seenVersion{createdAt: int64(event.CreatedAt), id: id}) || (opts.keepOlder && firstSeen) {
+				line := event.String()
+				var annotated string
+				var err error
+				if opts.annotateSource {
+					annotated, err = envelopeLine(relayURL, line)
+				} else {
+					annotated, err = withSeenOn(line, []string{relayURL})
+				}
+				if err == nil {
+					line = annotated
+				}
+				out <- eventLine{id: id, line: line}
+			}
+			if maxEvents > 0 && received >= maxEvents {
+				return received, duplicates, endCap, nil
 			}
 		}
 	}
@@ -703,3 +1792,71 @@ func chunkAuthors(authors []string, batchSize int) [][]string {
 	}
 	return batches
 }
+
+// followsWithoutRelayList returns the follows, in order, that have no kind
+// 10002 in the JSONL file at path; these are what --resume still fetches
+func followsWithoutRelayList(follows []string, path string) []string {
+	have := relayListAuthorsInJSONL(path)
+	var missing []string
+	for _, pk := range follows {
+		if !have.has(pk) {
+			missing = append(missing, pk)
+		}
+	}
+	return missing
+}
+
+// openJSONL opens the JSONL output, appending to an existing file when
+// resuming and truncating it otherwise
+func openJSONL(path string, appendExisting bool) (*os.File, error) {
+	if appendExisting {
+		return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	}
+	return os.Create(path)
+}
+
+// relayListAuthorsInJSONL returns the authors with a kind 10002 in a JSONL file
+func relayListAuthorsInJSONL(path string) set {
+	authors := set{}
+	lines, err := readLines(path)
+	if err != nil {
+		return authors
+	}
+	for _, line := range lines {
+		ev, err := parseEventLine([]byte(line))
+		if err != nil || ev.Kind != 10002 {
+			continue
+		}
+		authors.add(strings.ToLower(ev.PubKey))
+	}
+	return authors
+}
+
+// relaysInJSONL returns the distinct valid write/unmarked r-tag URLs in a JSONL of 10002 events
+func relaysInJSONL(path string) []string {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil
+	}
+	found := set{}
+	for _, line := range lines {
+		ev, err := parseEventLine([]byte(line))
+		if err != nil {
+			continue
+		}
+		for _, tag := range ev.Tags {
+			if len(tag) < 2 || tag[0] != "r" || (len(tag) >= 3 && strings.ToLower(tag[2]) == "read") {
+				continue
+			}
+			if url := normalizeURL(tag[1]); isValidRelayURL(url) {
+				found.add(url)
+			}
+		}
+	}
+	out := make([]string, 0, len(found))
+	for url := range found {
+		out = append(out, url)
+	}
+	sort.Strings(out)
+	return out
+}
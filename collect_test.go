@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	nostr "github.com/nbd-wtf/go-nostr"
+)
+
+func TestFollowsFromTagsHexAndNpub(t *testing.T) {
+	other := "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"
+	tags := nostr.Tags{
+		{"p", other, "wss://Relay.Example.com/"},
+		{"p", testNpub},
+		// The same follow again, once as uppercase hex
+		{"p", "7E7E9C42A91BFEF19FA929E5FDA1B72E0EBC1A4C1141673E2794234D86ADDF4E"},
+		{"p", "not-a-pubkey"},
+		{"e", other},
+		{"p"},
+	}
+	hints := map[string]string{}
+	got := deduplicateAndSort(followsFromTags(tags, hints))
+	want := []string{other, testPubkeyHex}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("follows = %v, want %v", got, want)
+	}
+	if len(hints) != 1 || hints[other] != "wss://relay.example.com" {
+		t.Errorf("hints = %v, want only %s", hints, other)
+	}
+	// Follow sets pass no hints map
+	if got := followsFromTags(tags, nil); len(got) != 3 {
+		t.Errorf("without hints: %v", got)
+	}
+}
+
+func TestFollowSetNameUnicodeAndLong(t *testing.T) {
+	long := strings.Repeat("close-friends-", 20)
+	dTags := []string{
+		"🎉 party people",
+		"🎸 party people",
+		"日本の友達",
+		"中国朋友",
+		long + "a",
+		long + "b",
+		"Friends",
+		"friends",
+	}
+	seen := map[string]string{}
+	for _, d := range dTags {
+		name := followSetName(d)
+		if name != followSetName(d) {
+			t.Errorf("%q: name not stable", d)
+		}
+		if prev, ok := seen[name]; ok {
+			t.Errorf("%q and %q both named %s", prev, d, name)
+		}
+		seen[name] = d
+		if len(name) > 40+1+8 {
+			t.Errorf("%q: name %s is %d chars", d, name, len(name))
+		}
+		for _, r := range name {
+			if r > 126 || r == '/' || r == ' ' {
+				t.Errorf("%q: unsafe character %q in %s", d, r, name)
+			}
+		}
+	}
+	if got := followSetName("friends"); got != "friends" {
+		t.Errorf("plain d-tag renamed to %s", got)
+	}
+	if got := followSetName("🎉 party people"); !strings.HasPrefix(got, "party_people_") {
+		t.Errorf("unicode d-tag named %s", got)
+	}
+	if got := followSetName("日本の友達"); !strings.HasPrefix(got, "unnamed_") {
+		t.Errorf("all-unicode d-tag named %s", got)
+	}
+}
+
+func TestSaveFollowSetsUnicodeHeaders(t *testing.T) {
+	dir := t.TempDir()
+	long := strings.Repeat("x", 300)
+	sets := map[string]*followSet{}
+	for _, fs := range []struct{ dTag, title string }{
+		{"🎉 party", "Party 🎉"},
+		{"🎸 party", "Band\nmates"},
+		{long, ""},
+	} {
+		sets[fs.dTag] = &followSet{dTag: fs.dTag, name: followSetName(fs.dTag), title: fs.title, pubkeys: []string{testPubkeyHex}}
+	}
+	captureOutput(t, &os.Stdout, func() {
+		if _, err := saveFollowSets(sets, dir, "text"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for dTag, s := range sets {
+		lines, err := readLines(filepath.Join(dir, "follow_set_"+s.name+".txt"))
+		if err != nil {
+			t.Fatalf("%q: %v", dTag, err)
+		}
+		header := strings.Join(lines, "\n")
+		if !strings.Contains(header, "# d-tag: "+headerLine(dTag)) {
+			t.Errorf("%q: header lacks the original d-tag:\n%s", dTag, header)
+		}
+		if s.title != "" && !strings.Contains(header, "# "+headerLine(s.title)) {
+			t.Errorf("%q: header lacks the title:\n%s", dTag, header)
+		}
+	}
+}
+
+func TestFetchBatchSkipsEarlierAttempts(t *testing.T) {
+	sk1, sk2 := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	pk1, _ := nostr.GetPublicKey(sk1)
+	pk2, _ := nostr.GetPublicKey(sk2)
+	list1 := signedEvent(t, sk1, 10002, 1, nostr.Tags{{"r", "wss://a.example.com"}}, "")
+	list2 := signedEvent(t, sk2, 10002, 1, nostr.Tags{{"r", "wss://b.example.com"}}, "")
+	url, _ := fakeRelay(t, list1, list2)
+
+	ctx := context.Background()
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	opts := &batchOptions{batchTimeout: 5 * time.Second, seen: newSeenSet(nil), sources: newSourceLog()}
+	out := make(chan eventLine, 10)
+
+	// A half-size retry only counts events the first attempt missed
+	got := map[string]struct{}{list1.ID: {}}
+	for _, half := range [][]string{{pk1}, {pk2}} {
+		received, _, end, err := fetchBatch(ctx, relay, url, half, 0, 0, 0, got, opts, out)
+		if err != nil || end != endEOSE {
+			t.Fatalf("%v: end %v, %v", half, end, err)
+		}
+		if want := map[string]int{pk1: 0, pk2: 1}[half[0]]; received != want {
+			t.Errorf("%v: received %d, want %d", half, received, want)
+		}
+	}
+	if _, ok := got[list2.ID]; !ok || len(got) != 2 {
+		t.Errorf("got %v, want both lists", got)
+	}
+	if len(out) != 1 {
+		t.Errorf("queued %d lines, want 1", len(out))
+	}
+}
+
+func TestResumeFetchesOnlyNewFollows(t *testing.T) {
+	pkA, pkB, pkC := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)
+	path := filepath.Join(t.TempDir(), "all_relay_lists.jsonl")
+	existing := []string{
+		`{"kind":10002,"id":"01","pubkey":"` + pkA + `","created_at":1,"tags":[["r","wss://a.example.com"]]}`,
+		`{"relay":"wss://x.example.com","event":{"kind":10002,"id":"02","pubkey":"` + strings.ToUpper(pkB) + `","created_at":1,"tags":[]}}`,
+	}
+	if err := writeLines(path, existing); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the follow without a relay list in the file is batched
+	fetch := followsWithoutRelayList([]string{pkA, pkB, pkC}, path)
+	if batches := chunkAuthors(fetch, 50); !reflect.DeepEqual(batches, [][]string{{pkC}}) {
+		t.Fatalf("batches %v, want only the third follow", batches)
+	}
+
+	// The existing lines are kept and new events appended
+	f, err := openJSONL(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := `{"kind":10002,"id":"03","pubkey":"` + pkC + `","created_at":1,"tags":[]}`
+	if _, err := f.WriteString(added + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readLines(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(existing, added); !reflect.DeepEqual(got, want) {
+		t.Errorf("file holds\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCheckpointCovers(t *testing.T) {
+	c := loadCheckpoint(filepath.Join(t.TempDir(), "collect_checkpoint.json"), "hash", 50)
+	relays := []string{"wss://r1", "wss://r2"}
+	c.markDone("wss://r1", 0)
+	c.markDone("wss://r1", 1)
+	c.markDone("wss://r2", 0)
+	if c.covers(relays, 2) {
+		t.Error("covers with wss://r2 batch 1 missing")
+	}
+	c.markDone("wss://r2", 1)
+	if !c.covers(relays, 2) {
+		t.Error("does not cover with every batch done")
+	}
+}
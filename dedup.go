@@ -0,0 +1,199 @@
+package main
+
+import (
+	"hash/maphash"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// seenShards is the number of independently locked shards in a seenSet
+const seenShards = 64
+
+// seenVersion is the version of an event kept for one dedup key
+type seenVersion struct {
+	createdAt int64
+	id        string
+}
+
+// newer reports whether v replaces old under NIP-01 rules: the later
+// created_at wins, and on a tie the lowest ID
+func (v seenVersion) newer(old seenVersion) bool {
+	if v.createdAt != old.createdAt {
+		return v.createdAt > old.createdAt
+	}
+	return v.id < old.id
+}
+
+// latestEventsByPubkey returns the newest event per author (lowercase pubkey),
+// picked by addRelayListVersion exactly as analyze picks relay lists. It is
+// meant for replaceable events of one kind, such as kind 10002 relay lists;
+// callers filter the kind.
+func latestEventsByPubkey(events []Event) map[string]Event {
+	lists := make(map[string]relayListVersion, len(events))
+	older := make(map[string][]relayListVersion)
+	byID := make(map[string]Event, len(events))
+	for _, ev := range events {
+		id := strings.ToLower(ev.ID)
+		byID[id] = ev
+		addRelayListVersion(lists, older, strings.ToLower(ev.PubKey), relayListVersion{ID: id, CreatedAt: ev.CreatedAt})
+	}
+	latest := make(map[string]Event, len(lists))
+	for pk, v := range lists {
+		latest[pk] = byID[v.ID]
+	}
+	return latest
+}
+
+// seenSet is a concurrent set of event dedup keys (see dedupKey). Keys are
+// spread over shards with their own locks so parallel relay fetchers rarely
+// contend.
+type seenSet struct {
+	seed   maphash.Seed
+	shards [seenShards]struct {
+		mu   sync.Mutex
+		keys map[string]seenVersion
+	}
+}
+
+// newSeenSet returns a set preloaded with keys (which may be nil)
+func newSeenSet(keys map[string]seenVersion) *seenSet {
+	s := &seenSet{seed: maphash.MakeSeed()}
+	for i := range s.shards {
+		s.shards[i].keys = make(map[string]seenVersion)
+	}
+	for k, v := range keys {
+		s.add(k, v)
+	}
+	return s
+}
+
+// add records an event version under key and reports whether it should be
+// written: the key is new, or v is newer than the version seen so far
+func (s *seenSet) add(key string, v seenVersion) bool {
+	sh := &s.shards[maphash.String(s.seed, key)%seenShards]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	old, ok := sh.keys[key]
+	if ok && (old.id == v.id || !v.newer(old)) {
+		return false
+	}
+	sh.keys[key] = v
+	return true
+}
+
+// len returns the number of keys in the set
+func (s *seenSet) len() int {
+	n := 0
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+		n += len(s.shards[i].keys)
+		s.shards[i].mu.Unlock()
+	}
+	return n
+}
+
+// dedupKey returns the identity of an event for deduplication: pubkey and
+// kind for replaceable kinds (0, 3, 10000-19999), pubkey, kind and d tag for
+// parameterized replaceable kinds (30000-39999), and the event ID otherwise
+func dedupKey(id, pubkey string, kind int, dTag string) string {
+	pubkey = strings.ToLower(pubkey)
+	switch {
+	case kind == 0 || kind == 3 || (kind >= 10000 && kind < 20000):
+		return pubkey + ":" + strconv.Itoa(kind)
+	case kind >= 30000 && kind < 40000:
+		return pubkey + ":" + strconv.Itoa(kind) + ":" + dTag
+	default:
+		return strings.ToLower(id)
+	}
+}
+
+// eventDedupKey is dedupKey for a decoded JSONL event
+func eventDedupKey(ev Event) string {
+	d := ""
+	for _, tag := range ev.Tags {
+		if len(tag) >= 2 && tag[0] == "d" {
+			d = tag[1]
+			break
+		}
+	}
+	return dedupKey(ev.ID, ev.PubKey, ev.Kind, d)
+}
+
+// loadSeenEvents returns the dedup keys of events already present in a JSONL
+// file with the newest version of each
+func loadSeenEvents(path string) map[string]seenVersion {
+	seen := make(map[string]seenVersion)
+	lines, err := readLines(path)
+	if err != nil {
+		return seen
+	}
+	for _, line := range lines {
+		ev, err := parseEventLine([]byte(line))
+		if err != nil || ev.ID == "" {
+			continue
+		}
+		key := eventDedupKey(ev)
+		v := seenVersion{createdAt: ev.CreatedAt, id: strings.ToLower(ev.ID)}
+		if old, ok := seen[key]; !ok || v.newer(old) {
+			seen[key] = v
+		}
+	}
+	return seen
+}
+
+// compactJSONL rewrites a JSONL file keeping the newest version of each
+// replaceable event. With keepOlder, older versions that some relay still
+// reported (seen_on) are kept as well, so conflicting relay lists stay
+// visible to analyze. Each event is kept once, in its original order, with
+// seen_on merged from all its lines and from sources (which may be nil).
+// Lines that are not events are kept. It returns the number of lines
+// dropped and of older versions kept.
+func compactJSONL(path string, sources *sourceLog, keepOlder bool) (dropped, older int, err error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	newest := loadSeenEvents(path)
+	seenOn := make(map[string][]string)
+	for _, line := range lines {
+		if ev, err := parseEventLine([]byte(line)); err == nil && ev.ID != "" {
+			id := strings.ToLower(ev.ID)
+			seenOn[id] = append(seenOn[id], ev.SeenOn...)
+		}
+	}
+	var b strings.Builder
+	changed := false
+	written := set{}
+	for _, line := range lines {
+		if ev, err := parseEventLine([]byte(line)); err == nil && ev.ID != "" {
+			id := strings.ToLower(ev.ID)
+			relays := seenOn[id]
+			if sources != nil {
+				relays = append(relays, sources.relays(id)...)
+			}
+			relays = uniqueSorted(relays)
+			isNewest := newest[eventDedupKey(ev)].id == id
+			if written.has(id) || (!isNewest && (!keepOlder || len(relays) == 0)) {
+				dropped++
+				continue
+			}
+			written.add(id)
+			if !isNewest {
+				older++
+			}
+			if strings.Join(relays, ",") != strings.Join(uniqueSorted(ev.SeenOn), ",") {
+				if annotated, err := withSeenOn(line, relays); err == nil {
+					line = annotated
+					changed = true
+				}
+			}
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	if dropped == 0 && !changed {
+		return 0, older, nil
+	}
+	return dropped, older, writeFileAtomic(path, []byte(b.String()))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLatestEventsByPubkey(t *testing.T) {
+	events := []Event{
+		{ID: "aa01", PubKey: "alice", CreatedAt: 100, Kind: 10002},
+		{ID: "aa02", PubKey: "alice", CreatedAt: 200, Kind: 10002},
+		{ID: "bb01", PubKey: "bob", CreatedAt: 150, Kind: 10002},
+	}
+	got := latestEventsByPubkey(events)
+	if len(got) != 2 {
+		t.Fatalf("got %d authors, want 2: %v", len(got), got)
+	}
+	if got["alice"].ID != "aa02" {
+		t.Errorf("alice: got %s, want the newer aa02", got["alice"].ID)
+	}
+	if got["bob"].ID != "bb01" {
+		t.Errorf("bob: got %s, want bb01", got["bob"].ID)
+	}
+}
+
+func TestLatestEventsByPubkeyTies(t *testing.T) {
+	// Same created_at: the lowest ID wins, whatever the input order and case
+	for _, events := range [][]Event{
+		{{ID: "BB", PubKey: "Alice", CreatedAt: 5}, {ID: "aa", PubKey: "alice", CreatedAt: 5}},
+		{{ID: "aa", PubKey: "alice", CreatedAt: 5}, {ID: "BB", PubKey: "Alice", CreatedAt: 5}},
+	} {
+		got := latestEventsByPubkey(events)
+		if len(got) != 1 || got["alice"].ID != "aa" {
+			t.Errorf("got %v, want only alice with aa", got)
+		}
+	}
+}
+
+func TestDedupKeyDTags(t *testing.T) {
+	long := strings.Repeat("d", 5000)
+	keys := map[string]string{}
+	for _, d := range []string{"", "friends", "Friends", "🎉", "🎸", "日本", long + "1", long + "2"} {
+		key := dedupKey("id-"+d, "PK", 30000, d)
+		if prev, ok := keys[key]; ok {
+			t.Errorf("d-tags %q and %q share key %q", prev, d, key)
+		}
+		keys[key] = d
+	}
+	if got := dedupKey("x", "PK", 30000, "🎉"); got != "pk:30000:🎉" {
+		t.Errorf("unicode d-tag key %q", got)
+	}
+
+	// eventDedupKey uses the first d tag; other kinds ignore it
+	ev := Event{ID: "ID1", PubKey: "pk", Kind: 30000, Tags: [][]string{{"title", "x"}, {"d", "🎉 " + long}, {"d", "other"}}}
+	if got, want := eventDedupKey(ev), "pk:30000:🎉 "+long; got != want {
+		t.Errorf("eventDedupKey picked %.40q", got)
+	}
+	ev.Kind = 10002
+	if got := eventDedupKey(ev); got != "pk:10002" {
+		t.Errorf("replaceable kind key %q", got)
+	}
+	ev.Kind = 1
+	if got := eventDedupKey(ev); got != "id1" {
+		t.Errorf("regular kind key %q", got)
+	}
+}
+
+func TestDedupKeyCategories(t *testing.T) {
+	tests := []struct {
+		name string
+		kind int
+		want string
+	}{
+		{"metadata", 0, "pk:0"},
+		{"note", 1, "id1"},
+		{"contacts", 3, "pk:3"},
+		{"reaction", 7, "id1"},
+		{"mute list", 10000, "pk:10000"},
+		{"relay list", 10002, "pk:10002"},
+		{"last replaceable", 19999, "pk:19999"},
+		{"ephemeral", 20000, "id1"},
+		{"follow set", 30000, "pk:30000:friends"},
+		{"last parameterized", 39999, "pk:39999:friends"},
+		{"past parameterized", 40000, "id1"},
+	}
+	for _, tt := range tests {
+		if got := dedupKey("ID1", "PK", tt.kind, "friends"); got != tt.want {
+			t.Errorf("%s (kind %d): key %q, want %q", tt.name, tt.kind, got, tt.want)
+		}
+	}
+}
+
+func TestSeenSetCategories(t *testing.T) {
+	s := newSeenSet(nil)
+	add := func(id string, kind int, d string, createdAt int64) bool {
+		return s.add(dedupKey(id, "pk", kind, d), seenVersion{createdAt: createdAt, id: id})
+	}
+
+	// Regular events: every ID once, whatever its age
+	if !add("a", 1, "", 10) || !add("b", 1, "", 5) || add("a", 1, "", 10) {
+		t.Error("regular events are not deduplicated by ID")
+	}
+	// Replaceable events: only a newer version of the author's kind is written
+	if !add("c", 10002, "", 10) || add("d", 10002, "", 5) || !add("e", 10002, "", 20) || add("e", 10002, "", 20) {
+		t.Error("replaceable events are not deduplicated by pubkey and kind")
+	}
+	// On equal created_at the lowest ID wins
+	if !add("0", 10002, "", 20) || add("f", 10002, "", 20) {
+		t.Error("replaceable tie not broken by lowest ID")
+	}
+	// Parameterized replaceable events: per d tag
+	if !add("g", 30000, "friends", 10) || !add("h", 30000, "family", 5) || add("i", 30000, "friends", 5) || !add("j", 30000, "friends", 11) {
+		t.Error("parameterized events are not deduplicated by pubkey, kind and d tag")
+	}
+	if got := s.len(); got != 5 {
+		t.Errorf("%d keys, want 5 (two notes, one relay list, two follow sets)", got)
+	}
+
+	// A preloaded set keeps the newest version per key
+	s = newSeenSet(map[string]seenVersion{"pk:10002": {createdAt: 10, id: "c"}})
+	if s.add("pk:10002", seenVersion{createdAt: 9, id: "x"}) || !s.add("pk:10002", seenVersion{createdAt: 11, id: "y"}) {
+		t.Error("preloaded version not honoured")
+	}
+}
+
+func TestCompactJSONLCategories(t *testing.T) {
+	event := func(id string, kind int, createdAt int64, d string) string {
+		tags := "[]"
+		if d != "" {
+			tags = `[["d","` + d + `"]]`
+		}
+		return `{"kind":` + strconv.Itoa(kind) + `,"id":"` + id + `","pubkey":"pk","created_at":` + strconv.FormatInt(createdAt, 10) + `,"tags":` + tags + `}`
+	}
+	lines := []string{
+		event("n1", 1, 1, ""),
+		event("n2", 1, 2, ""),
+		event("n1", 1, 1, ""),
+		event("r1", 10002, 1, ""),
+		event("r2", 10002, 2, ""),
+		event("s1", 30000, 1, "friends"),
+		event("s2", 30000, 1, "family"),
+		event("s3", 30000, 2, "friends"),
+		"not an event",
+	}
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	if err := writeLines(path, lines); err != nil {
+		t.Fatal(err)
+	}
+	dropped, older, err := compactJSONL(path, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped != 3 || older != 0 {
+		t.Errorf("dropped %d, kept %d older, want 3 and 0", dropped, older)
+	}
+	got, err := readLines(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{lines[0], lines[1], lines[4], lines[6], lines[7], lines[8]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("compacted to\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Collected lines carry seen_on. A superseded version is dropped anyway,
+	// unless keepOlder keeps it for the relay list conflicts report.
+	collected := []string{
+		`{"kind":10002,"id":"a1","pubkey":"pk2","created_at":1,"tags":[],"seen_on":["wss://old.example.com"]}`,
+		`{"kind":10002,"id":"a2","pubkey":"pk2","created_at":2,"tags":[],"seen_on":["wss://new.example.com"]}`,
+		`{"kind":10002,"id":"a2","pubkey":"pk2","created_at":2,"tags":[],"seen_on":["wss://other.example.com"]}`,
+	}
+	for _, tt := range []struct {
+		keepOlder bool
+		dropped   int
+		older     int
+		kept      map[string][]string
+	}{
+		{false, 2, 0, map[string][]string{"a2": {"wss://new.example.com", "wss://other.example.com"}}},
+		{true, 1, 1, map[string][]string{"a1": {"wss://old.example.com"}, "a2": {"wss://new.example.com", "wss://other.example.com"}}},
+	} {
+		if err := writeLines(path, collected); err != nil {
+			t.Fatal(err)
+		}
+		dropped, older, err := compactJSONL(path, nil, tt.keepOlder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dropped != tt.dropped || older != tt.older {
+			t.Errorf("keepOlder %v: dropped %d, kept %d older, want %d and %d", tt.keepOlder, dropped, older, tt.dropped, tt.older)
+		}
+		got, err := readLines(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept := map[string][]string{}
+		for _, line := range got {
+			ev, err := parseEventLine([]byte(line))
+			if err != nil {
+				t.Fatal(err)
+			}
+			kept[ev.ID] = ev.SeenOn
+		}
+		if !reflect.DeepEqual(kept, tt.kept) {
+			t.Errorf("keepOlder %v: kept %v, want %v", tt.keepOlder, kept, tt.kept)
+		}
+	}
+}
+
+// BenchmarkSeenSetParallel simulates many relays delivering overlapping
+// relay lists at once, all deduplicated through one seenSet
+func BenchmarkSeenSetParallel(b *testing.B) {
+	const events = 1 << 14
+	keys := make([]string, events)
+	versions := make([]seenVersion, events)
+	for i := range keys {
+		id := fmt.Sprintf("%064x", i)
+		// Several versions per author, so newer/older comparisons happen too
+		keys[i] = dedupKey(id, fmt.Sprintf("%064x", i%4096), 10002, "")
+		versions[i] = seenVersion{createdAt: int64(i), id: id}
+	}
+	s := newSeenSet(nil)
+	var relays atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// Each relay sends the same events, starting somewhere else
+		i := int(relays.Add(1)) * 7919
+		for pb.Next() {
+			s.add(keys[i%events], versions[i%events])
+			i++
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// explainCmd reports why a pubkey is or isn't covered by the generated config.
+// It only reads files produced by collect, analyze and gen-router.
+func explainCmd(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	dataDir := commonFlags(fs)
+	pubkeySrc := pubkeyFlags(fs, "pubkey of the author to explain")
+	inputJSONL := fs.String("input", "", "path to all_relay_lists.jsonl (default: data-dir/all_relay_lists.jsonl)")
+	configPath := fs.String("config", "./strfry-router.config", "router config written by gen-router")
+	emptyMarkerMode := fs.String("empty-marker-mode", "write", "classification of unmarked r-tags used by analyze: write, read or both")
+	markerMapFlag := fs.String("marker-map", "", "custom r-tag marker mapping used by analyze (e.g. 'outbox=write,inbox=read')")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse flags: %v\n", err)
+		os.Exit(1)
+	}
+
+	pk, err := pubkeySrc.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if pk == "" {
+		fmt.Fprintf(os.Stderr, "--pubkey, --pubkey-file or $%s is required\n", defaultPubkeyEnv)
+		os.Exit(1)
+	}
+	markerMap, err := parseMarkerMap(*markerMapFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--marker-map: %v\n", err)
+		os.Exit(1)
+	}
+	dd := *dataDir
+	if *inputJSONL == "" {
+		*inputJSONL = filepath.Join(dd, "all_relay_lists.jsonl")
+	}
+
+	fmt.Printf("Explaining %s\n", pk)
+	covered := false
+
+	// Follows
+	followsFile := filepath.Join(dd, "follows_list.txt")
+	if follows := readLinesIfExists(followsFile); len(follows) == 0 {
+		fmt.Printf(" - In follows: unknown (%s missing)\n", followsFile)
+	} else if _, ok := loadSetMust(followsFile)[pk]; ok {
+		fmt.Println(" - In follows: yes")
+	} else {
+		fmt.Println(" - In follows: no (gen-router only routes follows)")
+	}
+
+	// Relay list and how analyze treats each entry
+	exWrite, _ := loadExcludes(filepath.Join(dd, "outbox_exclude.txt"))
+	ev, found := newestRelayList(ctx, *inputJSONL, pk)
+	exitIfInterrupted(ctx)
+	if !found {
+		fmt.Printf(" - Relay list (10002): none found in %s\n", *inputJSONL)
+		for _, l := range readLinesIfExists(filepath.Join(dd, "follow_relay_hints.txt")) {
+			if fields := strings.Fields(l); len(fields) >= 2 && strings.ToLower(fields[0]) == pk {
+				fmt.Printf("    relay hint from your kind 3: %s\n", normalizeURL(fields[1]))
+			}
+		}
+	} else {
+		fmt.Printf(" - Relay list (10002, created %s):\n", time.Unix(ev.CreatedAt, 0).UTC().Format(time.RFC3339))
+		for _, tag := range ev.Tags {
+			if len(tag) < 2 || tag[0] != "r" {
+				continue
+			}
+			url := normalizeURL(tag[1])
+			mode := ""
+			if len(tag) >= 3 {
+				mode = strings.ToLower(tag[2])
+			}
+			label := url
+			if mode != "" {
+				label += " (" + mode + ")"
+			}
+			isWrite, _, _ := classifyMarker(mode, *emptyMarkerMode, markerMap)
+			switch {
+			case url == "":
+				fmt.Printf("    ✗ %q — empty URL\n", tag[1])
+			case !isValidRelayURL(url):
+				fmt.Printf("    ✗ %q — not a ws:// or wss:// relay URL, skipped\n", tag[1])
+			case exWrite.has(urlToHost(url)):
+				fmt.Printf("    ✗ %s — excluded by outbox_exclude.txt (%s)\n", label, urlToHost(url))
+			case strings.Contains(url, "/inbox"):
+				fmt.Printf("    ✗ %s — inbox endpoint, skipped for outbox\n", label)
+			case !isWrite:
+				fmt.Printf("    ✗ %s — read relay, not used for outbox\n", label)
+			default:
+				fmt.Printf("    ✓ %s — write relay\n", label)
+			}
+		}
+	}
+
+	// Write map as analyze wrote it (after NIP-11 and other analyze-time excludes)
+	writeMapFile := filepath.Join(dd, "pubkey_relays_map_write.txt")
+	writeRelays := relaysForPubkey(writeMapFile, pk)
+	if len(writeRelays) == 0 {
+		fmt.Printf(" - Write map: no relays in %s\n", writeMapFile)
+	} else {
+		fmt.Printf(" - Write map: %d relays\n", len(writeRelays))
+		for _, r := range writeRelays {
+			fmt.Printf("    - %s\n", r)
+		}
+	}
+
+	// Greedy assignment from gen-router
+	assigned := relaysForPubkey(filepath.Join(dd, "author_assignments.txt"), pk)
+	if len(assigned) == 0 {
+		fmt.Println(" - Assigned relays: none (author_assignments.txt)")
+	} else {
+		fmt.Printf(" - Assigned relays: %s\n", strings.Join(assigned, ", "))
+	}
+
+	// Streams in the generated config
+	streams, err := streamsForPubkey(*configPath, pk)
+	if err != nil {
+		fmt.Printf(" - Streams: cannot read %s: %v\n", *configPath, err)
+	} else if len(streams) == 0 {
+		fmt.Printf(" - Streams: none in %s\n", *configPath)
+	} else {
+		fmt.Printf(" - Streams: %d\n", len(streams))
+		for _, s := range streams {
+			fmt.Printf("    ✓ %s -> %s\n", s.Name, strings.Join(s.URLs, ", "))
+		}
+		covered = true
+	}
+
+	if covered {
+		fmt.Println("Verdict: covered")
+	} else {
+		fmt.Println("Verdict: NOT covered")
+	}
+}
+
+// newestRelayList scans a JSONL file for the newest kind 10002 by pubkey,
+// picked like analyze picks it (see addRelayListVersion)
+func newestRelayList(ctx context.Context, path, pubkey string) (Event, bool) {
+	in, err := openInput(ctx, path)
+	if err != nil {
+		return Event{}, false
+	}
+	defer in.Close()
+	var events []Event
+	s := bufio.NewScanner(in)
+	s.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for s.Scan() {
+		line := s.Bytes()
+		if !strings.Contains(string(line), pubkey) {
+			continue
+		}
+		ev, err := parseEventLine(line)
+		if err != nil {
+			continue
+		}
+		if ev.Kind != 10002 || strings.ToLower(ev.PubKey) != pubkey {
+			continue
+		}
+		events = append(events, ev)
+	}
+	newest, found := latestEventsByPubkey(events)[pubkey]
+	return newest, found
+}
+
+// relaysForPubkey returns the sorted relays paired with pubkey in a "pubkey url" file
+func relaysForPubkey(path, pubkey string) []string {
+	var out []string
+	for _, l := range readLinesIfExists(path) {
+		fields := strings.Fields(l)
+		if len(fields) >= 2 && strings.ToLower(fields[0]) == pubkey {
+			out = append(out, normalizeURL(fields[1]))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// streamsForPubkey returns the streams of a router config whose filter
+// mentions pubkey (in authors or #p), with their URLs
+func streamsForPubkey(path, pubkey string) ([]streamConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []streamConfig
+	var cur *streamConfig
+	inURLs := false
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "  ") && !strings.HasPrefix(line, "   ") && strings.HasSuffix(trimmed, "{"):
+			cur = &streamConfig{Name: strings.TrimSpace(strings.TrimSuffix(trimmed, "{"))}
+		case cur == nil:
+		case strings.HasPrefix(trimmed, "filter") && strings.Contains(trimmed, pubkey):
+			out = append(out, *cur)
+		case trimmed == "urls = [":
+			inURLs = true
+		case inURLs && trimmed == "]":
+			inURLs = false
+			if n := len(out); n > 0 && out[n-1].Name == cur.Name {
+				out[n-1].URLs = cur.URLs
+			}
+			cur = nil
+		case inURLs:
+			cur.URLs = append(cur.URLs, strings.Trim(trimmed, `"`))
+		}
+	}
+	return out, s.Err()
+}
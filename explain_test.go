@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewestRelayListTieBreak(t *testing.T) {
+	pk := strings.Repeat("ab", 32)
+	lines := []string{
+		`{"kind":10002,"id":"` + strings.Repeat("c", 64) + `","pubkey":"` + pk + `","created_at":100,"tags":[["r","wss://c.example"]]}`,
+		`{"kind":10002,"id":"` + strings.Repeat("b", 64) + `","pubkey":"` + pk + `","created_at":100,"tags":[["r","wss://b.example"]]}`,
+		`{"kind":10002,"id":"` + strings.Repeat("d", 64) + `","pubkey":"` + pk + `","created_at":50,"tags":[["r","wss://d.example"]]}`,
+		`{"kind":3,"id":"` + strings.Repeat("a", 64) + `","pubkey":"` + pk + `","created_at":200,"tags":[]}`,
+	}
+	path := filepath.Join(t.TempDir(), "all_relay_lists.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ev, found := newestRelayList(context.Background(), path, pk)
+	if !found {
+		t.Fatal("no relay list found")
+	}
+	// Both lists at created_at 100 tie; like analyze, the lowest ID wins
+	if ev.ID != strings.Repeat("b", 64) {
+		t.Errorf("got list %s, want the lowest ID among the newest", ev.ID[:8])
+	}
+	if _, found := newestRelayList(context.Background(), path, strings.Repeat("ef", 32)); found {
+		t.Error("found a relay list for an unknown pubkey")
+	}
+}
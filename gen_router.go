@@ -2,15 +2,27 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip11"
 )
 
+// defaultMaxAuthorsPerStream is the largest authors array we emit by default.
+// Many relays reject REQs whose filters carry more than ~1000 authors.
+const defaultMaxAuthorsPerStream = 1000
+
 type streamConfig struct {
 	Name    string
 	Dir     string // "down" or "up"
@@ -18,26 +30,90 @@ type streamConfig struct {
 	URLs    []string
 	Kinds   string // raw JSON array or empty
 	PTag    string // for #p filter (notifications)
+	Filter  string // raw JSON object of extra filter fields for down streams, or empty
+	Comment string // written as a # comment above the stream (optional)
+}
+
+// selectOptions tunes relay selection (greedySelectAndAssignN and the other Selectors)
+type selectOptions struct {
+	replicas int // number of distinct relays per author (>=1)
+	// tieBreak scores relays offering the same gain; the higher score wins (may be nil)
+	tieBreak func(relay string) int
+	// preassigned maps relay -> authors placed before the greedy runs (sticky mode);
+	// pairs whose relay no longer covers the author are ignored
+	preassigned map[string][]string
+	// primary maps author -> primary relay; on ties, relays that are primary for
+	// more still-needing authors win (may be nil)
+	primary map[string]string
+	// weight scales each relay's gain in the greedy, e.g. by observed
+	// reliability; nil weighs every relay 1
+	weight func(relay string) float64
+	// replicaFraction, when > 0, lowers an author's replicas to
+	// ceil(fraction * the relays they list), at least 1
+	replicaFraction float64
+	// maxRelays, when > 0, caps how many relays the greedy selects
+	maxRelays int
+}
+
+// replicasFor returns how many relays an author listing relayCount relays
+// should be assigned to
+func (o selectOptions) replicasFor(relayCount int) int {
+	if o.replicaFraction <= 0 {
+		return o.replicas
+	}
+	n := int(math.Ceil(float64(relayCount) * o.replicaFraction))
+	return max(1, min(o.replicas, n))
 }
 
 // greedySelectAndAssignN selects relays greedily so that each author is assigned
 // to up to 'replicas' distinct relays. It returns the selected relays and a mapping
 // of relay -> assigned authors.
-func greedySelectAndAssignN(relayAuthors map[string][]string, replicas int) ([]string, map[string][]string) {
-	// remaining need per author
+func greedySelectAndAssignN(relayAuthors map[string][]string, opts selectOptions) ([]string, map[string][]string) {
+	tieBreak := opts.tieBreak
+	// remaining need per author, from the number of relays they list
 	need := make(map[string]int)
-	// track which authors each relay covers for quick iteration
-	for _, authors := range relayAuthors {
-		for _, a := range authors {
-			if need[a] == 0 {
-				need[a] = replicas
-			}
-		}
+	for a, relays := range relaysByAuthor(relayAuthors) {
+		need[a] = opts.replicasFor(len(relays))
 	}
 	selected := []string{}
 	assigned := make(map[string][]string)
 	// Also prevent duplicate assignment of same author to same relay
 	assignedSet := make(map[string]map[string]struct{}) // relay -> set(author)
+	isSelected := make(map[string]bool)
+
+	// Apply preassignments first, in relay order for determinism
+	var preRelays []string
+	for relay := range opts.preassigned {
+		preRelays = append(preRelays, relay)
+	}
+	sort.Strings(preRelays)
+	for _, relay := range preRelays {
+		if opts.maxRelays > 0 && len(selected) >= opts.maxRelays {
+			break
+		}
+		covers := make(map[string]struct{})
+		for _, a := range relayAuthors[relay] {
+			covers[a] = struct{}{}
+		}
+		for _, a := range opts.preassigned[relay] {
+			if _, ok := covers[a]; !ok || need[a] <= 0 {
+				continue
+			}
+			if assignedSet[relay] == nil {
+				assignedSet[relay] = make(map[string]struct{})
+			}
+			if _, has := assignedSet[relay][a]; has {
+				continue
+			}
+			assignedSet[relay][a] = struct{}{}
+			assigned[relay] = append(assigned[relay], a)
+			need[a]--
+		}
+		if len(assigned[relay]) > 0 && !isSelected[relay] {
+			isSelected[relay] = true
+			selected = append(selected, relay)
+		}
+	}
 
 	// helper to count gain
 	gainOf := func(relay string) int {
@@ -66,16 +142,37 @@ func greedySelectAndAssignN(relayAuthors map[string][]string, replicas int) ([]s
 				break
 			}
 		}
-		if done {
+		if done || (opts.maxRelays > 0 && len(selected) >= opts.maxRelays) {
 			break
 		}
 
 		bestRelay := ""
-		bestGain := 0
+		bestGain := 0.0
+		bestTie := 0
 		for relay := range relayAuthors {
-			g := gainOf(relay)
-			if g > bestGain {
+			n := gainOf(relay)
+			if n == 0 {
+				continue
+			}
+			g := float64(n)
+			if opts.weight != nil {
+				g *= opts.weight(relay)
+			}
+			tie := 0
+			if tieBreak != nil {
+				tie = tieBreak(relay)
+			}
+			if opts.primary != nil {
+				for _, a := range relayAuthors[relay] {
+					if need[a] > 0 && opts.primary[a] == relay {
+						tie++
+					}
+				}
+			}
+			// Last resort: the URL, so ties don't follow map iteration order
+			if g > bestGain || (g == bestGain && tie > bestTie) || (g == bestGain && tie == bestTie && relay < bestRelay) {
 				bestGain = g
+				bestTie = tie
 				bestRelay = relay
 			}
 		}
@@ -98,7 +195,10 @@ func greedySelectAndAssignN(relayAuthors map[string][]string, replicas int) ([]s
 			assigned[bestRelay] = append(assigned[bestRelay], a)
 			need[a]--
 		}
-		selected = append(selected, bestRelay)
+		if !isSelected[bestRelay] {
+			isSelected[bestRelay] = true
+			selected = append(selected, bestRelay)
+		}
 	}
 
 	// normalize and sort authors per relay
@@ -111,25 +211,98 @@ func greedySelectAndAssignN(relayAuthors map[string][]string, replicas int) ([]s
 	return selected, assigned
 }
 
-func genRouterCmd(args []string) {
+func genRouterCmd(ctx context.Context, args []string) {
 	fs := flag.NewFlagSet("gen-router", flag.ExitOnError)
 	dataDir := commonFlags(fs)
 	output := fs.String("output", "./strfry-router.config", "output router config path")
 	authorsPerStream := fs.Int("authors-per-stream", 50, "max authors per stream section")
+	maxAuthorsPerStream := fs.Int("max-authors-per-stream", defaultMaxAuthorsPerStream, "upper bound for --authors-per-stream; larger filters are often rejected by relays")
 	streamPrefix := fs.String("stream-prefix", "follows", "prefix for down streams")
 	includeUnassigned := fs.Bool("include-unassigned", false, "add one stream querying all selected relays for any unassigned authors (rare)")
 	replicas := fs.Int("replicas", 1, "number of distinct relays to assign each author to (>=1)")
-	kindsJSON := fs.String("kinds-json", "", "JSON array for down streams kinds filter (e.g. [0,1,3])")
+	kindsJSON := fs.String("kinds-json", "", "JSON array for down streams kinds filter (e.g. [0,1,3]); overrides --content-preset")
+	extraFilterJSON := fs.String("extra-filter-json", "", "JSON object of extra filter fields merged into every down stream filter (e.g. '{\"#t\":[\"nostr\"],\"since\":1700000000}')")
+	learnedScores := fs.Bool("learned-scores", false, "weigh each relay's gain in the greedy by the reliability collect observed (stats in relay_cache.json)")
+	noHeader := fs.Bool("no-header", false, "don't start the config with comment lines recording the feedbuilder version, time and parameters")
+	maxRelays := fs.Int("max-relays", 0, "select at most this many relays with the greedy, leaving the least-covered follows out (0 = no limit)")
+	replicaFraction := fs.Float64("replica-fraction", 0, "assign each author to min(--replicas, ceil(fraction * relays they list)) relays, e.g. 0.5 (0 = always --replicas)")
+	deltaOutput := fs.String("delta-output", "", "write only streams for follows added since the last full run (data-dir/follows_snapshot.txt) to this path, leaving the main config alone")
+	verifyRelays := fs.Bool("verify-relays", false, "connect to each selected relay before writing; drop unreachable ones and reselect for their authors")
+	verifyTimeout := fs.Int("verify-timeout", 5, "with --verify-relays, connect timeout in seconds per relay")
+	verifyParallel := fs.Int("verify-parallel", 16, "with --verify-relays, relays checked in parallel")
+	probeAndPrune := fs.Bool("probe-and-prune", false, "same as --verify-relays")
+	qualityReport := fs.Bool("quality-report", false, "print a lower bound on the relays needed for full coverage and how far the selection overshoots it")
+	backupOutput := fs.String("backup-output", "", "also write a standby router config assigning follows to relays not used by the primary config (follows with no other relay are left out)")
+	urlForm := fs.String("url-form", urlFormBare, "how relay URLs are written in the config: bare (wss://host) or dtag (wss://host/, the NIP-66 d tag form)")
+	stableStreams := fs.Bool("stable-streams", false, "bucket each relay's authors by pubkey prefix into stably named streams, so small follow changes touch few streams on reload")
+	contentPreset := fs.String("content-preset", "", "named kinds filter for down streams: "+strings.Join(contentPresetNames(), ", "))
 	onlineOnly := fs.Bool("online-only", false, "use only online relays from NIP-66 monitoring (requires analyze --check-monitors)")
+	emitRunScript := fs.Bool("emit-run-script", false, "also write run-router.sh and a strfry-router.service systemd unit next to the output")
+	strategy := fs.String("strategy", "greedy", "relay selection strategy: "+strings.Join(strategyNames(), ", "))
+	seed := fs.Int64("seed", 1, "random seed for --strategy random (fixed by default so configs are reproducible)")
+	preferPrimary := fs.Bool("prefer-primary", false, "on ties, prefer relays listed first in authors' 10002 (data-dir/pubkey_primary_relay.txt)")
+	sticky := fs.Bool("sticky", false, "keep authors on their relay from the previous run (data-dir/author_assignments.txt) when it still covers them")
+	preferRegion := fs.String("prefer-region", "", "prefer relays in this region (from data-dir/relay_regions.txt) when coverage ties")
 
 	// Notification sync options
 	includeNotifs := fs.Bool("include-notifs", false, "add streams for user notifications (your posts and mentions)")
+	consolidateSingle := fs.Bool("exclude-if-single-author-relay", false, "drop selected relays assigned a single author when another selected relay also covers that author")
+	requireWSS := fs.Bool("require-wss", false, "never emit plaintext ws:// relay URLs")
+	maxInactive := fs.Int("max-inactive", 0, "drop follows with no note in this many days, per follow_activity.txt from collect --activity-days (0 = off)")
+	catchAllRelays := fs.String("catch-all-relays", "", "comma-separated aggregator relays queried for ALL follows as a backstop (extra bandwidth)")
+	emitDot := fs.String("emit-dot", "", "also write the greedy cover as a Graphviz .dot graph to this path")
+	nip11Limits := fs.Bool("nip11-limits", false, "size each selected relay's author chunks to fit its NIP-11 limits (from relay_cache.json, fetched if missing)")
+	defaultMaxSubs := fs.Int("default-max-subscriptions", 0, "with --nip11-limits, max_subscriptions assumed for relays that advertise none (0 = unlimited)")
+	target := fs.String("target", targetStrfry, "config format to write: strfry (router config) or haven (relays_import.json and relays_blastr.json next to --output)")
+	splitConfigs := fs.Bool("split-configs", false, "write down and up streams to router-down.config and router-up.config next to --output instead of one config")
+	onlyRelays := fs.String("only-relays", "", "route only through these relays: comma-separated URLs or a file with one URL per line")
+	notifsFollowsOnly := fs.Bool("notifs-follows-only", false, "restrict notification streams to mentions authored by your follows (combined authors AND #p filter)")
 
 	if err := fs.Parse(args); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to parse flags: %v\n", err)
 		os.Exit(1)
 	}
 
+	*authorsPerStream = clampAuthorsPerStream(*authorsPerStream, *maxAuthorsPerStream)
+	if *contentPreset != "" {
+		kinds, ok := contentPresets[strings.ToLower(*contentPreset)]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "--content-preset: unknown preset %q (want %s)\n", *contentPreset, strings.Join(contentPresetNames(), ", "))
+			os.Exit(1)
+		}
+		if *kindsJSON == "" {
+			*kindsJSON = kinds
+		} else {
+			fmt.Printf("Using --kinds-json %s instead of --content-preset %s\n", *kindsJSON, *contentPreset)
+		}
+	}
+	*urlForm = strings.ToLower(*urlForm)
+	if *urlForm != urlFormBare && *urlForm != urlFormDTag {
+		fmt.Fprintf(os.Stderr, "invalid --url-form %q (want %s or %s)\n", *urlForm, urlFormBare, urlFormDTag)
+		os.Exit(1)
+	}
+	*target = strings.ToLower(*target)
+	if *target != targetStrfry && *target != targetHaven {
+		fmt.Fprintf(os.Stderr, "invalid --target %q (want %s or %s)\n", *target, targetStrfry, targetHaven)
+		os.Exit(1)
+	}
+	if *target == targetHaven && *splitConfigs {
+		fmt.Fprintln(os.Stderr, "--split-configs only applies to --target strfry")
+		os.Exit(1)
+	}
+	if *target == targetHaven && (*deltaOutput != "" || *backupOutput != "") {
+		fmt.Fprintln(os.Stderr, "--delta-output and --backup-output write strfry router configs; they only apply to --target strfry")
+		os.Exit(1)
+	}
+	if err := validateKindsJSON(*kindsJSON); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid kinds filter: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateExtraFilterJSON(*extraFilterJSON); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --extra-filter-json: %v\n", err)
+		os.Exit(1)
+	}
+
 	dd := *dataDir
 	// Inputs
 	mapFile := filepath.Join(dd, "pubkey_relays_map.txt")
@@ -142,6 +315,9 @@ func genRouterCmd(args []string) {
 	userPubkeyFile := filepath.Join(dd, "user_pubkey.txt")
 
 	followsSet := loadSetMust(followsFile)
+	if *maxInactive > 0 {
+		pruneInactiveFollows(followsSet, filepath.Join(dd, "follow_activity.txt"), *maxInactive)
+	}
 	// Build relay->authors from pubkey_relays_map
 	relayAuthors := make(map[string][]string)
 	{
@@ -160,6 +336,9 @@ func genRouterCmd(args []string) {
 			if !isValidRelayURL(rurl) {
 				continue
 			}
+			if *requireWSS && isPlaintextRelay(rurl) {
+				continue
+			}
 			relayAuthors[rurl] = append(relayAuthors[rurl], pk)
 		}
 	}
@@ -167,37 +346,366 @@ func genRouterCmd(args []string) {
 	for r := range relayAuthors {
 		relayAuthors[r] = uniqueSorted(relayAuthors[r])
 	}
+	if *onlyRelays != "" {
+		allowed, err := parseRelayListOrFile(*onlyRelays, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--only-relays: %v\n", err)
+			os.Exit(1)
+		}
+		if len(allowed) == 0 {
+			fmt.Fprintln(os.Stderr, "--only-relays: no valid relay URLs")
+			os.Exit(1)
+		}
+		uncovered := restrictRelayAuthors(relayAuthors, allowed)
+		fmt.Printf("Restricted to %d relays from --only-relays (%d with follows)\n", len(allowed), len(relayAuthors))
+		if len(uncovered) > 0 {
+			fmt.Printf(" - Uncovered follows (no listed relay): %d\n", len(uncovered))
+			for _, pk := range uncovered {
+				fmt.Printf("    ✗ %s\n", pk)
+			}
+		}
+	}
+
+	// In delta mode only follows missing from the last full run are routed
+	snapshotFile := filepath.Join(dd, "follows_snapshot.txt")
+	var delta []string
+	if *deltaOutput != "" {
+		previous, err := readLines(snapshotFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--delta-output needs %s from a previous full gen-router run: %v\n", snapshotFile, err)
+			os.Exit(1)
+		}
+		prev := make(set)
+		for _, pk := range previous {
+			prev.add(strings.ToLower(pk))
+		}
+		deltaSet := make(set)
+		for pk := range followsSet {
+			if !prev.has(pk) {
+				deltaSet.add(pk)
+				delta = append(delta, pk)
+			}
+		}
+		sort.Strings(delta)
+		for r, authors := range relayAuthors {
+			var keep []string
+			for _, a := range authors {
+				if deltaSet.has(a) {
+					keep = append(keep, a)
+				}
+			}
+			if len(keep) == 0 {
+				delete(relayAuthors, r)
+			} else {
+				relayAuthors[r] = keep
+			}
+		}
+		fmt.Printf("Delta since last full run: %d new follows, %d with relays\n", len(delta), assignedAuthors(relayAuthors))
+	}
 
 	// Compute greedy optimal set from relayAuthors and assign authors to up to N replicas
 	if *replicas < 1 {
 		*replicas = 1
 	}
-	selected, assigned := greedySelectAndAssignN(relayAuthors, *replicas)
+	var tieBreak func(string) int
+	var regions map[string]string
+	if *preferRegion != "" {
+		regions = loadRelayRegions(filepath.Join(dd, "relay_regions.txt"))
+		if len(regions) == 0 {
+			fmt.Fprintf(os.Stderr, "warning: --prefer-region set but no regions found in %s\n", filepath.Join(dd, "relay_regions.txt"))
+		}
+		region := strings.ToLower(*preferRegion)
+		tieBreak = func(relay string) int {
+			if regions[relay] == region {
+				return 1
+			}
+			return 0
+		}
+	}
+	assignmentsFile := filepath.Join(dd, "author_assignments.txt")
+	selector, err := selectorByName(*strategy, *seed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--strategy: %v\n", err)
+		os.Exit(1)
+	}
+	if *sticky && *strategy != "greedy" {
+		fmt.Fprintf(os.Stderr, "warning: --sticky only applies to --strategy greedy; ignoring it for %s\n", *strategy)
+		*sticky = false
+	}
+	if *replicaFraction < 0 || *replicaFraction > 1 {
+		fmt.Fprintln(os.Stderr, "--replica-fraction must be between 0 and 1")
+		os.Exit(1)
+	}
+	if *maxRelays > 0 && *strategy != "greedy" {
+		fmt.Fprintf(os.Stderr, "--max-relays only applies to --strategy greedy, not %s\n", *strategy)
+		os.Exit(1)
+	}
+	opts := selectOptions{replicas: *replicas, tieBreak: tieBreak, replicaFraction: *replicaFraction, maxRelays: *maxRelays}
+	if *preferPrimary || *strategy == "primary" {
+		opts.primary = loadPrimaryRelays(filepath.Join(dd, "pubkey_primary_relay.txt"))
+		if len(opts.primary) == 0 {
+			fmt.Fprintln(os.Stderr, "warning: no primary relays found in pubkey_primary_relay.txt; run analyze first")
+		}
+	}
+	if *learnedScores {
+		if *strategy != "greedy" {
+			fmt.Fprintf(os.Stderr, "warning: --learned-scores only applies to --strategy greedy; ignoring it for %s\n", *strategy)
+		} else {
+			cache := loadRelayInfoCache(filepath.Join(dd, "relay_cache.json"))
+			opts.weight = cache.score
+			scored := 0
+			for relay := range relayAuthors {
+				if w := cache.score(relay); w < 1 {
+					scored++
+				}
+			}
+			fmt.Printf("Weighing relays by observed reliability (%d of %d relays deprioritized)\n", scored, len(relayAuthors))
+		}
+	}
+	var prevAssignments map[string][]string
+	if *sticky {
+		prevAssignments = loadAssignments(assignmentsFile)
+		opts.preassigned = prevAssignments
+		if len(prevAssignments) == 0 {
+			fmt.Fprintf(os.Stderr, "warning: --sticky set but no previous assignments found at %s\n", assignmentsFile)
+		}
+	}
+	selected, assigned := selector.Select(relayAuthors, opts)
+	if *verifyRelays || *probeAndPrune {
+		// Drop unreachable relays and reselect until every selected relay answered;
+		// each round removes at least one relay, so this terminates
+		timeout := time.Duration(*verifyTimeout) * time.Second
+		verified := make(set)
+		unreachable := make(map[string]error)
+		unverified := assigned
+		for {
+			var pending []string
+			for _, r := range selected {
+				if !verified.has(r) {
+					pending = append(pending, r)
+				}
+			}
+			if len(pending) == 0 {
+				break
+			}
+			failed := connectRelays(ctx, pending, *verifyParallel, timeout)
+			// Cancelled connects would drop every pending relay as unreachable
+			exitIfInterrupted(ctx)
+			for _, r := range pending {
+				if err, bad := failed[r]; bad {
+					unreachable[r] = err
+					delete(relayAuthors, r)
+				} else {
+					verified.add(r)
+				}
+			}
+			if len(failed) == 0 {
+				break
+			}
+			selected, assigned = selector.Select(relayAuthors, opts)
+		}
+		fmt.Printf("Verified relays: %d reachable, %d unreachable\n", len(verified), len(unreachable))
+		dropped := make([]string, 0, len(unreachable))
+		for r := range unreachable {
+			dropped = append(dropped, r)
+		}
+		sort.Strings(dropped)
+		for _, r := range dropped {
+			fmt.Printf("    ✗ %s (%v)\n", r, unreachable[r])
+		}
+		if len(unreachable) > 0 {
+			reportPrunedAuthors(unverified, assigned, unreachable)
+		}
+	}
+	if *consolidateSingle {
+		selected = consolidateSingleAuthorRelays(relayAuthors, selected, assigned)
+	}
+	if *sticky {
+		reportStickyChanges(prevAssignments, assigned)
+	}
+	if *replicaFraction > 0 {
+		reportReplicaDistribution(assigned)
+	}
+	if *maxRelays > 0 {
+		reportRelayBudget(relayAuthors, assigned, opts, len(selected))
+	}
+	if *qualityReport {
+		bound, forced := relayLowerBound(relayAuthors, opts)
+		fmt.Println("Selection quality:")
+		fmt.Printf(" - Selected relays: %d (%s)\n", len(selected), *strategy)
+		fmt.Printf(" - Lower bound for full coverage: %d (%d relays forced by authors with few relays)\n", bound, forced)
+		// The bound is for full coverage; a selection short of it (e.g. cut by
+		// --max-relays) is not comparable
+		if uncovered, short := coverageShortfall(relayAuthors, assigned, opts); len(uncovered) > 0 || short > 0 {
+			fmt.Printf(" - Overshoot: n/a, the selection is not full coverage (%d follows uncovered, %d below their replica target)\n", len(uncovered), short)
+		} else if bound > 0 {
+			fmt.Printf(" - Overshoot: %d relays (%.1f%% above the bound, the optimum may lie in between)\n",
+				len(selected)-bound, percent(len(selected)-bound, bound))
+		}
+	}
+	// A delta run must not replace the full run's assignments
+	if *deltaOutput == "" {
+		if err := writeAssignments(assignmentsFile, assigned); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to write %s: %v\n", assignmentsFile, err)
+		}
+	}
+	if *emitDot != "" {
+		if err := writeCoverDot(*emitDot, selected, assigned); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to write %s: %v\n", *emitDot, err)
+		} else {
+			fmt.Printf("Wrote %s (%d relays)\n", *emitDot, len(selected))
+		}
+	}
+	if *preferRegion != "" {
+		reportCrossRegion(relayAuthors, assigned, regions, strings.ToLower(*preferRegion))
+	}
 
-	var streams []streamConfig
-	// Create per-relay down streams for selected relays with their assigned authors
-	for _, relay := range selected {
-		relay = normalizeURL(relay)
-		auths := assigned[relay]
-		if len(auths) == 0 {
-			continue
+	// Optionally size chunks per relay from NIP-11 limits
+	var relayInfo *relayInfoCache
+	if *nip11Limits {
+		// Reuse what collect --cache-nip11 (or a previous run) stored; only fetch unknown relays
+		relayInfo = loadRelayInfoCache(filepath.Join(dd, "relay_cache.json"))
+		n := relayInfo.fetch(ctx, selected, 16, 5*time.Second, nip11NoExpiry)
+		exitIfInterrupted(ctx)
+		if n > 0 {
+			fmt.Printf("Fetched NIP-11 for %d relays missing from relay_cache.json\n", n)
+			if err := relayInfo.save(); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to save relay cache: %v\n", err)
+			}
 		}
-		// Validate authors are 64-char hex and normalize to lowercase
-		filtered := make([]string, 0, len(auths))
-		for _, a := range auths {
-			a = strings.ToLower(strings.TrimSpace(a))
-			if isHex64(a) {
-				filtered = append(filtered, a)
+		fmt.Println("Chunking influenced by relay limits:")
+	}
+	limited := 0
+
+	// followStreams creates per-relay down streams for relays with their assigned authors
+	followStreams := func(prefix string, relays []string, assigned map[string][]string) []streamConfig {
+		var streams []streamConfig
+		for _, relay := range relays {
+			relay = normalizeURL(relay)
+			auths := assigned[relay]
+			if len(auths) == 0 {
+				continue
+			}
+			// Validate authors are 64-char hex and normalize to lowercase
+			filtered := make([]string, 0, len(auths))
+			for _, a := range auths {
+				a = strings.ToLower(strings.TrimSpace(a))
+				if isHex64(a) {
+					filtered = append(filtered, a)
+				}
+			}
+			if len(filtered) == 0 {
+				continue
+			}
+			size := *authorsPerStream
+			var lim *nip11.RelayLimitationDocument
+			if relayInfo != nil {
+				if e := relayInfo.get(relay); e != nil && e.Info != nil && e.Info.Limitation != nil {
+					l := *e.Info.Limitation
+					lim = &l
+				}
+				if *defaultMaxSubs > 0 && (lim == nil || lim.MaxSubscriptions == 0) {
+					if lim == nil {
+						lim = &nip11.RelayLimitationDocument{}
+					}
+					lim.MaxSubscriptions = *defaultMaxSubs
+				}
+				var warning string
+				size, warning = relayChunkSize(len(filtered), *authorsPerStream, *maxAuthorsPerStream, lim)
+				if size != *authorsPerStream || warning != "" {
+					limited++
+					fmt.Printf("  - %s: %d authors -> %d streams of <=%d%s\n",
+						relay, len(filtered), (len(filtered)+size-1)/size, size, describeLimits(lim))
+				}
+				if warning != "" {
+					fmt.Fprintf(os.Stderr, "warning: %s: %s\n", relay, warning)
+				}
+			}
+			if *stableStreams {
+				maxStreams := 0
+				if lim != nil {
+					maxStreams = lim.MaxSubscriptions
+				}
+				buckets := stableChunks(filtered, size, maxStreams)
+				if n := largestBucket(buckets); n > size {
+					fmt.Fprintf(os.Stderr, "warning: %s: stable streams kept within max_subscriptions=%d hold up to %d authors (> %d)\n",
+						relay, maxStreams, n, size)
+				}
+				for _, b := range buckets {
+					name := fmt.Sprintf("%s_%s_%s", prefix, safeName(relay), b.label)
+					streams = append(streams, streamConfig{Name: name, Dir: "down", Authors: b.authors, URLs: []string{relay}, Kinds: *kindsJSON, Filter: *extraFilterJSON})
+				}
+				continue
+			}
+			chunks := chunk(filtered, size)
+			for i, chunkAuthors := range chunks {
+				name := fmt.Sprintf("%s_%s_%d", prefix, safeName(relay), i+1)
+				streams = append(streams, streamConfig{Name: name, Dir: "down", Authors: chunkAuthors, URLs: []string{relay}, Kinds: *kindsJSON, Filter: *extraFilterJSON})
 			}
 		}
-		if len(filtered) == 0 {
-			continue
+		return streams
+	}
+	// Provenance comments at the top of every config written below
+	var header []string
+	if !*noHeader {
+		header = configHeader(*replicas, *authorsPerStream, *onlineOnly, *strategy, len(followsSet))
+	}
+	if *deltaOutput != "" {
+		deltaStreams := lintStreams(followStreams(*streamPrefix+"_delta", selected, assigned))
+		if *requireWSS {
+			deltaStreams = stripPlaintextRelays(deltaStreams)
+		}
+		if _, err := writeRouterConfig(*deltaOutput, deltaStreams, *urlForm, header); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing delta router config: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %s (%d streams)\n", *deltaOutput, len(deltaStreams))
+		fmt.Printf(" - New follows: %d, routed: %d\n", len(delta), assignedAuthors(assigned))
+		fmt.Printf(" - Relays involved: %d\n", len(selected))
+		for _, r := range selected {
+			fmt.Printf("    ✓ %s (%d authors)\n", r, len(assigned[r]))
+		}
+		return
+	}
+	streams := followStreams(*streamPrefix, selected, assigned)
+
+	if relayInfo != nil {
+		fmt.Printf("Relay limits changed chunking for %d of %d relays\n", limited, len(selected))
+	}
+
+	// Optionally write a standby config on relays disjoint from the primary selection
+	if *backupOutput != "" {
+		backupAuthors := make(map[string][]string)
+		for relay, authors := range relayAuthors {
+			if _, primary := assigned[normalizeURL(relay)]; !primary {
+				backupAuthors[relay] = authors
+			}
+		}
+		// Same options as the primary tier; sticky assignments refer to primary relays
+		backupOpts := opts
+		backupOpts.preassigned = nil
+		backupSelected, backupAssigned := selector.Select(backupAuthors, backupOpts)
+		if relayInfo != nil {
+			if n := relayInfo.fetch(ctx, backupSelected, 16, 5*time.Second, nip11NoExpiry); n > 0 {
+				if err := relayInfo.save(); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to save relay cache: %v\n", err)
+				}
+			}
 		}
-		chunks := chunk(filtered, *authorsPerStream)
-		for i, chunkAuthors := range chunks {
-			name := fmt.Sprintf("%s_%s_%d", *streamPrefix, safeName(relay), i+1)
-			streams = append(streams, streamConfig{Name: name, Dir: "down", Authors: chunkAuthors, URLs: []string{relay}, Kinds: *kindsJSON})
+		backupStreams := lintStreams(followStreams(*streamPrefix+"_backup", backupSelected, backupAssigned))
+		if *requireWSS {
+			backupStreams = stripPlaintextRelays(backupStreams)
+		}
+		if _, err := writeRouterConfig(*backupOutput, backupStreams, *urlForm, header); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing backup router config: %v\n", err)
+			os.Exit(1)
 		}
+		primaryCovered, backupCovered := assignedAuthors(assigned), assignedAuthors(backupAssigned)
+		fmt.Printf("Wrote %s (%d streams on %d backup relays)\n", *backupOutput, len(backupStreams), len(backupSelected))
+		fmt.Printf(" - Primary tier: %d/%d follows (%.1f%%) on %d relays\n",
+			primaryCovered, len(followsSet), percent(primaryCovered, len(followsSet)), len(selected))
+		fmt.Printf(" - Backup tier: %d/%d follows (%.1f%%) on %d relays\n",
+			backupCovered, len(followsSet), percent(backupCovered, len(followsSet)), len(backupSelected))
 	}
 
 	// Optionally include authors still needing replicas across all selected relays
@@ -234,12 +742,36 @@ func genRouterCmd(args []string) {
 					// Query across selected relays for any missed authors
 					urls := make([]string, len(selected))
 					copy(urls, selected)
-					streams = append(streams, streamConfig{Name: name, Dir: "down", Authors: ch, URLs: urls, Kinds: *kindsJSON})
+					streams = append(streams, streamConfig{Name: name, Dir: "down", Authors: ch, URLs: urls, Kinds: *kindsJSON, Filter: *extraFilterJSON})
 				}
 			}
 		}
 	}
 
+	// Optionally add a backstop querying a few large relays for every follow
+	if *catchAllRelays != "" {
+		var urls []string
+		for _, r := range parseRelayList(*catchAllRelays, false) {
+			urls = append(urls, normalizeURL(r))
+		}
+		var all []string
+		for a := range followsSet {
+			if isHex64(a) {
+				all = append(all, a)
+			}
+		}
+		all = uniqueSorted(all)
+		if len(urls) > 0 && len(all) > 0 {
+			chunks := chunk(all, *authorsPerStream)
+			for i, ch := range chunks {
+				name := fmt.Sprintf("%s_catchall_%d", *streamPrefix, i+1)
+				streams = append(streams, streamConfig{Name: name, Dir: "down", Authors: ch, URLs: urls, Kinds: *kindsJSON, Filter: *extraFilterJSON})
+			}
+			fmt.Printf("Added %d catch-all streams for %d follows on %d relays: %s\n", len(chunks), len(all), len(urls), strings.Join(urls, ", "))
+			fmt.Fprintln(os.Stderr, "warning: catch-all streams request every follow from each catch-all relay; expect much more bandwidth and duplicate events")
+		}
+	}
+
 	// Add notification streams if requested
 	if *includeNotifs {
 		// Load user's pubkey from file
@@ -249,9 +781,9 @@ func genRouterCmd(args []string) {
 			fmt.Fprintln(os.Stderr, "hint: run 'collect' command first with --pubkey to save your pubkey")
 			os.Exit(1)
 		}
-		pubkey := strings.ToLower(strings.TrimSpace(userPubkeyLines[0]))
-		if !isHex64(pubkey) {
-			fmt.Fprintf(os.Stderr, "error: invalid pubkey in %s: %s\n", userPubkeyFile, pubkey)
+		pubkey, err := parsePubkey(userPubkeyLines[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid pubkey in %s: %v\n", userPubkeyFile, err)
 			os.Exit(1)
 		}
 
@@ -263,104 +795,1100 @@ func genRouterCmd(args []string) {
 				userRelays = append(userRelays, relay)
 			}
 		}
+		// Per NIP-65 mentions belong on the user's read relays; fall back to all relays without markers
+		if readRelays, ok := userReadRelays(filepath.Join(dd, "user_relay_markers.txt"), userRelays); ok {
+			fmt.Printf("Using %d of %d relays marked for reading for notifications:\n", len(readRelays), len(userRelays))
+			for _, r := range readRelays {
+				fmt.Printf("  - %s\n", r)
+			}
+			userRelays = readRelays
+		}
+		// Drop relays excluded for reading in outbox_exclude.txt
+		if _, readEx := loadExcludes(filepath.Join(dd, "outbox_exclude.txt")); len(readEx) > 0 {
+			var kept []string
+			for _, r := range userRelays {
+				if readEx.has(urlToHost(r)) {
+					fmt.Printf("Skipping %s for notifications (excluded for read)\n", r)
+					continue
+				}
+				kept = append(kept, r)
+			}
+			userRelays = kept
+		}
 		if len(userRelays) == 0 {
 			fmt.Fprintf(os.Stderr, "warning: no user relay list found at %s, skipping notification streams\n", userRelayListFile)
 			fmt.Fprintln(os.Stderr, "hint: run 'collect' command first with --pubkey to fetch your relay list")
 		} else {
 			fmt.Printf("Adding notification streams for pubkey %s using %d relays\n", pubkey, len(userRelays))
 
+			// strfry ANDs the fields of a filter, so authors + #p on one stream means
+			// "mentions of you by your follows", not "follows' posts OR your mentions".
+			// Follows' posts already come from the per-relay streams above.
+			var followAuthors []string
+			if *notifsFollowsOnly {
+				for a := range followsSet {
+					if isHex64(a) {
+						followAuthors = append(followAuthors, a)
+					}
+				}
+				sort.Strings(followAuthors)
+				fmt.Printf("Restricting notification streams to mentions from %d follows\n", len(followAuthors))
+			}
+
 			// Add stream for notifications mentioning user (inbox)
 			for _, relay := range userRelays {
 				relay = normalizeURL(relay)
 				name := fmt.Sprintf("notifs_inbox_%s", safeName(relay))
-				streams = append(streams, streamConfig{
-					Name:    name,
-					Dir:     "down",
-					Authors: nil, // No authors filter for inbox
-					URLs:    []string{relay},
-					Kinds:   *kindsJSON,
-					PTag:    pubkey, // Special field for #p filter
-				})
+				if !*notifsFollowsOnly {
+					streams = append(streams, streamConfig{
+						Name:    name,
+						Dir:     "down",
+						Authors: nil, // No authors filter for inbox
+						URLs:    []string{relay},
+						Kinds:   *kindsJSON,
+						Filter:  *extraFilterJSON,
+						PTag:    pubkey, // Special field for #p filter
+					})
+					continue
+				}
+				for i, ch := range chunk(followAuthors, *authorsPerStream) {
+					streams = append(streams, streamConfig{
+						Name:    fmt.Sprintf("%s_%d", name, i+1),
+						Dir:     "down",
+						Authors: ch,
+						URLs:    []string{relay},
+						Kinds:   *kindsJSON,
+						Filter:  *extraFilterJSON,
+						PTag:    pubkey,
+					})
+				}
 			}
 		}
 	}
 
-	// Write taocpp::config
-	if err := writeRouterConfig(*output, streams); err != nil {
-		fmt.Fprintf(os.Stderr, "error writing router config: %v\n", err)
-		os.Exit(1)
+	// Last line of defence: strip ws:// from every stream (notifs, catch-all, ...)
+	if *requireWSS {
+		streams = stripPlaintextRelays(streams)
 	}
-	fmt.Printf("Wrote %s (%d streams)\n", *output, len(streams))
-}
 
-func readLinesMust(path string) []string {
-	lines, err := readLines(path)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error reading %s: %v\n", path, err)
-		os.Exit(1)
-	}
-	for i := range lines {
-		lines[i] = normalizeURL(lines[i])
-	}
-	return lines
-}
+	streams = dedupStreams(streams)
+	streams = lintStreams(streams)
 
-func readLinesIfExists(path string) []string {
-	lines, err := readLines(path)
-	if err != nil {
-		return nil
+	// Name a known follow per stream when collect --fetch-profiles ran
+	namesFile := filepath.Join(dd, "pubkey_names.txt")
+	if names, err := loadPubkeyNames(namesFile); err == nil && len(names) > 0 {
+		n := annotateStreamNames(streams, names)
+		fmt.Printf("Annotated %d of %d streams with follow names from %s\n", n, len(streams), namesFile)
 	}
-	for i := range lines {
-		lines[i] = normalizeURL(lines[i])
+
+	// haven pulls and pushes whole relays: write its relay lists instead
+	if *target == targetHaven {
+		imports, blastr := havenRelayLists(streams, *urlForm)
+		paths, err := writeHavenConfig(filepath.Dir(*output), imports, blastr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error writing haven config: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %s (%d import relays)\n", paths[0], len(imports))
+		fmt.Printf("Wrote %s (%d blastr relays)\n", paths[1], len(blastr))
+		fmt.Println(" - haven imports whole relays; per-stream authors, kinds and #p filters are not carried over")
+		if *emitRunScript {
+			fmt.Fprintln(os.Stderr, "warning: --emit-run-script runs strfry; skipping it with --target haven")
+		}
+		writeFollowsSnapshot(snapshotFile, followsSet)
+		return
 	}
-	return lines
-}
 
-func loadSetMust(path string) map[string]struct{} {
-	m := make(map[string]struct{})
-	lines, err := readLines(path)
+	// Write taocpp::config, or one per direction for split router instances
+	if *splitConfigs {
+		dir := filepath.Dir(*output)
+		for _, part := range splitStreamsByDir(streams) {
+			path := filepath.Join(dir, "router-"+part.dir+".config")
+			changed, err := writeRouterConfig(path, part.streams, *urlForm, header)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error writing router config: %v\n", err)
+				os.Exit(1)
+			}
+			if changed {
+				fmt.Printf("Wrote %s (%d %s streams)\n", path, len(part.streams), part.dir)
+			} else {
+				fmt.Printf("%s unchanged (%d %s streams)\n", path, len(part.streams), part.dir)
+			}
+			if len(part.streams) == 0 {
+				fmt.Fprintf(os.Stderr, "warning: no %s streams; %s has an empty streams block\n", part.dir, path)
+			}
+		}
+		if *emitRunScript {
+			fmt.Fprintln(os.Stderr, "warning: --emit-run-script runs a single config; skipping it with --split-configs")
+		}
+		writeFollowsSnapshot(snapshotFile, followsSet)
+		return
+	}
+	changed, err := writeRouterConfig(*output, streams, *urlForm, header)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error reading %s: %v\n", path, err)
+		fmt.Fprintf(os.Stderr, "error writing router config: %v\n", err)
 		os.Exit(1)
 	}
-	for _, l := range lines {
-		l = strings.ToLower(strings.TrimSpace(l))
-		if l != "" {
-			m[l] = struct{}{}
+	if changed {
+		fmt.Printf("Wrote %s (%d streams)\n", *output, len(streams))
+	} else {
+		fmt.Printf("%s unchanged (%d streams)\n", *output, len(streams))
+	}
+	writeFollowsSnapshot(snapshotFile, followsSet)
+
+	if *emitRunScript {
+		paths, err := writeRunScripts(*output, streams)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error writing run script: %v\n", err)
+			os.Exit(1)
+		}
+		for _, p := range paths {
+			fmt.Printf("Wrote %s\n", p)
 		}
 	}
-	return m
 }
 
-func uniqueSorted(in []string) []string {
-	m := make(map[string]struct{})
-	for _, v := range in {
-		m[v] = struct{}{}
-	}
-	var out []string
-	for v := range m {
-		out = append(out, v)
-	}
-	sort.Strings(out)
-	return out
+// streamGroup is the set of streams written to one config file
+type streamGroup struct {
+	dir     string
+	streams []streamConfig
 }
 
-func chunk[T any](in []T, n int) [][]T {
-	if n <= 0 || len(in) == 0 {
-		return nil
-	}
-	var out [][]T
-	for i := 0; i < len(in); i += n {
-		j := i + n
-		if j > len(in) {
-			j = len(in)
+// splitStreamsByDir partitions streams into down (follows, notifications,
+// catch-all) and up (publishing) groups, keeping their order
+func splitStreamsByDir(streams []streamConfig) []streamGroup {
+	var down, up []streamConfig
+	for _, s := range streams {
+		if s.Dir == "up" {
+			up = append(up, s)
+		} else {
+			down = append(down, s)
 		}
-		out = append(out, in[i:j])
 	}
-	return out
+	return []streamGroup{{"down", down}, {"up", up}}
 }
 
-func safeName(relay string) string {
+// relayLowerBound returns a lower bound on the number of relays any selection
+// needs to give every author its replica target (opts.replicasFor, at most its
+// relay count), and how many relays are forced because their authors have no
+// other choice. Relays of authors whose target is all their relays must be
+// selected; the demand left after them needs at least as many more relays as
+// it takes to cover it with the largest remaining relays, since a relay serves
+// each author once. It runs in O(pairs + relays log relays).
+func relayLowerBound(relayAuthors map[string][]string, opts selectOptions) (int, int) {
+	relaysOf := make(map[string][]string)
+	for relay, authors := range relayAuthors {
+		for _, a := range authors {
+			relaysOf[a] = append(relaysOf[a], relay)
+		}
+	}
+	needOf := func(relays []string) int {
+		return max(1, min(opts.replicasFor(len(relays)), len(relays)))
+	}
+	forced := make(set)
+	for _, relays := range relaysOf {
+		if needOf(relays) == len(relays) {
+			for _, r := range relays {
+				forced.add(r)
+			}
+		}
+	}
+	demand := make(map[string]int)
+	total := 0
+	for a, relays := range relaysOf {
+		need := needOf(relays)
+		for _, r := range relays {
+			if forced.has(r) {
+				need--
+			}
+		}
+		if need > 0 {
+			demand[a] = need
+			total += need
+		}
+	}
+	var sizes []int
+	for relay, authors := range relayAuthors {
+		if forced.has(relay) {
+			continue
+		}
+		n := 0
+		for _, a := range authors {
+			if demand[a] > 0 {
+				n++
+			}
+		}
+		if n > 0 {
+			sizes = append(sizes, n)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+	extra := 0
+	for _, n := range sizes {
+		if total <= 0 {
+			break
+		}
+		total -= n
+		extra++
+	}
+	return len(forced) + extra, len(forced)
+}
+
+// writeFollowsSnapshot records the follows a full config was generated for,
+// the baseline of the next --delta-output run
+func writeFollowsSnapshot(path string, follows map[string]struct{}) {
+	pks := make([]string, 0, len(follows))
+	for pk := range follows {
+		pks = append(pks, pk)
+	}
+	sort.Strings(pks)
+	if err := writeLines(path, pks); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write %s: %v\n", path, err)
+	}
+}
+
+// reportRelayBudget prints the follows left uncovered or below their
+// replica target because the greedy stopped at --max-relays
+func reportRelayBudget(relayAuthors, assigned map[string][]string, opts selectOptions, selectedCount int) {
+	uncovered, short := coverageShortfall(relayAuthors, assigned, opts)
+	fmt.Printf("Relay budget (--max-relays %d): %d relays selected\n", opts.maxRelays, selectedCount)
+	fmt.Printf(" - Below their replica target: %d follows\n", short)
+	fmt.Printf(" - Uncovered within the budget: %d follows\n", len(uncovered))
+	for _, pk := range uncovered {
+		fmt.Printf("    ✗ %s\n", pk)
+	}
+}
+
+// coverageShortfall returns the sorted authors assigned to no relay and how
+// many more are assigned to fewer relays than their replica target
+func coverageShortfall(relayAuthors, assigned map[string][]string, opts selectOptions) ([]string, int) {
+	perAuthor := make(map[string]int)
+	for _, authors := range assigned {
+		for _, a := range authors {
+			perAuthor[a]++
+		}
+	}
+	var uncovered []string
+	short := 0
+	for a, relays := range relaysByAuthor(relayAuthors) {
+		switch n := perAuthor[a]; {
+		case n == 0:
+			uncovered = append(uncovered, a)
+		case n < min(opts.replicasFor(len(relays)), len(relays)):
+			short++
+		}
+	}
+	sort.Strings(uncovered)
+	return uncovered, short
+}
+
+// reportPrunedAuthors prints what became of the authors the first selection
+// put on relays that turned out unreachable: moved to other relays, or left
+// without any
+func reportPrunedAuthors(before, after map[string][]string, unreachable map[string]error) {
+	affected := make(set)
+	for relay, authors := range before {
+		if _, bad := unreachable[relay]; bad {
+			for _, a := range authors {
+				affected.add(a)
+			}
+		}
+	}
+	perAuthor := make(map[string]int)
+	for _, authors := range after {
+		for _, a := range authors {
+			perAuthor[a]++
+		}
+	}
+	var uncovered []string
+	for a := range affected {
+		if perAuthor[a] == 0 {
+			uncovered = append(uncovered, a)
+		}
+	}
+	sort.Strings(uncovered)
+	fmt.Printf(" - Follows on unreachable relays: %d (%d reassigned, %d left uncovered)\n",
+		len(affected), len(affected)-len(uncovered), len(uncovered))
+	for _, pk := range uncovered {
+		fmt.Printf("    ✗ %s\n", pk)
+	}
+}
+
+// reportReplicaDistribution prints how many authors got 1, 2, ... relays
+func reportReplicaDistribution(assigned map[string][]string) {
+	perAuthor := make(map[string]int)
+	for _, authors := range assigned {
+		for _, a := range authors {
+			perAuthor[a]++
+		}
+	}
+	counts := make(map[int]int)
+	maxN := 0
+	for _, n := range perAuthor {
+		counts[n]++
+		maxN = max(maxN, n)
+	}
+	fmt.Println("Replicas per author (--replica-fraction):")
+	for n := 1; n <= maxN; n++ {
+		if counts[n] > 0 {
+			fmt.Printf(" - %d relays: %d authors\n", n, counts[n])
+		}
+	}
+}
+
+// assignedAuthors counts the distinct authors in a relay -> authors assignment
+func assignedAuthors(assigned map[string][]string) int {
+	authors := make(set)
+	for _, as := range assigned {
+		for _, a := range as {
+			authors.add(a)
+		}
+	}
+	return len(authors)
+}
+
+// restrictRelayAuthors drops every relay not in allowed from relayAuthors and
+// returns the sorted authors that were reachable before but no longer are
+func restrictRelayAuthors(relayAuthors map[string][]string, allowed []string) []string {
+	keep := make(set)
+	for _, r := range allowed {
+		keep.add(normalizeURL(r))
+	}
+	before := make(set)
+	for r, authors := range relayAuthors {
+		for _, a := range authors {
+			before.add(a)
+		}
+		if !keep.has(r) {
+			delete(relayAuthors, r)
+		}
+	}
+	for _, authors := range relayAuthors {
+		for _, a := range authors {
+			delete(before, a)
+		}
+	}
+	uncovered := make([]string, 0, len(before))
+	for a := range before {
+		uncovered = append(uncovered, a)
+	}
+	sort.Strings(uncovered)
+	return uncovered
+}
+
+// reqBytesPerAuthor approximates the REQ size one author adds to a filter
+// (64 hex chars, quotes and a comma); reqOverhead covers the rest of the REQ.
+const (
+	reqBytesPerAuthor = 67
+	reqOverhead       = 256
+)
+
+// relayChunkSize picks the authors per stream for one relay. Each stream is
+// one subscription, so when the relay advertises max_subscriptions the chunks
+// are consolidated (up to maxPerStream authors) to fit. Chunks are shrunk when
+// max_message_length cannot hold them. A warning is returned when the limits
+// cannot all be met.
+func relayChunkSize(authors, perStream, maxPerStream int, lim *nip11.RelayLimitationDocument) (int, string) {
+	size := perStream
+	if lim == nil || authors == 0 {
+		return size, ""
+	}
+	if maxPerStream < 1 {
+		maxPerStream = defaultMaxAuthorsPerStream
+	}
+	if lim.MaxSubscriptions > 0 && (authors+size-1)/size > lim.MaxSubscriptions {
+		size = (authors + lim.MaxSubscriptions - 1) / lim.MaxSubscriptions
+		if size > maxPerStream {
+			size = maxPerStream
+		}
+	}
+	if lim.MaxMessageLength > 0 {
+		fit := (lim.MaxMessageLength - reqOverhead) / reqBytesPerAuthor
+		if fit < 1 {
+			fit = 1
+		}
+		if size > fit {
+			size = fit
+		}
+	}
+	if lim.MaxSubscriptions > 0 {
+		if n := (authors + size - 1) / size; n > lim.MaxSubscriptions {
+			return size, fmt.Sprintf("needs %d streams but relay allows %d subscriptions; some may be rejected", n, lim.MaxSubscriptions)
+		}
+	}
+	return size, ""
+}
+
+// describeLimits formats the NIP-11 limits relevant to chunking for reports
+func describeLimits(lim *nip11.RelayLimitationDocument) string {
+	if lim == nil {
+		return " (no NIP-11 limits)"
+	}
+	var parts []string
+	if lim.MaxSubscriptions > 0 {
+		parts = append(parts, fmt.Sprintf("max_subscriptions=%d", lim.MaxSubscriptions))
+	}
+	if lim.MaxMessageLength > 0 {
+		parts = append(parts, fmt.Sprintf("max_message_length=%d", lim.MaxMessageLength))
+	}
+	if len(parts) == 0 {
+		return " (no relevant limits)"
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+// consolidateSingleAuthorRelays moves the author of each single-author relay
+// to another selected relay that also covers them (preferring the busiest) and
+// drops the emptied relay. Relays that are the author's only selected option
+// are kept. Returns the new selection; assigned is updated in place.
+func consolidateSingleAuthorRelays(relayAuthors map[string][]string, selected []string, assigned map[string][]string) []string {
+	covers := make(map[string]map[string]struct{}, len(relayAuthors))
+	for relay, authors := range relayAuthors {
+		m := make(map[string]struct{}, len(authors))
+		for _, a := range authors {
+			m[a] = struct{}{}
+		}
+		covers[relay] = m
+	}
+	hasAuthor := func(relay, author string) bool {
+		for _, a := range assigned[relay] {
+			if a == author {
+				return true
+			}
+		}
+		return false
+	}
+
+	dropped := make(map[string]bool)
+	moved, kept := 0, 0
+	for _, relay := range selected {
+		if len(assigned[relay]) != 1 {
+			continue
+		}
+		author := assigned[relay][0]
+		best := ""
+		for _, other := range selected {
+			if other == relay || dropped[other] || len(assigned[other]) < 2 {
+				continue
+			}
+			if _, ok := covers[other][author]; !ok || hasAuthor(other, author) {
+				continue
+			}
+			if best == "" || len(assigned[other]) > len(assigned[best]) {
+				best = other
+			}
+		}
+		if best == "" {
+			kept++
+			continue
+		}
+		assigned[best] = append(assigned[best], author)
+		delete(assigned, relay)
+		dropped[relay] = true
+		moved++
+		fmt.Printf("  - consolidated %s: author %s... moved to %s\n", relay, author[:min(8, len(author))], best)
+	}
+
+	var out []string
+	for _, relay := range selected {
+		if !dropped[relay] {
+			out = append(out, relay)
+		}
+	}
+	fmt.Printf("Consolidated %d single-author relays (%d kept as the author's only option)\n", moved, kept)
+	return out
+}
+
+// writeCoverDot writes the greedy cover as a Graphviz graph: a "follows" node
+// linked to each selected relay, with edges weighted by assigned authors.
+// With replicas, relays sharing authors are linked by the shared count.
+func writeCoverDot(path string, selected []string, assigned map[string][]string) error {
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "graph cover {")
+	fmt.Fprintln(&b, "  layout=neato;")
+	fmt.Fprintln(&b, "  overlap=false;")
+	fmt.Fprintln(&b, `  follows [shape=doublecircle, label="follows"];`)
+
+	maxCount := 1
+	for _, relay := range selected {
+		if n := len(assigned[relay]); n > maxCount {
+			maxCount = n
+		}
+	}
+	id := make(map[string]string, len(selected))
+	for i, relay := range selected {
+		id[relay] = fmt.Sprintf("r%d", i+1)
+		n := len(assigned[relay])
+		fmt.Fprintf(&b, "  %s [shape=box, label=\"%s\\n%d authors\"];\n", id[relay], urlToHost(relay), n)
+		fmt.Fprintf(&b, "  follows -- %s [label=\"%d\", weight=%d, penwidth=%.1f];\n",
+			id[relay], n, n, 1+4*float64(n)/float64(maxCount))
+	}
+
+	// Overlap between relays (only non-empty when authors have several replicas)
+	owners := make(map[string][]string)
+	for _, relay := range selected {
+		for _, a := range assigned[relay] {
+			owners[a] = append(owners[a], relay)
+		}
+	}
+	shared := make(map[[2]string]int)
+	for _, relays := range owners {
+		for i := 0; i < len(relays); i++ {
+			for j := i + 1; j < len(relays); j++ {
+				shared[[2]string{relays[i], relays[j]}]++
+			}
+		}
+	}
+	pairs := make([][2]string, 0, len(shared))
+	for p := range shared {
+		pairs = append(pairs, p)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return id[pairs[i][0]] < id[pairs[j][0]]
+		}
+		return id[pairs[i][1]] < id[pairs[j][1]]
+	})
+	for _, p := range pairs {
+		fmt.Fprintf(&b, "  %s -- %s [style=dashed, label=\"%d shared\", weight=%d];\n", id[p[0]], id[p[1]], shared[p], shared[p])
+	}
+	fmt.Fprintln(&b, "}")
+	return writeFileAtomic(path, b.Bytes())
+}
+
+// pruneInactiveFollows removes follows that haven't posted in maxInactiveDays
+// from followsSet and records them in inactive_follows.txt next to the activity file
+func pruneInactiveFollows(followsSet map[string]struct{}, activityFile string, maxInactiveDays int) {
+	latest, windowDays, err := loadActivity(activityFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: --max-inactive set but %s is unreadable (run collect --activity-days): %v\n", activityFile, err)
+		return
+	}
+	if windowDays <= maxInactiveDays {
+		fmt.Fprintf(os.Stderr, "warning: activity covers %d days <= --max-inactive %d; follows with no note in the window are unknown and kept, so few are pruned\n", windowDays, maxInactiveDays)
+	}
+	inactive := inactiveFollows(followsSet, latest, maxInactiveDays)
+	for _, pk := range inactive {
+		delete(followsSet, pk)
+	}
+	inactivePath := filepath.Join(filepath.Dir(activityFile), "inactive_follows.txt")
+	if err := writeLines(inactivePath, inactive); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write %s: %v\n", inactivePath, err)
+	}
+	fmt.Printf("Pruned %d follows inactive for more than %d days (listed in %s)\n", len(inactive), maxInactiveDays, inactivePath)
+}
+
+// dedupStreams collapses streams that would open redundant subscriptions:
+// streams with the same dir, URLs, kinds, #p and extra filter whose authors
+// are the same or a subset of an earlier stream's. A stream without authors
+// is unfiltered and covers any author list. The kept stream keeps its name
+// and takes the larger author list, so no chunk grows beyond what was
+// generated.
+func dedupStreams(streams []streamConfig) []streamConfig {
+	groupKey := func(s streamConfig) string {
+		urls := append([]string(nil), s.URLs...)
+		sort.Strings(urls)
+		return strings.Join([]string{s.Dir, strings.Join(urls, " "), s.Kinds, s.PTag, s.Filter}, "|")
+	}
+	subset := func(a, b []string) bool {
+		in := make(set, len(b))
+		for _, x := range b {
+			in.add(x)
+		}
+		for _, x := range a {
+			if !in.has(x) {
+				return false
+			}
+		}
+		return true
+	}
+	// covers reports whether outer receives everything inner does
+	covers := func(outer, inner streamConfig) bool {
+		if len(outer.Authors) == 0 {
+			return true
+		}
+		return len(inner.Authors) > 0 && subset(inner.Authors, outer.Authors)
+	}
+
+	groups := make(map[string][]int)
+	var out []streamConfig
+	merged := 0
+next:
+	for _, s := range streams {
+		key := groupKey(s)
+		for _, i := range groups[key] {
+			switch {
+			case covers(out[i], s):
+				fmt.Printf("Merged stream %s into %s (same relays and filter)\n", s.Name, out[i].Name)
+				merged++
+				continue next
+			case covers(s, out[i]):
+				fmt.Printf("Merged stream %s into %s (same relays and filter)\n", s.Name, out[i].Name)
+				out[i].Authors = s.Authors
+				merged++
+				continue next
+			}
+		}
+		groups[key] = append(groups[key], len(out))
+		out = append(out, s)
+	}
+	if merged > 0 {
+		fmt.Printf("Merged %d redundant streams\n", merged)
+	}
+	return out
+}
+
+// lintStreams drops streams without any URL, which strfry can't run, and
+// warns about down streams with neither authors nor a #p filter, which pull
+// every event the relays have
+func lintStreams(streams []streamConfig) []streamConfig {
+	var out []streamConfig
+	for _, s := range streams {
+		var urls []string
+		for _, u := range s.URLs {
+			if strings.TrimSpace(u) != "" {
+				urls = append(urls, u)
+			}
+		}
+		if len(urls) == 0 {
+			fmt.Fprintf(os.Stderr, "warning: dropped stream %s: no relay URLs\n", s.Name)
+			continue
+		}
+		s.URLs = urls
+		if s.Dir == "down" && len(s.Authors) == 0 && s.PTag == "" {
+			fmt.Fprintf(os.Stderr, "warning: down stream %s has no authors or #p filter and pulls everything from %s\n", s.Name, strings.Join(urls, ", "))
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// stripPlaintextRelays removes ws:// URLs from streams, dropping streams left
+// without URLs, and reports what was removed
+func stripPlaintextRelays(streams []streamConfig) []streamConfig {
+	dropped := set{}
+	var out []streamConfig
+	for _, s := range streams {
+		var urls []string
+		for _, u := range s.URLs {
+			if isPlaintextRelay(u) {
+				dropped.add(u)
+				continue
+			}
+			urls = append(urls, u)
+		}
+		if len(urls) == 0 {
+			fmt.Printf("Dropped stream %s: only ws:// relays\n", s.Name)
+			continue
+		}
+		s.URLs = urls
+		out = append(out, s)
+	}
+	if len(dropped) > 0 {
+		var urls []string
+		for u := range dropped {
+			urls = append(urls, u)
+		}
+		sort.Strings(urls)
+		fmt.Printf("Dropped %d ws:// relays (--require-wss): %s\n", len(urls), strings.Join(urls, ", "))
+	}
+	return out
+}
+
+// authorBucket is one stably named chunk of a relay's authors
+type authorBucket struct {
+	label   string
+	authors []string
+}
+
+// stableChunks splits hex authors into k buckets by pubkey prefix, with k the
+// smallest power of two that keeps every bucket at or below size. Unlike
+// chunk, adding or removing an author only changes its own bucket unless k
+// has to change. With maxBuckets > 0 (a relay's max_subscriptions), k stays at
+// or below it, and buckets may then exceed size. Buckets are labeled
+// "<i>of<k>", authors are sorted and empty buckets are omitted.
+func stableChunks(authors []string, size, maxBuckets int) []authorBucket {
+	if size < 1 {
+		size = 1
+	}
+	sorted := append([]string(nil), authors...)
+	sort.Strings(sorted)
+	capped := func(k int) bool { return maxBuckets > 0 && k*2 > maxBuckets }
+	k := 1
+	for k*size < len(sorted) && !capped(k) {
+		k *= 2
+	}
+	for {
+		buckets := make([][]string, k)
+		fits := true
+		for _, a := range sorted {
+			prefix, _ := strconv.ParseUint(a[:4], 16, 32)
+			i := int(prefix) * k >> 16
+			buckets[i] = append(buckets[i], a)
+			if len(buckets[i]) > size {
+				fits = false
+			}
+		}
+		if fits || k >= 1<<16 || capped(k) {
+			var out []authorBucket
+			for i, b := range buckets {
+				if len(b) > 0 {
+					out = append(out, authorBucket{label: fmt.Sprintf("%dof%d", i+1, k), authors: b})
+				}
+			}
+			return out
+		}
+		k *= 2
+	}
+}
+
+// largestBucket returns the author count of the fullest bucket
+func largestBucket(buckets []authorBucket) int {
+	n := 0
+	for _, b := range buckets {
+		if len(b.authors) > n {
+			n = len(b.authors)
+		}
+	}
+	return n
+}
+
+// contentPresets map --content-preset names to down-stream kinds filters.
+// An empty filter means all kinds.
+var contentPresets = map[string]string{
+	"notes":  "[0,1,5,6]",                 // profiles, notes, deletions, reposts
+	"social": "[0,1,3,5,6,7]",             // notes plus follow lists and reactions
+	"media":  "[0,1,5,6,7,20,21,22,1063]", // social without follow lists, plus pictures, videos and file metadata
+	"all":    "",
+}
+
+// contentPresetNames returns the preset names in sorted order
+func contentPresetNames() []string {
+	names := make([]string, 0, len(contentPresets))
+	for name := range contentPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateKindsJSON checks that a kinds filter is empty or a JSON array of
+// non-negative integers
+func validateKindsJSON(s string) error {
+	if s == "" {
+		return nil
+	}
+	var kinds []int
+	if err := json.Unmarshal([]byte(s), &kinds); err != nil {
+		return fmt.Errorf("%s is not a JSON array of kinds: %w", s, err)
+	}
+	for _, k := range kinds {
+		if k < 0 || k > 65535 {
+			return fmt.Errorf("kind %d out of range 0-65535", k)
+		}
+	}
+	return nil
+}
+
+// validateExtraFilterJSON checks --extra-filter-json: a JSON object of NIP-01
+// filter fields other than the ones gen-router computes (authors, #p, kinds)
+func validateExtraFilterJSON(s string) error {
+	if s == "" {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(s), &fields); err != nil {
+		return fmt.Errorf("%s is not a JSON object: %w", s, err)
+	}
+	for _, k := range sortedRawKeys(fields) {
+		v := fields[k]
+		switch {
+		case k == "authors" || k == "#p":
+			return fmt.Errorf("%q is computed by gen-router and cannot be overridden", k)
+		case k == "kinds":
+			return fmt.Errorf("use --kinds-json or --content-preset for kinds")
+		case k == "since" || k == "until" || k == "limit":
+			var n int64
+			if err := json.Unmarshal(v, &n); err != nil || n < 0 {
+				return fmt.Errorf("%q must be a non-negative integer, got %s", k, v)
+			}
+		case k == "search":
+			var q string
+			if err := json.Unmarshal(v, &q); err != nil {
+				return fmt.Errorf("%q must be a string, got %s", k, v)
+			}
+		case k == "ids" || (len(k) == 2 && k[0] == '#' && isASCIILetter(k[1])):
+			var vals []string
+			if err := json.Unmarshal(v, &vals); err != nil {
+				return fmt.Errorf("%q must be an array of strings, got %s", k, v)
+			}
+		default:
+			return fmt.Errorf("unknown filter field %q", k)
+		}
+	}
+	return nil
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func sortedRawKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// clampAuthorsPerStream bounds the requested authors per stream to [1, max],
+// warning when the request had to be changed
+func clampAuthorsPerStream(requested, max int) int {
+	if max < 1 {
+		max = defaultMaxAuthorsPerStream
+	}
+	if requested < 1 {
+		fmt.Fprintf(os.Stderr, "warning: --authors-per-stream %d is invalid, using 1\n", requested)
+		return 1
+	}
+	if requested > max {
+		fmt.Fprintf(os.Stderr, "warning: --authors-per-stream %d exceeds %d and is likely to be rejected by relays; clamping to %d\n", requested, max, max)
+		return max
+	}
+	return requested
+}
+
+// userReadRelays filters relays to those the user's 10002 marks for reading
+// ("read" or no marker). It returns false when the markers file is missing or
+// carries no explicit markers, in which case all relays should be used.
+func userReadRelays(markersFile string, relays []string) ([]string, bool) {
+	lines, err := readLines(markersFile)
+	if err != nil {
+		return nil, false
+	}
+	markers := make(map[string]string)
+	explicit := false
+	for _, l := range lines {
+		fields := strings.Fields(l)
+		if len(fields) == 0 {
+			continue
+		}
+		marker := ""
+		if len(fields) >= 2 {
+			marker = strings.ToLower(fields[1])
+			explicit = true
+		}
+		markers[normalizeURL(fields[0])] = marker
+	}
+	if !explicit {
+		return nil, false
+	}
+	var out []string
+	for _, r := range relays {
+		// Relays added by hand to user_relay_list.txt have no marker and are kept
+		if m, ok := markers[normalizeURL(r)]; !ok || m == "" || m == "read" {
+			out = append(out, r)
+		}
+	}
+	return out, true
+}
+
+// loadPrimaryRelays reads "pubkey url" lines into an author -> primary relay map
+func loadPrimaryRelays(path string) map[string]string {
+	out := make(map[string]string)
+	lines, err := readLines(path)
+	if err != nil {
+		return out
+	}
+	for _, l := range lines {
+		fields := strings.Fields(l)
+		if len(fields) < 2 {
+			continue
+		}
+		out[strings.ToLower(fields[0])] = normalizeURL(fields[1])
+	}
+	return out
+}
+
+// loadAssignments reads "pubkey relay" lines into a relay -> authors map
+func loadAssignments(path string) map[string][]string {
+	out := make(map[string][]string)
+	lines, err := readLines(path)
+	if err != nil {
+		return out
+	}
+	for _, l := range lines {
+		fields := strings.Fields(l)
+		if len(fields) < 2 {
+			continue
+		}
+		relay := normalizeURL(fields[1])
+		out[relay] = append(out[relay], strings.ToLower(fields[0]))
+	}
+	return out
+}
+
+// writeAssignments saves the relay -> authors assignment as sorted "pubkey relay" lines
+func writeAssignments(path string, assigned map[string][]string) error {
+	var lines []string
+	for relay, authors := range assigned {
+		for _, a := range authors {
+			lines = append(lines, fmt.Sprintf("%s %s", a, relay))
+		}
+	}
+	sort.Strings(lines)
+	return writeLines(path, lines)
+}
+
+// reportStickyChanges prints how many previously assigned authors kept a prior
+// relay, how many moved, and how many are newly assigned
+func reportStickyChanges(prev, assigned map[string][]string) {
+	prevByAuthor := make(map[string]set)
+	for relay, authors := range prev {
+		for _, a := range authors {
+			if prevByAuthor[a] == nil {
+				prevByAuthor[a] = set{}
+			}
+			prevByAuthor[a].add(relay)
+		}
+	}
+	kept, changed, added := set{}, set{}, set{}
+	for relay, authors := range assigned {
+		for _, a := range authors {
+			p, ok := prevByAuthor[a]
+			switch {
+			case !ok:
+				added.add(a)
+			case p.has(relay):
+				kept.add(a)
+			default:
+				changed.add(a)
+			}
+		}
+	}
+	// An author that kept one relay but moved another replica counts as kept
+	for a := range kept {
+		delete(changed, a)
+	}
+	fmt.Printf("Sticky assignments: %d kept, %d changed, %d new\n", len(kept), len(changed), len(added))
+}
+
+// loadRelayRegions reads "<relay-url> <region>" lines into a map keyed by normalized URL
+func loadRelayRegions(path string) map[string]string {
+	regions := make(map[string]string)
+	lines, err := readLines(path)
+	if err != nil {
+		return regions
+	}
+	for _, l := range lines {
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
+		fields := strings.Fields(l)
+		if len(fields) < 2 {
+			continue
+		}
+		regions[normalizeURL(fields[0])] = strings.ToLower(fields[1])
+	}
+	return regions
+}
+
+// reportCrossRegion prints how many assignments landed outside the preferred region,
+// and how many of those were unavoidable because the author has no relay in that region
+func reportCrossRegion(relayAuthors, assigned map[string][]string, regions map[string]string, region string) {
+	hasInRegion := set{}
+	for relay, authors := range relayAuthors {
+		if regions[relay] != region {
+			continue
+		}
+		for _, a := range authors {
+			hasInRegion.add(a)
+		}
+	}
+	cross, unavoidable := 0, 0
+	for relay, authors := range assigned {
+		if regions[relay] == region {
+			continue
+		}
+		for _, a := range authors {
+			cross++
+			if !hasInRegion.has(a) {
+				unavoidable++
+			}
+		}
+	}
+	fmt.Printf("Region %q: %d cross-region assignments (%d unavoidable, author has no relay in region)\n", region, cross, unavoidable)
+}
+
+func readLinesMust(path string) []string {
+	lines, err := readLines(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	for i := range lines {
+		lines[i] = normalizeURL(lines[i])
+	}
+	return lines
+}
+
+func readLinesIfExists(path string) []string {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil
+	}
+	for i := range lines {
+		lines[i] = normalizeURL(lines[i])
+	}
+	return lines
+}
+
+func loadSetMust(path string) map[string]struct{} {
+	m := make(map[string]struct{})
+	lines, err := readLines(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	for _, l := range lines {
+		l = strings.ToLower(strings.TrimSpace(l))
+		if l != "" {
+			m[l] = struct{}{}
+		}
+	}
+	return m
+}
+
+func uniqueSorted(in []string) []string {
+	m := make(map[string]struct{})
+	for _, v := range in {
+		m[v] = struct{}{}
+	}
+	var out []string
+	for v := range m {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func chunk[T any](in []T, n int) [][]T {
+	if n <= 0 || len(in) == 0 {
+		return nil
+	}
+	var out [][]T
+	for i := 0; i < len(in); i += n {
+		j := i + n
+		if j > len(in) {
+			j = len(in)
+		}
+		out = append(out, in[i:j])
+	}
+	return out
+}
+
+func safeName(relay string) string {
 	name := strings.TrimPrefix(relay, "wss://")
 	name = strings.TrimPrefix(name, "ws://")
 	name = strings.ReplaceAll(name, ":", "_")
@@ -369,25 +1897,79 @@ func safeName(relay string) string {
 	return name
 }
 
-func writeRouterConfig(path string, streams []streamConfig) error {
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-		return err
+// writeRouterConfig renders the config and writes it atomically. An existing
+// file with identical content is left untouched so its mtime doesn't change
+// and strfry isn't needlessly reloaded. Reports whether the file changed.
+func writeRouterConfig(path string, streams []streamConfig, urlForm string, header []string) (bool, error) {
+	var buf bytes.Buffer
+	for _, l := range header {
+		fmt.Fprintf(&buf, "# %s\n", l)
 	}
-	f, err := os.Create(path)
-	if err != nil {
-		return err
+	if len(header) > 0 {
+		buf.WriteByte('\n')
+	}
+	if err := renderRouterConfig(&buf, streams, urlForm); err != nil {
+		return false, err
+	}
+	// Only the header's timestamp changed: keep the file so strfry doesn't reload
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(stripGeneratedAt(old), stripGeneratedAt(buf.Bytes())) {
+		return false, nil
+	}
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// configHeader describes how a config was generated, for its comment header
+func configHeader(replicas, authorsPerStream int, onlineOnly bool, strategy string, follows int) []string {
+	return []string{
+		fmt.Sprintf("%s%s at %s", generatedBy, version, time.Now().UTC().Format(time.RFC3339)),
+		fmt.Sprintf("replicas=%d authors-per-stream=%d online-only=%t strategy=%s follows=%d",
+			replicas, authorsPerStream, onlineOnly, strategy, follows),
 	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
+}
+
+// generatedBy starts the header line that carries the generation time
+const generatedBy = "Generated by feedbuilder "
+
+// stripGeneratedAt removes the generation time line from a config
+func stripGeneratedAt(config []byte) []byte {
+	if !bytes.HasPrefix(config, []byte("# "+generatedBy)) {
+		return config
+	}
+	if i := bytes.IndexByte(config, '\n'); i >= 0 {
+		return config[i+1:]
+	}
+	return nil
+}
+
+// renderRouterConfig writes the taocpp::config for streams to w, with relay
+// URLs in urlForm (see formatRelayURL)
+func renderRouterConfig(out io.Writer, streams []streamConfig, urlForm string) error {
+	w := bufio.NewWriter(out)
 	fmt.Fprintln(w, "connectionTimeout = 20")
 	fmt.Fprintln(w)
 	fmt.Fprintln(w, "streams {")
 	for _, s := range streams {
+		if s.Comment != "" {
+			fmt.Fprintf(w, "  # %s\n", s.Comment)
+		}
 		fmt.Fprintf(w, "  %s {\n", s.Name)
 		fmt.Fprintf(w, "    dir = \"%s\"\n", s.Dir)
-		if s.Dir == "down" && (len(s.Authors) > 0 || s.PTag != "") {
+		if s.Dir == "down" && (len(s.Authors) > 0 || s.PTag != "" || s.Filter != "") {
 			filter := make(map[string]any)
 
+			// Extra fields first; they never name authors, #p or kinds
+			if s.Filter != "" {
+				var extra map[string]json.RawMessage
+				if err := json.Unmarshal([]byte(s.Filter), &extra); err == nil {
+					for k, v := range extra {
+						filter[k] = v
+					}
+				}
+			}
+
 			// Add authors filter if present
 			if len(s.Authors) > 0 {
 				filter["authors"] = s.Authors
@@ -415,7 +1997,7 @@ func writeRouterConfig(path string, streams []streamConfig) error {
 		fmt.Fprintln(w)
 		fmt.Fprintln(w, "    urls = [")
 		for _, u := range s.URLs {
-			fmt.Fprintf(w, "      \"%s\"\n", u)
+			fmt.Fprintf(w, "      \"%s\"\n", formatRelayURL(u, urlForm))
 		}
 		fmt.Fprintln(w, "    ]")
 		fmt.Fprintln(w, "  }")
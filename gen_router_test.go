@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelayLowerBoundReplicaFraction(t *testing.T) {
+	// a lists three relays, b two, c one
+	relayAuthors := map[string][]string{
+		"wss://r1": {"a", "b", "c"},
+		"wss://r2": {"a", "b"},
+		"wss://r3": {"a"},
+	}
+	tests := []struct {
+		name              string
+		opts              selectOptions
+		bound, forcedWant int
+	}{
+		// Every author needs all its relays
+		{"replicas 3", selectOptions{replicas: 3}, 3, 3},
+		// a needs ceil(3*0.5)=2 of 3, b 1 of 2, c its only relay r1
+		{"replicas 3 fraction 0.5", selectOptions{replicas: 3, replicaFraction: 0.5}, 2, 1},
+		{"replicas 1", selectOptions{replicas: 1}, 1, 1},
+	}
+	for _, tt := range tests {
+		bound, forced := relayLowerBound(relayAuthors, tt.opts)
+		if bound != tt.bound || forced != tt.forcedWant {
+			t.Errorf("%s: bound %d, forced %d; want %d, %d", tt.name, bound, forced, tt.bound, tt.forcedWant)
+		}
+		// The bound never exceeds what the greedy needs for full coverage
+		selected, assigned := greedySelectAndAssignN(relayAuthors, tt.opts)
+		if uncovered, short := coverageShortfall(relayAuthors, assigned, tt.opts); len(uncovered) > 0 || short > 0 {
+			t.Errorf("%s: greedy left %v uncovered, %d short", tt.name, uncovered, short)
+		}
+		if bound > len(selected) {
+			t.Errorf("%s: bound %d above the greedy's %d relays", tt.name, bound, len(selected))
+		}
+	}
+}
+
+func TestDedupStreams(t *testing.T) {
+	down := func(name string, authors ...string) streamConfig {
+		return streamConfig{Name: name, Dir: "down", URLs: []string{"wss://r1", "wss://r2"}, Kinds: "[1]", Authors: authors}
+	}
+	type kept struct {
+		name    string
+		authors []string
+	}
+	tests := []struct {
+		name    string
+		streams []streamConfig
+		want    []kept
+	}{
+		{"identical", []streamConfig{down("a", "x", "y"), down("b", "x", "y")}, []kept{{"a", []string{"x", "y"}}}},
+		{"subset of earlier", []streamConfig{down("a", "x", "y"), down("b", "y")}, []kept{{"a", []string{"x", "y"}}}},
+		{"superset of earlier", []streamConfig{down("a", "x"), down("b", "x", "y", "z")}, []kept{{"a", []string{"x", "y", "z"}}}},
+		{"partial overlap", []streamConfig{down("a", "x", "y"), down("b", "y", "z")}, []kept{{"a", []string{"x", "y"}}, {"b", []string{"y", "z"}}}},
+		{"URL order", []streamConfig{down("a", "x"), {Name: "b", Dir: "down", URLs: []string{"wss://r2", "wss://r1"}, Kinds: "[1]", Authors: []string{"x"}}}, []kept{{"a", []string{"x"}}}},
+		{"other kinds", []streamConfig{down("a", "x"), {Name: "b", Dir: "down", URLs: []string{"wss://r1", "wss://r2"}, Kinds: "[7]", Authors: []string{"x"}}}, []kept{{"a", []string{"x"}}, {"b", []string{"x"}}}},
+		{"other filter", []streamConfig{down("a", "x"), {Name: "b", Dir: "down", URLs: []string{"wss://r1", "wss://r2"}, Kinds: "[1]", Filter: `{"#t":["nostr"]}`, Authors: []string{"x"}}}, []kept{{"a", []string{"x"}}, {"b", []string{"x"}}}},
+		{"other dir", []streamConfig{down("a", "x"), {Name: "b", Dir: "up", URLs: []string{"wss://r1", "wss://r2"}, Kinds: "[1]", Authors: []string{"x"}}}, []kept{{"a", []string{"x"}}, {"b", []string{"x"}}}},
+		// A stream without authors pulls every author: it absorbs filtered
+		// streams and is never absorbed by one
+		{"unfiltered then filtered", []streamConfig{down("all"), down("a", "x")}, []kept{{"all", nil}}},
+		{"filtered then unfiltered", []streamConfig{down("a", "x"), down("all")}, []kept{{"a", nil}}},
+		{"both unfiltered", []streamConfig{down("all"), down("all2")}, []kept{{"all", nil}}},
+	}
+	for _, tt := range tests {
+		got := dedupStreams(tt.streams)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: kept %d streams, want %d: %+v", tt.name, len(got), len(tt.want), got)
+			continue
+		}
+		for i, w := range tt.want {
+			if got[i].Name != w.name || strings.Join(got[i].Authors, ",") != strings.Join(w.authors, ",") {
+				t.Errorf("%s: stream %d is %s %v, want %s %v", tt.name, i, got[i].Name, got[i].Authors, w.name, w.authors)
+			}
+		}
+	}
+}
+
+func TestClampAuthorsPerStream(t *testing.T) {
+	tests := []struct {
+		requested, max, want int
+	}{
+		{300, 1000, 300},
+		{1000, 1000, 1000},
+		{1001, 1000, 1000},
+		{5000, 200, 200},
+		{1, 200, 1},
+		{0, 200, 1},
+		{-3, 200, 1},
+		// An unusable max falls back to the default
+		{5000, 0, defaultMaxAuthorsPerStream},
+		{500, -1, 500},
+	}
+	for _, tt := range tests {
+		if got := clampAuthorsPerStream(tt.requested, tt.max); got != tt.want {
+			t.Errorf("clampAuthorsPerStream(%d, %d) = %d, want %d", tt.requested, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestConfigHeader(t *testing.T) {
+	header := configHeader(2, 300, true, "greedy", 150)
+	if len(header) != 2 {
+		t.Fatalf("header has %d lines: %q", len(header), header)
+	}
+	stamp, ok := strings.CutPrefix(header[0], generatedBy+version+" at ")
+	if !ok {
+		t.Fatalf("first line %q does not name the generator", header[0])
+	}
+	if _, err := time.Parse(time.RFC3339, stamp); err != nil {
+		t.Errorf("generation time %q: %v", stamp, err)
+	}
+	if want := "replicas=2 authors-per-stream=300 online-only=true strategy=greedy follows=150"; header[1] != want {
+		t.Errorf("settings line %q, want %q", header[1], want)
+	}
+}
+
+func TestWriteRouterConfigIgnoresGeneratedAt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "router.config")
+	streams := []streamConfig{{Name: "follows_0", Dir: "down", Authors: []string{"a"}, URLs: []string{"wss://r1"}}}
+	settings := "replicas=2 authors-per-stream=300 online-only=false strategy=greedy follows=1"
+	oldHeader := []string{generatedBy + version + " at 2024-01-01T00:00:00Z", settings}
+
+	if changed, err := writeRouterConfig(path, streams, urlFormBare, oldHeader); err != nil || !changed {
+		t.Fatalf("first write: changed=%t, %v", changed, err)
+	}
+	before, _ := os.ReadFile(path)
+
+	// Same config, new timestamp: reported unchanged and left as is
+	newHeader := []string{generatedBy + version + " at 2024-06-01T12:00:00Z", settings}
+	if changed, err := writeRouterConfig(path, streams, urlFormBare, newHeader); err != nil || changed {
+		t.Fatalf("timestamp only: changed=%t, %v", changed, err)
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+		t.Error("file rewritten although only the timestamp differs")
+	}
+
+	// Other header lines and the streams do count
+	if changed, _ := writeRouterConfig(path, streams, urlFormBare, []string{newHeader[0], strings.Replace(settings, "replicas=2", "replicas=3", 1)}); !changed {
+		t.Error("changed settings line not written")
+	}
+	streams[0].URLs = []string{"wss://r2"}
+	if changed, _ := writeRouterConfig(path, streams, urlFormBare, newHeader); !changed {
+		t.Error("changed streams not written")
+	}
+}
+
+func TestGenRouterReproducible(t *testing.T) {
+	pk := func(c string) string { return strings.Repeat(c, 64) }
+	// Six relays of two authors each: every greedy step is a tie
+	var follows, lines []string
+	for i, a := range []string{"a", "b", "c", "d", "e", "f", "1", "2", "3", "4", "5", "6"} {
+		follows = append(follows, pk(a))
+		lines = append(lines, fmt.Sprintf("%s wss://r%d.example.com", pk(a), i/2))
+	}
+	run := func(flags ...string) []byte {
+		dir := t.TempDir()
+		if err := writeLines(filepath.Join(dir, "follows_list.txt"), follows); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeLines(filepath.Join(dir, "pubkey_relays_map.txt"), lines); err != nil {
+			t.Fatal(err)
+		}
+		output := filepath.Join(dir, "router.config")
+		args := append([]string{"--data-dir", dir, "--output", output}, flags...)
+		captureOutput(t, &os.Stdout, func() {
+			captureOutput(t, &os.Stderr, func() { genRouterCmd(context.Background(), args) })
+		})
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stripGeneratedAt(data)
+	}
+
+	for _, flags := range [][]string{nil, {"--stable-streams"}, {"--authors-per-stream", "1"}} {
+		first := run(flags...)
+		for i := 0; i < 5; i++ {
+			if again := run(flags...); !bytes.Equal(again, first) {
+				t.Fatalf("flags %v: run %d differs from the first:\n%s\nfirst:\n%s", flags, i+2, again, first)
+			}
+		}
+	}
+}
+
+func TestStripGeneratedAt(t *testing.T) {
+	plain := []byte("connectionTimeout = 20\n")
+	if got := stripGeneratedAt(plain); !bytes.Equal(got, plain) {
+		t.Errorf("config without header changed to %q", got)
+	}
+	withHeader := []byte("# " + generatedBy + "dev at 2024-01-01T00:00:00Z\n# replicas=2\n\nconnectionTimeout = 20\n")
+	if got := string(stripGeneratedAt(withHeader)); got != "# replicas=2\n\nconnectionTimeout = 20\n" {
+		t.Errorf("stripped to %q", got)
+	}
+}
+
+func TestValidateExtraFilterJSON(t *testing.T) {
+	valid := []string{
+		"",
+		`{"#t":["nostr","bitcoin"]}`,
+		`{"#t":["nostr"],"since":1700000000,"until":1800000000,"limit":500}`,
+		`{"ids":["abc"],"search":"relay","#e":[]}`,
+	}
+	for _, s := range valid {
+		if err := validateExtraFilterJSON(s); err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+	invalid := []string{
+		`["#t"]`,
+		`{"authors":["a"]}`,
+		`{"#p":["a"]}`,
+		`{"kinds":[1]}`,
+		`{"#t":"nostr"}`,
+		`{"#tt":["x"]}`,
+		`{"#1":["x"]}`,
+		`{"since":-1}`,
+		`{"limit":"10"}`,
+		`{"search":1}`,
+		`{"foo":1}`,
+	}
+	for _, s := range invalid {
+		if err := validateExtraFilterJSON(s); err == nil {
+			t.Errorf("%s: accepted", s)
+		}
+	}
+}
+
+// filterOf returns the filter rendered for the only stream in config
+func filterOf(t *testing.T, config string) map[string]any {
+	t.Helper()
+	for _, l := range strings.Split(config, "\n") {
+		if raw, ok := strings.CutPrefix(strings.TrimSpace(l), "filter = "); ok {
+			var f map[string]any
+			if err := json.Unmarshal([]byte(raw), &f); err != nil {
+				t.Fatalf("filter %s: %v", raw, err)
+			}
+			return f
+		}
+	}
+	return nil
+}
+
+func TestRenderExtraTagFilter(t *testing.T) {
+	extra := `{"#t":["nostr","bitcoin"],"since":1700000000}`
+	if err := validateExtraFilterJSON(extra); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	streams := []streamConfig{{Name: "follows_0", Dir: "down", Authors: []string{"a", "b"}, Kinds: "[1,6]", Filter: extra, URLs: []string{"wss://r1"}}}
+	if err := renderRouterConfig(&buf, streams, urlFormBare); err != nil {
+		t.Fatal(err)
+	}
+	got := filterOf(t, buf.String())
+	want := map[string]any{
+		"#t":      []any{"nostr", "bitcoin"},
+		"since":   float64(1700000000),
+		"authors": []any{"a", "b"},
+		"kinds":   []any{float64(1), float64(6)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filter %v, want %v", got, want)
+	}
+
+	// A down stream with only the extra filter still gets a filter line
+	buf.Reset()
+	streams = []streamConfig{{Name: "all", Dir: "down", Filter: `{"#t":["nostr"]}`, URLs: []string{"wss://r1"}}}
+	if err := renderRouterConfig(&buf, streams, urlFormBare); err != nil {
+		t.Fatal(err)
+	}
+	if got := filterOf(t, buf.String()); !reflect.DeepEqual(got, map[string]any{"#t": []any{"nostr"}}) {
+		t.Errorf("extra-only filter %v", got)
+	}
+}
+
+// captureOutput returns what fn writes to *f (os.Stdout or os.Stderr)
+func captureOutput(t *testing.T, f **os.File, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := *f
+	*f = w
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	defer func() { *f = prev }()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestLintStreams(t *testing.T) {
+	streams := []streamConfig{
+		{Name: "no_urls", Dir: "down", Authors: []string{"a"}},
+		{Name: "blank_urls", Dir: "down", Authors: []string{"a"}, URLs: []string{"", "  "}},
+		{Name: "some_blank", Dir: "down", Authors: []string{"a"}, URLs: []string{"", "wss://r1", " "}},
+		{Name: "unfiltered", Dir: "down", URLs: []string{"wss://r1"}},
+		{Name: "notifications", Dir: "down", PTag: "me", URLs: []string{"wss://r2"}},
+		{Name: "up_all", Dir: "up", URLs: []string{"wss://r3"}},
+	}
+	var out []streamConfig
+	warnings := captureOutput(t, &os.Stderr, func() { out = lintStreams(streams) })
+
+	var names []string
+	for _, s := range out {
+		names = append(names, s.Name)
+	}
+	if want := "some_blank unfiltered notifications up_all"; strings.Join(names, " ") != want {
+		t.Errorf("kept %v, want %s", names, want)
+	}
+	if len(out) > 0 && !reflect.DeepEqual(out[0].URLs, []string{"wss://r1"}) {
+		t.Errorf("blank URLs kept: %q", out[0].URLs)
+	}
+	for _, want := range []string{
+		"dropped stream no_urls: no relay URLs",
+		"dropped stream blank_urls: no relay URLs",
+		"down stream unfiltered has no authors or #p filter",
+	} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("no warning %q in:\n%s", want, warnings)
+		}
+	}
+	// #p streams and up streams without authors are expected
+	for _, quiet := range []string{"notifications", "up_all", "some_blank"} {
+		if strings.Contains(warnings, quiet) {
+			t.Errorf("unexpected warning about %s:\n%s", quiet, warnings)
+		}
+	}
+
+	if got := lintStreams(nil); len(got) != 0 {
+		t.Errorf("lintStreams(nil) = %v", got)
+	}
+}
+
+func TestRelayBudgetLeavesAuthorsUncovered(t *testing.T) {
+	relayAuthors := selectorFixture()
+	tests := []struct {
+		name      string
+		opts      selectOptions
+		selected  int
+		uncovered []string
+		short     int
+	}{
+		// big alone covers a-d; e and f have no relay left in the budget
+		{"one relay", selectOptions{replicas: 1, maxRelays: 1}, 1, []string{"e", "f"}, 0},
+		{"enough relays", selectOptions{replicas: 1, maxRelays: 5}, 2, nil, 0},
+		// big and mid give a, b two relays; c, d list only big, e gets mid
+		// and f none of its two
+		{"two relays, two replicas", selectOptions{replicas: 2, maxRelays: 2}, 2, []string{"f"}, 1},
+	}
+	for _, tt := range tests {
+		selected, assigned := greedySelectAndAssignN(relayAuthors, tt.opts)
+		if len(selected) != tt.selected {
+			t.Errorf("%s: selected %v, want %d relays", tt.name, selected, tt.selected)
+		}
+		uncovered, short := coverageShortfall(relayAuthors, assigned, tt.opts)
+		if strings.Join(uncovered, ",") != strings.Join(tt.uncovered, ",") || short != tt.short {
+			t.Errorf("%s: uncovered %v, %d short; want %v, %d", tt.name, uncovered, short, tt.uncovered, tt.short)
+		}
+	}
+
+	opts := selectOptions{replicas: 1, maxRelays: 1}
+	selected, assigned := greedySelectAndAssignN(relayAuthors, opts)
+	report := captureOutput(t, &os.Stdout, func() { reportRelayBudget(relayAuthors, assigned, opts, len(selected)) })
+	for _, want := range []string{
+		"Relay budget (--max-relays 1): 1 relays selected",
+		"Below their replica target: 0 follows",
+		"Uncovered within the budget: 2 follows",
+		"✗ e\n",
+		"✗ f\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report lacks %q:\n%s", want, report)
+		}
+	}
+}
+
+func TestGreedySelectTiesDeterministic(t *testing.T) {
+	// Every relay covers two authors of its own: all gains and tie scores are equal
+	relayAuthors := map[string][]string{
+		"wss://r3": {"e", "f"},
+		"wss://r1": {"a", "b"},
+		"wss://r4": {"g", "h"},
+		"wss://r2": {"c", "d"},
+	}
+	opts := selectOptions{replicas: 1, tieBreak: func(string) int { return 0 }}
+	first, firstAssigned := greedySelectAndAssignN(relayAuthors, opts)
+	if want := []string{"wss://r1", "wss://r2", "wss://r3", "wss://r4"}; !reflect.DeepEqual(first, want) {
+		t.Errorf("selected %v, want %v", first, want)
+	}
+	for i := 0; i < 20; i++ {
+		selected, assigned := greedySelectAndAssignN(relayAuthors, opts)
+		if !reflect.DeepEqual(selected, first) || !reflect.DeepEqual(assigned, firstAssigned) {
+			t.Fatalf("run %d selected %v, first run %v", i, selected, first)
+		}
+	}
+}
+
+// backupRelayAuthors runs gen-router with --backup-output on a small data dir
+// and returns, per relay in the backup config, the authors it routes
+func backupRelayAuthors(t *testing.T, flags ...string) map[string][]string {
+	t.Helper()
+	dir := t.TempDir()
+	pk := func(c string) string { return strings.Repeat(c, 64) }
+	// a and c list four relays, b and d two; r1 is the largest
+	pairs := map[string][]string{
+		"a": {"wss://r1.example.com", "wss://r2.example.com", "wss://r3.example.com", "wss://r4.example.com"},
+		"b": {"wss://r1.example.com", "wss://r3.example.com"},
+		"c": {"wss://r2.example.com", "wss://r4.example.com", "wss://r6.example.com", "wss://r7.example.com"},
+		"d": {"wss://r1.example.com", "wss://r5.example.com"},
+	}
+	var follows, lines []string
+	for a, relays := range pairs {
+		follows = append(follows, pk(a))
+		for _, r := range relays {
+			lines = append(lines, pk(a)+" "+r)
+		}
+	}
+	if err := writeLines(filepath.Join(dir, "follows_list.txt"), follows); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeLines(filepath.Join(dir, "pubkey_relays_map.txt"), lines); err != nil {
+		t.Fatal(err)
+	}
+	backup := filepath.Join(dir, "backup.config")
+	args := append([]string{"--data-dir", dir, "--output", filepath.Join(dir, "router.config"), "--backup-output", backup, "--no-header"}, flags...)
+	captureOutput(t, &os.Stdout, func() {
+		captureOutput(t, &os.Stderr, func() { genRouterCmd(context.Background(), args) })
+	})
+
+	data, err := os.ReadFile(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string][]string{}
+	var authors []string
+	for _, l := range strings.Split(string(data), "\n") {
+		for a := range pairs {
+			if strings.Contains(l, pk(a)) {
+				authors = append(authors, a)
+			}
+		}
+		if i := strings.Index(l, "wss://"); i >= 0 {
+			url := strings.Trim(l[i:], `",[] `)
+			out[url] = append(out[url], authors...)
+			authors = nil
+		}
+	}
+	for r := range out {
+		out[r] = uniqueSorted(out[r])
+	}
+	return out
+}
+
+func TestBackupTierUsesSelectOptions(t *testing.T) {
+	// r1 covers three follows, so the primary tier takes it
+	all := backupRelayAuthors(t)
+	if _, ok := all["wss://r1.example.com"]; ok || len(all) < 3 {
+		t.Fatalf("backup relays %v", all)
+	}
+
+	// --max-relays caps the backup tier too
+	if got := backupRelayAuthors(t, "--max-relays", "1"); len(got) != 1 {
+		t.Errorf("--max-relays 1: backup relays %v", got)
+	}
+
+	// --replica-fraction: c has two relays left for the backup after the
+	// primary took two, so with --replicas 2 it gets both, and with fraction
+	// 0.5 one
+	count := func(assigned map[string][]string, author string) int {
+		n := 0
+		for _, authors := range assigned {
+			for _, a := range authors {
+				if a == author {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	if n := count(backupRelayAuthors(t, "--replicas", "2"), "c"); n != 2 {
+		t.Errorf("--replicas 2: c on %d backup relays, want 2", n)
+	}
+	if n := count(backupRelayAuthors(t, "--replicas", "2", "--replica-fraction", "0.5"), "c"); n != 1 {
+		t.Errorf("--replica-fraction 0.5: c on %d backup relays, want 1", n)
+	}
+}
+
+func TestStableChunks(t *testing.T) {
+	authors := make([]string, 200)
+	for i := range authors {
+		authors[i] = fmt.Sprintf("%064x", sha256.Sum256([]byte{byte(i)}))
+	}
+	byLabel := func(buckets []authorBucket) map[string][]string {
+		m := map[string][]string{}
+		for _, b := range buckets {
+			m[b.label] = b.authors
+		}
+		return m
+	}
+	base := byLabel(stableChunks(authors, 32, 0))
+	for label, b := range base {
+		if len(b) > 32 || !strings.HasSuffix(label, "of8") {
+			t.Fatalf("bucket %s holds %d authors, want 8 buckets of <=32", label, len(b))
+		}
+	}
+
+	// Adding or removing one author only changes the bucket it falls in
+	extra := fmt.Sprintf("%064x", sha256.Sum256([]byte("new follow")))
+	for name, changed := range map[string][]string{
+		"added":   append(append([]string(nil), authors...), extra),
+		"removed": authors[1:],
+	} {
+		got := byLabel(stableChunks(changed, 32, 0))
+		diff := 0
+		for label := range got {
+			if !reflect.DeepEqual(got[label], base[label]) {
+				diff++
+			}
+		}
+		if diff != 1 || len(got) != len(base) {
+			t.Errorf("%s one author: %d of %d buckets changed, want 1", name, diff, len(got))
+		}
+	}
+
+	// max_subscriptions caps the bucket count, at a power of two
+	capped := stableChunks(authors, 32, 6)
+	if len(capped) > 4 || largestBucket(capped) <= 32 {
+		t.Errorf("capped to %d buckets of up to %d authors, want at most 4 larger buckets", len(capped), largestBucket(capped))
+	}
+	for _, b := range capped {
+		if !strings.HasSuffix(b.label, "of4") {
+			t.Errorf("capped bucket labeled %s, want <i>of4", b.label)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+)
+
+// Config targets accepted by gen-router --target
+const (
+	targetStrfry = "strfry"
+	targetHaven  = "haven"
+)
+
+// Relay list files haven reads, named by IMPORT_SEED_RELAYS_FILE and
+// BLASTR_RELAYS_FILE in its .env
+const (
+	havenImportFile = "relays_import.json"
+	havenBlastrFile = "relays_blastr.json"
+)
+
+// havenRelayLists maps router streams onto haven's two relay lists. haven
+// pulls from whole relays, so only stream URLs carry over: down streams
+// (follows, catch-all, notifications) become import relays, most routed
+// authors first, and up streams become blastr relays in stream order.
+func havenRelayLists(streams []streamConfig, urlForm string) (imports, blastr []string) {
+	authors := make(map[string]int)
+	var downOrder []string
+	seenUp := make(set)
+	for _, s := range streams {
+		for _, u := range s.URLs {
+			if s.Dir == "up" {
+				if !seenUp.has(u) {
+					seenUp.add(u)
+					blastr = append(blastr, formatRelayURL(u, urlForm))
+				}
+				continue
+			}
+			if _, ok := authors[u]; !ok {
+				downOrder = append(downOrder, u)
+			}
+			authors[u] += len(s.Authors)
+		}
+	}
+	sort.SliceStable(downOrder, func(i, j int) bool {
+		if authors[downOrder[i]] != authors[downOrder[j]] {
+			return authors[downOrder[i]] > authors[downOrder[j]]
+		}
+		return downOrder[i] < downOrder[j]
+	})
+	for _, u := range downOrder {
+		imports = append(imports, formatRelayURL(u, urlForm))
+	}
+	return imports, blastr
+}
+
+// writeHavenConfig writes the import and blastr relay lists as haven's JSON
+// files into dir and returns their paths
+func writeHavenConfig(dir string, imports, blastr []string) ([]string, error) {
+	var paths []string
+	for _, f := range []struct {
+		name   string
+		relays []string
+	}{{havenImportFile, imports}, {havenBlastrFile, blastr}} {
+		if f.relays == nil {
+			f.relays = []string{}
+		}
+		data, err := json.MarshalIndent(f.relays, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, f.name)
+		if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// httpTimeout bounds every HTTP fetch (remote input, bootstrap excludes,
+// NIP-05, NIP-11): connecting, waiting for response headers, and any stall while
+// reading the body. Set with --http-timeout.
+var httpTimeout = 30 * time.Second
+
+// errHTTPTimeout marks fetches that gave up because the server stopped
+// responding, so they can be reported apart from other failures
+var errHTTPTimeout = errors.New("timed out")
+
+// isTimeout reports whether err comes from a deadline or network timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, errHTTPTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// newHTTPClient returns a client whose dial, TLS handshake and response
+// headers are bounded by httpTimeout. The body is not bounded as a whole, so
+// large downloads can stream; httpGet guards it against stalls instead.
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: httpTimeout}).DialContext
+	transport.TLSHandshakeTimeout = httpTimeout
+	transport.ResponseHeaderTimeout = httpTimeout
+	return &http.Client{Transport: transport}
+}
+
+// httpGet starts a GET request bound to ctx, with an Accept header unless
+// accept is empty. The returned body is cancelled when no data arrives for
+// httpTimeout; closing it releases the request.
+func httpGet(ctx context.Context, url, accept string) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		cancel()
+		if isTimeout(err) {
+			return nil, fmt.Errorf("%w after %s: %v", errHTTPTimeout, httpTimeout, err)
+		}
+		return nil, err
+	}
+	resp.Body = newStallReader(resp.Body, httpTimeout, cancel)
+	return resp, nil
+}
+
+// stallReader cancels a request whose body goes quiet for longer than limit
+type stallReader struct {
+	body    io.ReadCloser
+	limit   time.Duration
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	mu      sync.Mutex
+	stalled bool
+}
+
+func newStallReader(body io.ReadCloser, limit time.Duration, cancel context.CancelFunc) *stallReader {
+	r := &stallReader{body: body, limit: limit, cancel: cancel}
+	r.timer = time.AfterFunc(limit, func() {
+		r.mu.Lock()
+		r.stalled = true
+		r.mu.Unlock()
+		cancel()
+	})
+	return r
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.limit)
+	}
+	if err != nil && err != io.EOF {
+		r.mu.Lock()
+		stalled := r.stalled
+		r.mu.Unlock()
+		if stalled {
+			return n, fmt.Errorf("%w: no data for %s", errHTTPTimeout, r.limit)
+		}
+	}
+	return n, err
+}
+
+func (r *stallReader) Close() error {
+	r.timer.Stop()
+	err := r.body.Close()
+	r.cancel()
+	return err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stallServer serves /headers, which never sends its response headers,
+// /body, which sends part of its body and then stops, and /slow, which sends
+// its body in small pieces just within the stall limit
+func stallServer(t *testing.T, limit time.Duration) *httptest.Server {
+	t.Helper()
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wait := func() {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		switch r.URL.Path {
+		case "/headers":
+			wait()
+		case "/body":
+			w.Write([]byte("wss://relay.example.com\n"))
+			w.(http.Flusher).Flush()
+			wait()
+		case "/slow":
+			for i := 0; i < 6; i++ {
+				w.Write([]byte("x"))
+				w.(http.Flusher).Flush()
+				time.Sleep(limit / 3)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	return srv
+}
+
+func withHTTPTimeout(t *testing.T, d time.Duration) {
+	t.Helper()
+	prev := httpTimeout
+	httpTimeout = d
+	t.Cleanup(func() { httpTimeout = prev })
+}
+
+func TestHTTPGetHeaderTimeout(t *testing.T) {
+	withHTTPTimeout(t, 200*time.Millisecond)
+	srv := stallServer(t, httpTimeout)
+
+	start := time.Now()
+	resp, err := httpGet(context.Background(), srv.URL+"/headers", "")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("want a timeout, got a response")
+	}
+	if !errors.Is(err, errHTTPTimeout) {
+		t.Fatalf("err = %v, want errHTTPTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*httpTimeout {
+		t.Errorf("gave up after %s", elapsed)
+	}
+}
+
+func TestHTTPGetStalledBody(t *testing.T) {
+	withHTTPTimeout(t, 200*time.Millisecond)
+	srv := stallServer(t, httpTimeout)
+
+	resp, err := httpGet(context.Background(), srv.URL+"/body", "")
+	if err != nil {
+		t.Fatalf("httpGet: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if !errors.Is(err, errHTTPTimeout) {
+		t.Fatalf("err = %v, want errHTTPTimeout", err)
+	}
+	if !isTimeout(err) {
+		t.Errorf("isTimeout(%v) = false", err)
+	}
+	if string(data) != "wss://relay.example.com\n" {
+		t.Errorf("read %q before the stall", data)
+	}
+}
+
+func TestHTTPGetSlowBodyWithinLimit(t *testing.T) {
+	withHTTPTimeout(t, 300*time.Millisecond)
+	srv := stallServer(t, httpTimeout)
+
+	// The whole body takes longer than httpTimeout, but no gap does
+	resp, err := httpGet(context.Background(), srv.URL+"/slow", "")
+	if err != nil {
+		t.Fatalf("httpGet: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil || string(data) != "xxxxxx" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+}
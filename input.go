@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// isHTTPInput reports whether an input path is an http(s) URL
+func isHTTPInput(path string) bool {
+	p := strings.ToLower(path)
+	return strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://")
+}
+
+// openInput opens a JSONL input from a local file or an http(s) URL, whose
+// download is bound to ctx. The stream is transparently gunzipped when it
+// starts with the gzip magic bytes.
+func openInput(ctx context.Context, path string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	if isHTTPInput(path) {
+		body, err := fetchHTTPInput(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		rc = body
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		rc = f
+	}
+
+	br := bufio.NewReader(rc)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		return &readCloser{Reader: gz, closers: []io.Closer{gz, rc}}, nil
+	}
+	return &readCloser{Reader: br, closers: []io.Closer{rc}}, nil
+}
+
+// fetchHTTPInput starts a streaming download bound to ctx and --http-timeout,
+// and rejects responses that are errors or clearly not JSONL (e.g. an HTML
+// error page)
+func fetchHTTPInput(ctx context.Context, url string) (io.ReadCloser, error) {
+	resp, err := httpGet(ctx, url, "")
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download: %s returned %s", url, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		mt, _, _ := mime.ParseMediaType(ct)
+		if mt == "text/html" {
+			resp.Body.Close()
+			return nil, fmt.Errorf("download: %s returned %s, expected JSONL", url, mt)
+		}
+	}
+	return resp.Body, nil
+}
+
+// readCloser pairs a reader with the closers of the streams underneath it
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *readCloser) Close() error {
+	var first error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	nostr "github.com/nbd-wtf/go-nostr"
+)
+
+// Liveness results for one (author, relay) pair
+const (
+	livenessFound       = "found"
+	livenessMissing     = "missing"
+	livenessUnknown     = "unknown" // no EOSE before the timeout
+	livenessUnreachable = "unreachable"
+)
+
+// livenessResult records whether a relay had a recent note of an author
+type livenessResult struct {
+	pubkey string
+	relay  string
+	status string
+}
+
+// writeRelaysByAuthor returns the write relays of each author's newest
+// relay list in a JSONL file (unmarked r-tags count as write)
+func writeRelaysByAuthor(path string) map[string][]string {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil
+	}
+	var events []Event
+	for _, line := range lines {
+		ev, err := parseEventLine([]byte(line))
+		if err != nil || ev.Kind != 10002 {
+			continue
+		}
+		events = append(events, ev)
+	}
+	out := make(map[string][]string)
+	for pk, ev := range latestEventsByPubkey(events) {
+		for _, tag := range ev.Tags {
+			if len(tag) < 2 || tag[0] != "r" || (len(tag) >= 3 && strings.ToLower(tag[2]) == "read") {
+				continue
+			}
+			if url := normalizeURL(tag[1]); isValidRelayURL(url) {
+				out[pk] = append(out[pk], url)
+			}
+		}
+	}
+	return out
+}
+
+// sampleAuthors picks up to n authors with at least one relay, at random
+func sampleAuthors(relaysByAuthor map[string][]string, n int) []string {
+	var authors []string
+	for pk, relays := range relaysByAuthor {
+		if len(relays) > 0 {
+			authors = append(authors, pk)
+		}
+	}
+	sort.Strings(authors)
+	rand.Shuffle(len(authors), func(i, j int) { authors[i], authors[j] = authors[j], authors[i] })
+	if len(authors) > n {
+		authors = authors[:n]
+	}
+	sort.Strings(authors)
+	return authors
+}
+
+// checkLiveness asks each relay whether it has a kind 1 note from the last
+// windowDays for each sampled author assigned to it. Every author gets its own
+// filter with limit 1, so prolific authors can't crowd out the others. A REQ
+// holds at most the relay's max_filters filters (see relayInfoCache.maxFilters);
+// authors whose REQ saw no EOSE within timeout are unknown.
+func checkLiveness(ctx context.Context, relaysByAuthor map[string][]string, authors []string, windowDays, parallel int, timeout time.Duration, info *relayInfoCache) []livenessResult {
+	byRelay := make(map[string][]string)
+	for _, pk := range authors {
+		for _, r := range relaysByAuthor[pk] {
+			byRelay[r] = append(byRelay[r], pk)
+		}
+	}
+	since := nostr.Timestamp(time.Now().AddDate(0, 0, -windowDays).Unix())
+
+	var results []livenessResult
+	var mu sync.Mutex
+	if parallel < 1 {
+		parallel = 1
+	}
+	semaphore := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for relayURL, pks := range byRelay {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(url string, pks []string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			status := make(map[string]string, len(pks))
+			for _, pk := range pks {
+				status[pk] = livenessUnreachable
+			}
+			defer func() {
+				mu.Lock()
+				for pk, st := range status {
+					results = append(results, livenessResult{pubkey: pk, relay: url, status: st})
+				}
+				mu.Unlock()
+			}()
+
+			connectCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			relay, err := nostr.RelayConnect(connectCtx, url)
+			if err != nil {
+				return
+			}
+			defer relay.Close()
+			for _, pk := range pks {
+				status[pk] = livenessUnknown
+			}
+
+			for _, chunk := range chunkAuthors(pks, info.maxFilters(url)) {
+				if ctx.Err() != nil {
+					return
+				}
+				checkLivenessChunk(ctx, relay, chunk, since, timeout, status)
+			}
+		}(relayURL, pks)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].pubkey != results[j].pubkey {
+			return results[i].pubkey < results[j].pubkey
+		}
+		return results[i].relay < results[j].relay
+	})
+	return results
+}
+
+// checkLivenessChunk sends one REQ for pks and marks each found or, once
+// the relay sent EOSE, missing. Without EOSE within timeout they stay unknown.
+func checkLivenessChunk(ctx context.Context, relay *nostr.Relay, pks []string, since nostr.Timestamp, timeout time.Duration, status map[string]string) {
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	filters := make(nostr.Filters, 0, len(pks))
+	for _, pk := range pks {
+		filters = append(filters, nostr.Filter{Kinds: []int{1}, Authors: []string{pk}, Since: &since, Limit: 1})
+	}
+	sub, err := relay.Subscribe(reqCtx, filters)
+	if err != nil {
+		return
+	}
+	defer sub.Unsub()
+	found := set{}
+	for {
+		select {
+		case <-reqCtx.Done():
+			for pk := range found {
+				status[pk] = livenessFound
+			}
+			return
+		case <-sub.EndOfStoredEvents:
+			for _, pk := range pks {
+				if found.has(pk) {
+					status[pk] = livenessFound
+				} else {
+					status[pk] = livenessMissing
+				}
+			}
+			return
+		case ev := <-sub.Events:
+			if ev == nil {
+				continue
+			}
+			if pk := strings.ToLower(ev.PubKey); status[pk] == livenessUnknown {
+				found.add(pk)
+			}
+		}
+	}
+}
+
+// writeLivenessSample saves relay_liveness_sample.txt as "pubkey | relay | status" lines
+func writeLivenessSample(path string, windowDays int, source string, results []livenessResult) error {
+	lines := []string{
+		fmt.Sprintf("# Recent kind 1 notes (last %d days) of sampled follows on their relays from %s", windowDays, source),
+		"# Format: pubkey | relay | found, missing, unknown (no EOSE in time) or unreachable",
+		"",
+	}
+	for _, r := range results {
+		lines = append(lines, fmt.Sprintf("%s | %s | %s", r.pubkey, r.relay, r.status))
+	}
+	return writeLines(path, lines)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	nostr "github.com/nbd-wtf/go-nostr"
+)
+
+func TestCheckLivenessChunksFilters(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	note := signedEvent(t, sk, 1, time.Now().Unix(), nil, "still here")
+	url, reqs := fakeRelay(t, note)
+
+	authors := []string{pk}
+	for i := 1; i < 12; i++ {
+		authors = append(authors, fmt.Sprintf("%064x", i))
+	}
+	relaysByAuthor := map[string][]string{}
+	for _, a := range authors {
+		relaysByAuthor[a] = []string{url}
+	}
+	results := checkLiveness(context.Background(), relaysByAuthor, authors, 30, 1, 5*time.Second, nil)
+
+	// 12 authors at the default max_filters of 10 take two REQs
+	if n := reqs(); n != 2 {
+		t.Errorf("sent %d REQs, want 2", n)
+	}
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.status]++
+		if r.pubkey == pk && r.status != livenessFound {
+			t.Errorf("author with a recent note is %s", r.status)
+		}
+	}
+	if counts[livenessFound] != 1 || counts[livenessMissing] != 11 {
+		t.Errorf("statuses %v, want 1 found and 11 missing", counts)
+	}
+}
@@ -1,24 +1,45 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
 	if len(os.Args) < 2 {
 		usage()
 		os.Exit(1)
 	}
+	// Ctrl-C or SIGTERM cancels relay queries and HTTP fetches in flight;
+	// a second one kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	sub := os.Args[1]
 	switch sub {
 	case "analyze":
-		analyzeCmd(os.Args[2:])
+		analyzeCmd(ctx, os.Args[2:])
 	case "gen-router":
-		genRouterCmd(os.Args[2:])
+		genRouterCmd(ctx, os.Args[2:])
 	case "collect":
-		collectCmd(os.Args[2:])
+		collectCmd(ctx, os.Args[2:])
+	case "build":
+		buildCmd(ctx, os.Args[2:])
+	case "probe":
+		probeCmd(ctx, os.Args[2:])
+	case "explain":
+		explainCmd(ctx, os.Args[2:])
 	case "help", "-h", "--help":
 		usage()
 	default:
@@ -34,9 +55,22 @@ func usage() {
 	fmt.Println("  collect     Fetch follows (kind 3) and relay lists (kind 10002) into data dir")
 	fmt.Println("  analyze     Parse 10002 JSONL, build maps, apply excludes, compute optimal and outbox sets")
 	fmt.Println("  gen-router  Generate strfry router config from analysis outputs")
+	fmt.Println("  build       Run collect, analyze and gen-router in one go")
+	fmt.Println("  probe       Measure relay connect time, time to first event, EOSE and throughput")
+	fmt.Println("  explain     Explain why a pubkey is (not) covered by the router config")
 	fmt.Println("\nUse '<subcommand> -h' for flags.")
 }
 
+// exitIfInterrupted exits non-zero once Ctrl-C or SIGTERM cancelled ctx, so
+// partial results never overwrite the outputs of a complete run.
+func exitIfInterrupted(ctx context.Context) {
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "interrupted")
+		os.Exit(130)
+	}
+}
+
 func commonFlags(fs *flag.FlagSet) (dataDir *string) {
+	fs.DurationVar(&httpTimeout, "http-timeout", httpTimeout, "give up on HTTP fetches (remote input, bootstrap excludes, NIP-05, NIP-11) that stall this long")
 	return fs.String("data-dir", "./relay_data", "path to data directory (inputs/outputs)")
 }
@@ -0,0 +1,45 @@
+package main
+
+import "fmt"
+
+// mirrorStep is one relay of the self-hosting advice with the follows it
+// adds and the running total
+type mirrorStep struct {
+	relay      string
+	added      int
+	cumulative int
+}
+
+// mirrorAdvice runs the greedy max-coverage over the follows' write relays
+// and returns up to n relays in the order they add the most uncovered follows
+func mirrorAdvice(writeMap map[string]set, follows set, n int) []mirrorStep {
+	relayAuthors := make(map[string][]string)
+	for url, authors := range writeMap {
+		for pk := range authors {
+			if follows.has(pk) {
+				relayAuthors[url] = append(relayAuthors[url], pk)
+			}
+		}
+	}
+	selected, assigned := greedySelectAndAssignN(relayAuthors, selectOptions{replicas: 1, maxRelays: n})
+	steps := make([]mirrorStep, 0, len(selected))
+	total := 0
+	for _, relay := range selected {
+		total += len(assigned[relay])
+		steps = append(steps, mirrorStep{relay: relay, added: len(assigned[relay]), cumulative: total})
+	}
+	return steps
+}
+
+// writeMirrorAdvice saves mirror_advice.txt
+func writeMirrorAdvice(path string, steps []mirrorStep, follows int) error {
+	lines := []string{
+		"# If you mirror the first N relays, you cover this share of your follows",
+		"# Format: rank | relay | follows added | cumulative follows | cumulative percent",
+		"",
+	}
+	for i, s := range steps {
+		lines = append(lines, fmt.Sprintf("%d | %s | %d | %d | %.1f%%", i+1, s.relay, s.added, s.cumulative, percent(s.cumulative, follows)))
+	}
+	return writeLines(path, lines)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	nostr "github.com/nbd-wtf/go-nostr"
+)
+
+// muteList is the public part of a user's kind 10000 mute list
+type muteList struct {
+	pubkeys   []string
+	invalid   int  // p tags that are neither hex pubkeys nor npubs
+	encrypted bool // the content holds private (encrypted) entries, which are not read
+}
+
+// fetchMuteList fetches the newest kind 10000 of pubkey and returns its public
+// p-tag pubkeys. Private entries in the encrypted content are out of scope.
+func fetchMuteList(ctx context.Context, relayURL, pubkey string, timeout time.Duration) (*muteList, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	relay, err := nostr.RelayConnect(ctx, relayURL)
+	if err != nil {
+		return nil, fmt.Errorf("relay connect: %w", err)
+	}
+	defer relay.Close()
+
+	filters := nostr.Filters{nostr.Filter{Kinds: []int{10000}, Authors: []string{strings.ToLower(pubkey)}}}
+	subscription, err := relay.Subscribe(ctx, filters)
+	if err != nil {
+		return nil, fmt.Errorf("subscribe: %w", err)
+	}
+	defer subscription.Unsub()
+
+	var newest *nostr.Event
+	for {
+		select {
+		case <-ctx.Done():
+			return parseMuteList(newest), nil
+		case <-subscription.EndOfStoredEvents:
+			return parseMuteList(newest), nil
+		case event := <-subscription.Events:
+			if event == nil || event.Kind != 10000 {
+				continue
+			}
+			if newest == nil || event.CreatedAt > newest.CreatedAt {
+				newest = event
+			}
+		}
+	}
+}
+
+// parseMuteList extracts the muted pubkeys of a kind 10000 (nil if none)
+func parseMuteList(ev *nostr.Event) *muteList {
+	m := &muteList{}
+	if ev == nil {
+		return m
+	}
+	m.encrypted = strings.TrimSpace(ev.Content) != ""
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 || tag[0] != "p" {
+			continue
+		}
+		pk, ok := pTagPubkey(tag[1])
+		if !ok {
+			m.invalid++
+			continue
+		}
+		m.pubkeys = append(m.pubkeys, pk)
+	}
+	m.pubkeys = deduplicateAndSort(m.pubkeys)
+	return m
+}
+
+// excludeMuted removes muted authors from the write map and primary relays,
+// and drops relays left without authors. It returns the muted authors that
+// were in the map and the relays dropped.
+func excludeMuted(writeMap map[string]set, primary map[string]string, muted set) (removed, emptied []string) {
+	found := set{}
+	for url, authors := range writeMap {
+		for pk := range authors {
+			if muted.has(pk) {
+				found.add(pk)
+				delete(authors, pk)
+			}
+		}
+		if len(authors) == 0 {
+			emptied = append(emptied, url)
+			delete(writeMap, url)
+		}
+	}
+	for pk := range found {
+		delete(primary, pk)
+	}
+	return setSorted(found), uniqueSorted(emptied)
+}
+
+// loadMuteList reads mute_list.txt, skipping entries that are not hex pubkeys
+func loadMuteList(path string) (set, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	muted := set{}
+	for _, l := range lines {
+		pk := strings.ToLower(strings.TrimSpace(l))
+		if isHex64(pk) {
+			muted.add(pk)
+		} else if pk != "" && !strings.HasPrefix(pk, "#") {
+			fmt.Fprintf(os.Stderr, "warning: %s: skipping invalid pubkey %q\n", path, l)
+		}
+	}
+	return muted, nil
+}
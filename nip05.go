@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip05"
+)
+
+// resolveNIP05 looks up name@domain via https://domain/.well-known/nostr.json
+// and returns the hex pubkey and any relays the domain advertises for it
+func resolveNIP05(ctx context.Context, identifier string, timeout time.Duration) (string, []string, error) {
+	if !nip05.IsValidIdentifier(identifier) {
+		return "", nil, fmt.Errorf("%q is not a NIP-05 identifier (want name@domain)", identifier)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resp, name, err := nip05.Fetch(ctx, identifier)
+	if err != nil {
+		if isTimeout(err) {
+			return "", nil, fmt.Errorf("timeout (no response within %s): %w", timeout, err)
+		}
+		return "", nil, err
+	}
+	raw, ok := resp.Names[name]
+	if !ok {
+		return "", nil, fmt.Errorf("nostr.json has no entry for %q", name)
+	}
+	pubkey, err := parsePubkey(raw)
+	if err != nil {
+		return "", nil, fmt.Errorf("nostr.json entry for %q: %w", name, err)
+	}
+	// Relays are keyed by pubkey; some servers use a different case
+	relays := resp.Relays[pubkey]
+	if relays == nil {
+		relays = resp.Relays[raw]
+	}
+	out := make([]string, 0, len(relays))
+	for _, r := range relays {
+		out = append(out, normalizeURL(r))
+	}
+	return pubkey, out, nil
+}
+
+// containsRelay reports whether relays holds url after normalization
+func containsRelay(relays []string, url string) bool {
+	url = normalizeURL(url)
+	for _, r := range relays {
+		if normalizeURL(r) == url {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+// buildOutboxHints maps each author to their write relays, most useful first:
+// the primary (first-listed) relay, then relays shared by more authors, then
+// by URL. With follows set, only those authors are included.
+func buildOutboxHints(writeMap map[string]set, primary map[string]string, follows map[string]struct{}) map[string][]string {
+	hints := make(map[string][]string)
+	for url, authors := range writeMap {
+		for pk := range authors {
+			if follows != nil {
+				if _, ok := follows[pk]; !ok {
+					continue
+				}
+			}
+			hints[pk] = append(hints[pk], url)
+		}
+	}
+	for pk, relays := range hints {
+		first := primary[pk]
+		sort.Slice(relays, func(i, j int) bool {
+			a, b := relays[i], relays[j]
+			if (a == first) != (b == first) {
+				return a == first
+			}
+			if na, nb := len(writeMap[a]), len(writeMap[b]); na != nb {
+				return na > nb
+			}
+			return a < b
+		})
+	}
+	return hints
+}
+
+// writeOutboxHints saves the hints as a JSON object of pubkey -> relay URLs
+func writeOutboxHints(path string, hints map[string][]string) error {
+	data, err := json.MarshalIndent(hints, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
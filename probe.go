@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	nostr "github.com/nbd-wtf/go-nostr"
+)
+
+// probeResult holds connection metrics for one relay
+type probeResult struct {
+	URL        string
+	Reachable  bool
+	Err        string
+	Connect    time.Duration // time to open the websocket
+	FirstEvent time.Duration // time from REQ to first event (0 if none)
+	EOSE       time.Duration // time from REQ to EOSE (0 if none before timeout)
+	Events     int           // events received for the sample REQ
+}
+
+// eventsPerSecond returns the sample throughput between REQ and EOSE (or the last event)
+func (r probeResult) eventsPerSecond(timeout time.Duration) float64 {
+	window := r.EOSE
+	if window == 0 {
+		window = timeout
+	}
+	if r.Events == 0 || window <= 0 {
+		return 0
+	}
+	return float64(r.Events) / window.Seconds()
+}
+
+func probeCmd(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	dataDir := commonFlags(fs)
+	relaysCSV := fs.String("relays", "", "comma-separated relay URLs to probe (default: data-dir/outbox_relays.txt)")
+	parallel := fs.Int("parallel", 8, "number of relays to probe in parallel")
+	timeoutSec := fs.Int("timeout", 10, "seconds to wait per relay (connect and sample REQ each)")
+	inferSchemeFlag := fs.Bool("infer-scheme", false, "accept relays without a scheme in --relays and assume wss://")
+	sample := fs.Int("sample", 200, "limit for the sample REQ (kind 1 notes) used to measure throughput")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse flags: %v\n", err)
+		os.Exit(1)
+	}
+
+	dd := *dataDir
+	var relays []string
+	if *relaysCSV != "" {
+		relays = parseRelayList(*relaysCSV, *inferSchemeFlag)
+	} else {
+		relays = readLinesMust(filepath.Join(dd, "outbox_relays.txt"))
+	}
+	if len(relays) == 0 {
+		fmt.Fprintln(os.Stderr, "no relays to probe")
+		os.Exit(1)
+	}
+
+	timeout := time.Duration(*timeoutSec) * time.Second
+	fmt.Printf("Probing %d relays (parallel %d, timeout %s)...\n", len(relays), *parallel, timeout)
+	results := probeRelays(ctx, relays, *parallel, timeout, *sample)
+	// Cancelled probes would mark every relay unreachable
+	exitIfInterrupted(ctx)
+
+	reportPath := filepath.Join(dd, "relay_latency.txt")
+	if err := writeLatencyReport(reportPath, results, timeout); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing %s: %v\n", reportPath, err)
+		os.Exit(1)
+	}
+	reachable := 0
+	for _, r := range results {
+		if r.Reachable {
+			reachable++
+		}
+	}
+	fmt.Println("Probe complete.")
+	fmt.Printf(" - Reachable: %d/%d\n", reachable, len(results))
+	fmt.Printf(" - Latency report: %s\n", reportPath)
+}
+
+// probeRelays probes relays with bounded parallelism and returns results sorted by URL
+func probeRelays(ctx context.Context, relays []string, parallel int, timeout time.Duration, sample int) []probeResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+	results := make([]probeResult, len(relays))
+	semaphore := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, url := range relays {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			results[i] = probeRelay(ctx, normalizeURL(url), timeout, sample)
+		}(i, url)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].URL < results[j].URL })
+	return results
+}
+
+// connectRelays only opens and closes a connection to each relay, with bounded
+// parallelism, and returns the connect error per unreachable relay
+func connectRelays(ctx context.Context, relays []string, parallel int, timeout time.Duration) map[string]error {
+	if parallel < 1 {
+		parallel = 1
+	}
+	failed := make(map[string]error)
+	var mu sync.Mutex
+	semaphore := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for _, url := range relays {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			relay, err := nostr.RelayConnect(ctx, url)
+			if err != nil {
+				mu.Lock()
+				failed[url] = err
+				mu.Unlock()
+				return
+			}
+			relay.Close()
+		}(url)
+	}
+	wg.Wait()
+	return failed
+}
+
+// probeRelay connects to a relay and times a sample REQ for recent notes
+func probeRelay(ctx context.Context, url string, timeout time.Duration, sample int) probeResult {
+	res := probeResult{URL: url}
+
+	connectCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	relay, err := nostr.RelayConnect(connectCtx, url)
+	if err != nil {
+		res.Err = err.Error()
+		return res
+	}
+	defer relay.Close()
+	res.Connect = time.Since(start)
+	res.Reachable = true
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, timeout)
+	defer reqCancel()
+	filters := nostr.Filters{nostr.Filter{Kinds: []int{1}, Limit: sample}}
+	reqStart := time.Now()
+	sub, err := relay.Subscribe(reqCtx, filters)
+	if err != nil {
+		res.Err = fmt.Sprintf("subscribe: %v", err)
+		return res
+	}
+	defer sub.Unsub()
+
+	for {
+		select {
+		case <-reqCtx.Done():
+			return res
+		case <-sub.EndOfStoredEvents:
+			res.EOSE = time.Since(reqStart)
+			return res
+		case ev := <-sub.Events:
+			if ev == nil {
+				continue
+			}
+			if res.Events == 0 {
+				res.FirstEvent = time.Since(reqStart)
+			}
+			res.Events++
+		}
+	}
+}
+
+// writeLatencyReport writes probe results to relay_latency.txt
+func writeLatencyReport(path string, results []probeResult, timeout time.Duration) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	fmt.Fprintln(w, "# Relay probe report")
+	fmt.Fprintln(w, "# Format: URL | Status | Connect | First-Event | EOSE | Events | Events/s")
+	fmt.Fprintln(w, "")
+	for _, r := range results {
+		status := "reachable"
+		if !r.Reachable {
+			status = "unreachable"
+		}
+		fmt.Fprintf(w, "%s | %s | %dms | %dms | %dms | %d | %.1f\n",
+			r.URL,
+			status,
+			r.Connect.Milliseconds(),
+			r.FirstEvent.Milliseconds(),
+			r.EOSE.Milliseconds(),
+			r.Events,
+			r.eventsPerSecond(timeout))
+	}
+	return w.Flush()
+}
+
+// loadLatencyReport reads relay_latency.txt into relay URL -> reachable
+func loadLatencyReport(path string) (map[string]bool, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]bool)
+	for _, l := range lines {
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
+		fields := strings.Split(l, "|")
+		if len(fields) < 2 {
+			continue
+		}
+		out[normalizeURL(fields[0])] = strings.TrimSpace(fields[1]) == "reachable"
+	}
+	return out, nil
+}
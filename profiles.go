@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	nostr "github.com/nbd-wtf/go-nostr"
+)
+
+// maxProfileNameLen caps names written to pubkey_names.txt and config comments
+const maxProfileNameLen = 40
+
+// fetchProfileNames asks each relay for follows' kind 0 profiles and returns
+// the display name (or name) of the newest profile seen per author
+func fetchProfileNames(ctx context.Context, relays []string, batches [][]string, parallel int, timeout time.Duration) map[string]string {
+	type profile struct {
+		createdAt nostr.Timestamp
+		name      string
+	}
+	newest := make(map[string]profile)
+	fetchAuthorEvents(ctx, "Profiles", relays, batches, 0, parallel, timeout, func(ev *nostr.Event) {
+		name := profileName(ev.Content)
+		if name == "" {
+			return
+		}
+		pk := strings.ToLower(ev.PubKey)
+		if cur, ok := newest[pk]; !ok || ev.CreatedAt > cur.createdAt {
+			newest[pk] = profile{createdAt: ev.CreatedAt, name: name}
+		}
+	})
+
+	names := make(map[string]string, len(newest))
+	for pk, p := range newest {
+		names[pk] = p.name
+	}
+	return names
+}
+
+// profileName extracts display_name, falling back to name, from kind 0 content.
+// The result is a single trimmed line of at most maxProfileNameLen runes.
+func profileName(content string) string {
+	var meta struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+	}
+	if err := json.Unmarshal([]byte(content), &meta); err != nil {
+		return ""
+	}
+	name := meta.DisplayName
+	if strings.TrimSpace(name) == "" {
+		name = meta.Name
+	}
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}), " ")
+	if runes := []rune(name); len(runes) > maxProfileNameLen {
+		name = string(runes[:maxProfileNameLen-1]) + "…"
+	}
+	return name
+}
+
+// writePubkeyNames saves pubkey_names.txt as "pubkey name" lines
+func writePubkeyNames(path string, names map[string]string) error {
+	lines := make([]string, 0, len(names))
+	for _, pk := range sortedKeys(names) {
+		lines = append(lines, pk+" "+names[pk])
+	}
+	return writeLines(path, lines)
+}
+
+// loadPubkeyNames reads pubkey_names.txt; names may contain spaces
+func loadPubkeyNames(path string) (map[string]string, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	for _, l := range lines {
+		pk, name, ok := strings.Cut(strings.TrimSpace(l), " ")
+		if !ok || strings.HasPrefix(pk, "#") {
+			continue
+		}
+		if name = strings.TrimSpace(name); name != "" {
+			names[strings.ToLower(pk)] = name
+		}
+	}
+	return names, nil
+}
+
+// annotateStreamNames sets a comment naming one known follow in each stream
+// with authors, so operators can tell which stream covers whom
+func annotateStreamNames(streams []streamConfig, names map[string]string) int {
+	annotated := 0
+	for i := range streams {
+		s := &streams[i]
+		for _, a := range s.Authors {
+			name, ok := names[a]
+			if !ok {
+				continue
+			}
+			s.Comment = name
+			if more := len(s.Authors) - 1; more > 0 {
+				s.Comment = fmt.Sprintf("%s (+%d more)", name, more)
+			}
+			annotated++
+			break
+		}
+	}
+	return annotated
+}
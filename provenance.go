@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sourceLog records which relays delivered each event during collect, for
+// the seen_on field of the JSONL
+type sourceLog struct {
+	mu   sync.Mutex
+	byID map[string]set
+}
+
+func newSourceLog() *sourceLog {
+	return &sourceLog{byID: make(map[string]set)}
+}
+
+// record notes that relay delivered event id and reports whether the id is
+// new to this run
+func (l *sourceLog) record(id, relay string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	relays, ok := l.byID[id]
+	if !ok {
+		relays = set{}
+		l.byID[id] = relays
+	}
+	relays.add(relay)
+	return !ok
+}
+
+// relays returns the relays that delivered id, sorted
+func (l *sourceLog) relays(id string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return setSorted(l.byID[id])
+}
+
+// withSeenOn adds or replaces the seen_on field of a JSON event line
+func withSeenOn(line string, relays []string) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return "", err
+	}
+	seen, err := json.Marshal(relays)
+	if err != nil {
+		return "", err
+	}
+	fields["seen_on"] = seen
+	out, err := json.Marshal(fields)
+	return string(out), err
+}
+
+// sourceEnvelope is a JSONL line written by collect --annotate-source: the
+// event wrapped with the relay it was first received from. collect adds
+// seen_on when more relays delivered it.
+type sourceEnvelope struct {
+	Relay  string          `json:"relay"`
+	SeenOn []string        `json:"seen_on,omitempty"`
+	Event  json.RawMessage `json:"event"`
+}
+
+// envelopeLine wraps a JSON event line in a sourceEnvelope
+func envelopeLine(relay, line string) (string, error) {
+	out, err := json.Marshal(sourceEnvelope{Relay: relay, Event: json.RawMessage(line)})
+	return string(out), err
+}
+
+// parseEventLine decodes a JSONL line holding either a plain event or a
+// sourceEnvelope. An envelope's relays are merged into the event's SeenOn.
+func parseEventLine(line []byte) (Event, error) {
+	var ev Event
+	// Plain events never have an "event" key, so most lines skip the envelope
+	if bytes.Contains(line, []byte(`"event"`)) {
+		var env sourceEnvelope
+		if err := json.Unmarshal(line, &env); err == nil && len(env.Event) > 0 {
+			if err := json.Unmarshal(env.Event, &ev); err != nil {
+				return ev, err
+			}
+			seenOn := append(ev.SeenOn, env.SeenOn...)
+			if env.Relay != "" {
+				seenOn = append(seenOn, env.Relay)
+			}
+			ev.SeenOn = uniqueSorted(seenOn)
+			return ev, nil
+		}
+	}
+	err := json.Unmarshal(line, &ev)
+	return ev, err
+}
+
+// relayListVersion is one kind 10002 of an author as analyze reads it: the
+// write relays it lists, in order, and the relays it was collected from
+type relayListVersion struct {
+	ID        string   `json:"id"`
+	CreatedAt int64    `json:"created_at"`
+	URLs      []string `json:"urls"`
+	ReadURLs  []string `json:"read_urls,omitempty"`
+	SeenOn    []string `json:"seen_on,omitempty"`
+}
+
+func (v relayListVersion) newer(old relayListVersion) bool {
+	return seenVersion{createdAt: v.CreatedAt, id: v.ID}.newer(seenVersion{createdAt: old.CreatedAt, id: old.ID})
+}
+
+// sameRelays reports whether two versions list the same write relays, in any order
+func (v relayListVersion) sameRelays(o relayListVersion) bool {
+	if len(v.URLs) != len(o.URLs) {
+		return false
+	}
+	a := append([]string(nil), v.URLs...)
+	b := append([]string(nil), o.URLs...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// addRelayListVersion keeps the newest version per author in lists and the
+// others in older. The result does not depend on the order versions are
+// added in.
+func addRelayListVersion(lists map[string]relayListVersion, older map[string][]relayListVersion, pk string, v relayListVersion) {
+	cur, ok := lists[pk]
+	switch {
+	case !ok:
+		lists[pk] = v
+	case cur.ID == v.ID:
+		cur.SeenOn = uniqueSorted(append(cur.SeenOn, v.SeenOn...))
+		lists[pk] = cur
+	case v.newer(cur):
+		older[pk] = appendVersion(older[pk], cur)
+		lists[pk] = v
+	default:
+		older[pk] = appendVersion(older[pk], v)
+	}
+}
+
+// relayListConflicts returns every version of the authors whose versions do
+// not all list the same write relays
+func relayListConflicts(lists map[string]relayListVersion, older map[string][]relayListVersion) map[string][]relayListVersion {
+	conflicts := map[string][]relayListVersion{}
+	for pk, vs := range older {
+		cur := lists[pk]
+		for _, v := range vs {
+			if !cur.sameRelays(v) {
+				conflicts[pk] = append([]relayListVersion{cur}, vs...)
+				break
+			}
+		}
+	}
+	return conflicts
+}
+
+// appendVersion adds v unless a version with its ID is already present, in
+// which case their sources are merged
+func appendVersion(versions []relayListVersion, v relayListVersion) []relayListVersion {
+	for i := range versions {
+		if versions[i].ID == v.ID {
+			versions[i].SeenOn = uniqueSorted(append(versions[i].SeenOn, v.SeenOn...))
+			return versions
+		}
+	}
+	return append(versions, v)
+}
+
+// writeRelayListConflicts saves relay_list_conflicts.txt: for each author
+// whose 10002 differed across source relays, one line per version, newest
+// (the one used) first
+func writeRelayListConflicts(path string, conflicts map[string][]relayListVersion) error {
+	lines := []string{"# pubkey | created_at | id | seen_on | write relays (first line per author is the version used)"}
+	pks := make([]string, 0, len(conflicts))
+	for pk := range conflicts {
+		pks = append(pks, pk)
+	}
+	sort.Strings(pks)
+	for _, pk := range pks {
+		versions := conflicts[pk]
+		sort.Slice(versions, func(i, j int) bool { return versions[i].newer(versions[j]) })
+		for _, v := range versions {
+			seenOn := "unknown"
+			if len(v.SeenOn) > 0 {
+				seenOn = strings.Join(v.SeenOn, ",")
+			}
+			lines = append(lines, fmt.Sprintf("%s | %s | %s | %s | %s", pk,
+				time.Unix(v.CreatedAt, 0).UTC().Format(time.RFC3339), v.ID, seenOn, strings.Join(v.URLs, ",")))
+		}
+	}
+	return writeLines(path, lines)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const testEventLine = `{"kind":10002,"id":"e1","pubkey":"p1","created_at":5,"tags":[["r","wss://relay.example.com"]],"content":"","sig":"s"}`
+
+func TestParseEventLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		content string
+		seenOn  []string
+	}{
+		{"plain", testEventLine, "", nil},
+		{"plain with seen_on", `{"kind":10002,"id":"e1","pubkey":"p1","created_at":5,"tags":[["r","wss://relay.example.com"]],"seen_on":["wss://b","wss://a"]}`, "", []string{"wss://b", "wss://a"}},
+		{"envelope", `{"relay":"wss://a","event":` + testEventLine + `}`, "", []string{"wss://a"}},
+		{
+			"envelope with seen_on",
+			`{"relay":"wss://b","seen_on":["wss://c","wss://a"],"event":{"kind":10002,"id":"e1","pubkey":"p1","created_at":5,"tags":[["r","wss://relay.example.com"]],"seen_on":["wss://a","wss://d"]}}`,
+			"", []string{"wss://a", "wss://b", "wss://c", "wss://d"},
+		},
+		// "event" inside the event itself must not be taken for an envelope
+		{"event in content", `{"kind":10002,"id":"e1","pubkey":"p1","created_at":5,"tags":[["r","wss://relay.example.com"],["t","event"]],"content":"an \"event\" here"}`, `an "event" here`, nil},
+		{"event as tag value", `{"kind":10002,"id":"e1","pubkey":"p1","created_at":5,"tags":[["r","wss://relay.example.com"],["event","x"]],"content":"{\"event\":{}}"}`, `{"event":{}}`, nil},
+	}
+	for _, tt := range tests {
+		ev, err := parseEventLine([]byte(tt.line))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if ev.Kind != 10002 || ev.ID != "e1" || ev.PubKey != "p1" || ev.CreatedAt != 5 || len(ev.Tags) == 0 || ev.Tags[0][1] != "wss://relay.example.com" {
+			t.Errorf("%s: decoded %+v", tt.name, ev)
+		}
+		if ev.Content != tt.content {
+			t.Errorf("%s: content %q, want %q", tt.name, ev.Content, tt.content)
+		}
+		if !reflect.DeepEqual(ev.SeenOn, tt.seenOn) {
+			t.Errorf("%s: seen_on %v, want %v", tt.name, ev.SeenOn, tt.seenOn)
+		}
+	}
+}
+
+func TestParseEventLineInvalid(t *testing.T) {
+	for _, line := range []string{
+		`{not json`,
+		`{"relay":"wss://a","event":{"kind":"ten"}}`,
+	} {
+		if _, err := parseEventLine([]byte(line)); err == nil {
+			t.Errorf("%s: no error", line)
+		}
+	}
+}
+
+func TestEnvelopeLineRoundTrip(t *testing.T) {
+	line, err := envelopeLine("wss://a", testEventLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, err := parseEventLine([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.ID != "e1" || !reflect.DeepEqual(ev.SeenOn, []string{"wss://a"}) {
+		t.Errorf("envelope %s decoded to %+v", line, ev)
+	}
+
+	line, err = withSeenOn(testEventLine, []string{"wss://a", "wss://b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev, err = parseEventLine([]byte(line)); err != nil || ev.ID != "e1" || !reflect.DeepEqual(ev.SeenOn, []string{"wss://a", "wss://b"}) {
+		t.Errorf("withSeenOn line %s decoded to %+v, %v", line, ev, err)
+	}
+}
+
+// addRelayListVersion is the one newest-wins rule; analyze, explain and
+// liveness all pick relay lists with it
+func TestAddRelayListVersion(t *testing.T) {
+	versions := []relayListVersion{
+		{ID: "03", CreatedAt: 10},
+		{ID: "02", CreatedAt: 20, SeenOn: []string{"wss://b"}},
+		{ID: "01", CreatedAt: 20},
+		{ID: "02", CreatedAt: 20, SeenOn: []string{"wss://a"}},
+	}
+	// The same result whatever the order versions arrive in
+	for _, order := range [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}, {1, 3, 0, 2}} {
+		lists := map[string]relayListVersion{}
+		older := map[string][]relayListVersion{}
+		for _, i := range order {
+			addRelayListVersion(lists, older, "alice", versions[i])
+		}
+		// Same created_at: the lowest ID wins
+		if got := lists["alice"]; got.ID != "01" {
+			t.Errorf("order %v: kept %s, want 01", order, got.ID)
+		}
+		var ids []string
+		for _, v := range older["alice"] {
+			ids = append(ids, v.ID)
+			if v.ID == "02" && !reflect.DeepEqual(v.SeenOn, []string{"wss://a", "wss://b"}) {
+				t.Errorf("order %v: 02 seen on %v, want both relays", order, v.SeenOn)
+			}
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, []string{"02", "03"}) {
+			t.Errorf("order %v: older %v, want 02 and 03", order, ids)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultPubkeyEnv is the environment variable read for the pubkey when
+// neither --pubkey nor --pubkey-file is given
+const defaultPubkeyEnv = "FEEDBUILDER_PUBKEY"
+
+// pubkeySource holds the flags a pubkey can be supplied through, so it can be
+// kept out of shell history and process listings
+type pubkeySource struct {
+	value *string
+	file  *string
+	env   *string
+}
+
+// pubkeyFlags registers --pubkey, --pubkey-file and --pubkey-from-env on fs
+func pubkeyFlags(fs *flag.FlagSet, usage string) *pubkeySource {
+	return &pubkeySource{
+		value: fs.String("pubkey", "", usage+" (hex, npub or nprofile)"),
+		file:  fs.String("pubkey-file", "", "read the pubkey from this file instead of --pubkey"),
+		env:   fs.String("pubkey-from-env", defaultPubkeyEnv, "environment variable read for the pubkey when neither --pubkey nor --pubkey-file is set (empty = off)"),
+	}
+}
+
+// resolve returns the pubkey as lowercase hex, taken from --pubkey, else
+// --pubkey-file, else the environment. It returns "" when none is set.
+func (p *pubkeySource) resolve() (string, error) {
+	raw, from := *p.value, "--pubkey"
+	if raw == "" && *p.file != "" {
+		data, err := os.ReadFile(*p.file)
+		if err != nil {
+			return "", fmt.Errorf("--pubkey-file: %w", err)
+		}
+		raw, from = strings.TrimSpace(string(data)), "--pubkey-file "+*p.file
+		if raw == "" {
+			return "", fmt.Errorf("%s: empty file", from)
+		}
+	}
+	if raw == "" && *p.env != "" {
+		raw, from = os.Getenv(*p.env), "$"+*p.env
+	}
+	if raw == "" {
+		return "", nil
+	}
+	pk, err := parsePubkey(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", from, err)
+	}
+	return pk, nil
+}
+
+// parsePubkey accepts a pubkey as 64-char hex, npub or nprofile (optionally
+// with a "nostr:" prefix) and returns it as lowercase hex
+func parsePubkey(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 6 && strings.EqualFold(s[:6], "nostr:") {
+		s = s[6:]
+	}
+	lower := strings.ToLower(s)
+	if lower == "" {
+		return "", errors.New("empty pubkey")
+	}
+	if isHex64(lower) {
+		return lower, nil
+	}
+	// BIP-173: a bech32 string is either all lowercase or all uppercase
+	if s != lower && s != strings.ToUpper(s) {
+		return "", errors.New("mixed-case bech32")
+	}
+
+	hrp, data, err := bech32Decode(lower)
+	if err != nil {
+		return "", fmt.Errorf("not 64-hex, npub or nprofile: %w", err)
+	}
+	switch hrp {
+	case "npub":
+		if len(data) != 32 {
+			return "", fmt.Errorf("npub carries %d bytes, want 32", len(data))
+		}
+		return hex.EncodeToString(data), nil
+	case "nprofile":
+		// TLV entries: type 0 is the 32-byte pubkey, others (relays) are skipped
+		for len(data) >= 2 {
+			t, l := data[0], int(data[1])
+			if len(data) < 2+l {
+				return "", errors.New("truncated nprofile")
+			}
+			if t == 0 {
+				if l != 32 {
+					return "", fmt.Errorf("nprofile pubkey has %d bytes, want 32", l)
+				}
+				return hex.EncodeToString(data[2 : 2+l]), nil
+			}
+			data = data[2+l:]
+		}
+		return "", errors.New("nprofile has no pubkey")
+	default:
+		return "", fmt.Errorf("unsupported bech32 prefix %q (want npub or nprofile)", hrp)
+	}
+}
+
+// pTagPubkey returns the pubkey of a p-tag value as lowercase hex. Besides
+// the 64-hex form NIP-02 asks for, it accepts the npub (or nprofile) some
+// clients write instead.
+func pTagPubkey(v string) (string, bool) {
+	pk, err := parsePubkey(v)
+	return pk, err == nil
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Decode decodes a lowercase bech32 string (BIP-173) into its human
+// readable part and 8-bit data. NIP-19 strings may exceed the 90 char limit.
+func bech32Decode(s string) (string, []byte, error) {
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, errors.New("invalid bech32 separator position")
+	}
+	hrp := s[:sep]
+	values := make([]byte, 0, len(s)-sep-1)
+	for _, c := range s[sep+1:] {
+		i := strings.IndexRune(bech32Charset, c)
+		if i < 0 {
+			return "", nil, fmt.Errorf("invalid bech32 character %q", c)
+		}
+		values = append(values, byte(i))
+	}
+	if bech32Polymod(append(bech32ExpandHRP(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid bech32 checksum")
+	}
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32ExpandHRP(hrp string) []byte {
+	out := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+// convertBits regroups a slice of fromBits-wide values into toBits-wide values
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	maxv := uint32(1)<<toBits - 1
+	var out []byte
+	for _, v := range data {
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.New("invalid bech32 padding")
+	}
+	return out, nil
+}
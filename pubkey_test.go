@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// NIP-19 test vectors, plus an nprofile whose relay TLV comes before the pubkey
+const (
+	testPubkeyHex       = "7e7e9c42a91bfef19fa929e5fda1b72e0ebc1a4c1141673e2794234d86addf4e"
+	testNpub            = "npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjptg"
+	testNprofileHex     = "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"
+	testNprofile        = "nprofile1qqsrhuxx8l9ex335q7he0f09aej04zpazpl0ne2cgukyawd24mayt8gpp4mhxue69uhhytnc9e3k7mgpz4mhxue69uhkg6nzv9ejuumpv34kytnrdaksjlyr9p"
+	testNprofileLateKey = "nprofile1qyzksetvd3hsqgr706wy92gmlmcel2ffuh76rdewp67p5nq3g9nnufu5ydxcdtwlfcprnnc8"
+)
+
+func TestParsePubkey(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"hex", testPubkeyHex, testPubkeyHex},
+		{"uppercase hex", strings.ToUpper(testPubkeyHex), testPubkeyHex},
+		{"hex with spaces", "  " + testPubkeyHex + "\n", testPubkeyHex},
+		{"npub", testNpub, testPubkeyHex},
+		{"uppercase npub", strings.ToUpper(testNpub), testPubkeyHex},
+		{"nostr: npub", "nostr:" + testNpub, testPubkeyHex},
+		{"NOSTR: NPUB", "NOSTR:" + strings.ToUpper(testNpub), testPubkeyHex},
+		{"nprofile", testNprofile, testNprofileHex},
+		// TLV entries before the pubkey (here a relay) are skipped
+		{"nprofile pubkey after relay", testNprofileLateKey, testPubkeyHex},
+	}
+	for _, tt := range tests {
+		got, err := parsePubkey(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: parsePubkey(%q) = %q, %v; want %q", tt.name, tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParsePubkeyInvalid(t *testing.T) {
+	tests := []struct {
+		name, in, wantErr string
+	}{
+		{"empty", "  ", "empty pubkey"},
+		{"short hex", testPubkeyHex[:63], "bech32"},
+		{"bad checksum", testNpub[:len(testNpub)-1] + "q", "invalid bech32 checksum"},
+		{"mixed case", "npub1" + strings.ToUpper(testNpub[5:10]) + testNpub[10:], "mixed-case bech32"},
+		{"invalid character", "npub1" + "b" + testNpub[6:], "invalid bech32 character"},
+		{"wrong hrp", "note10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qnx3ujq", `unsupported bech32 prefix "note"`},
+		{"bad padding", "npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8pl6x5k6", "invalid bech32 padding"},
+		{"short npub", "npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjv9gm6wy", "npub carries 20 bytes"},
+		{"truncated tlv", "nprofile1qqs8ul5ug253hlh3n75suvm3eq", "truncated nprofile"},
+		{"nprofile without pubkey", "nprofile1qyzksetvd3hs2nk29x", "nprofile has no pubkey"},
+		{"no separator", "npub", "separator"},
+	}
+	for _, tt := range tests {
+		got, err := parsePubkey(tt.in)
+		if err == nil {
+			t.Errorf("%s: parsePubkey(%q) = %q, want error", tt.name, tt.in, got)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: parsePubkey(%q) error %q, want it to mention %q", tt.name, tt.in, err, tt.wantErr)
+		}
+	}
+}
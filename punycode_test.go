@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+// Sample strings from RFC 3492 section 7.1
+func TestPunycodeEncodeRFC3492(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"A Arabic", "ليهمابتكلموشعربي؟", "egbpdaj6bu4bxfgehfvwxn"},
+		{"B Chinese simplified", "他们为什么不说中文", "ihqwcrb4cv8a8dqg056pqjye"},
+		{"C Chinese traditional", "他們爲什麽不說中文", "ihqwctvzc91f659drss3x8bo0yb"},
+		{"D Czech", "Pročprostěnemluvíčesky", "Proprostnemluvesky-uyb24dma41a"},
+		{"G Japanese", "なぜみんな日本語を話してくれないのか", "n8jok5ay5dzabd5bym9f0cm5685rrjetr6pdxa"},
+		{"L", "3年B組金八先生", "3B-ww4c5e180e575a65lsy2b"},
+		{"M", "安室奈美恵-with-SUPER-MONKEYS", "-with-SUPER-MONKEYS-pc58ag80a8qai00g7n9n"},
+		{"N", "Hello-Another-Way-それぞれの場所", "Hello-Another-Way--fc4qua05auwb3674vfr0b"},
+		{"O", "ひとつ屋根の下2", "2-u9tlzr9756bt3uc0v"},
+		{"P", "MajiでKoiする5秒前", "MajiKoi5-783gue6qz075azm5e"},
+		{"Q", "パフィーdeルンバ", "de-jg4avhby1noc0d"},
+		{"R", "そのスピードで", "d9juau41awczczp"},
+		{"S", "-> $1.00 <-", "-> $1.00 <--"},
+	}
+	for _, tt := range tests {
+		if got := punycodeEncode(tt.in); got != tt.want {
+			t.Errorf("%s: punycodeEncode = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestASCIIHostname(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"relay.example.com", "relay.example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"münchen.de", "xn--mnchen-3ya.de"},
+		{"例え。テスト", "xn--r8jz45g.xn--zckzah"},
+		{"例え．テスト", "xn--r8jz45g.xn--zckzah"},
+		{"例え｡テスト", "xn--r8jz45g.xn--zckzah"},
+	}
+	for _, tt := range tests {
+		if got := asciiHostname(tt.in); got != tt.want {
+			t.Errorf("asciiHostname(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
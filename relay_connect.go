@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	nostr "github.com/nbd-wtf/go-nostr"
+)
+
+// maxConnectBackoff caps the wait between connection attempts
+const maxConnectBackoff = 4 * time.Second
+
+// connectWithRetry connects to relayURL, retrying up to retries more times
+// with a 1s, 2s, 4s, ... backoff (capped at maxConnectBackoff). Each attempt
+// gets its own timeout; the backoff stops early when ctx is cancelled. It
+// also returns how long the successful attempt took.
+func connectWithRetry(ctx context.Context, relayURL string, timeout time.Duration, retries int) (*nostr.Relay, time.Duration, error) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		connectCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		relay, err := nostr.RelayConnect(connectCtx, relayURL)
+		cancel()
+		if err == nil {
+			return relay, time.Since(start), nil
+		}
+		if attempt >= retries || ctx.Err() != nil {
+			return nil, 0, err
+		}
+		fmt.Fprintf(os.Stderr, "warning: connect to %s failed (%v); retry %d/%d in %s\n", relayURL, err, attempt+1, retries, backoff)
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// fetchAuthorEvents asks each relay, parallel at a time, for the kind events
+// of every batch of authors and passes each event received to onEvent. Calls
+// to onEvent are serialized, so it needs no locking of its own. label names
+// the caller in connection warnings.
+func fetchAuthorEvents(ctx context.Context, label string, relays []string, batches [][]string, kind, parallel int, timeout time.Duration, onEvent func(ev *nostr.Event)) {
+	var mu sync.Mutex
+	semaphore := make(chan struct{}, max(1, parallel))
+	var wg sync.WaitGroup
+	for _, relayURL := range relays {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			connectCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			relay, err := nostr.RelayConnect(connectCtx, url)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "    ⚠ %s: cannot connect to %s: %v\n", label, url, err)
+				return
+			}
+			defer relay.Close()
+
+			for _, batch := range batches {
+				batchCtx, batchCancel := context.WithTimeout(ctx, timeout)
+				filters := nostr.Filters{nostr.Filter{Kinds: []int{kind}, Authors: batch, Limit: len(batch)}}
+				sub, err := relay.Subscribe(batchCtx, filters)
+				if err != nil {
+					batchCancel()
+					continue
+				}
+			events:
+				for {
+					select {
+					case <-batchCtx.Done():
+						break events
+					case <-sub.EndOfStoredEvents:
+						break events
+					case ev := <-sub.Events:
+						if ev == nil || ev.Kind != kind {
+							continue
+						}
+						mu.Lock()
+						onEvent(ev)
+						mu.Unlock()
+					}
+				}
+				sub.Unsub()
+				batchCancel()
+			}
+		}(relayURL)
+	}
+	wg.Wait()
+}
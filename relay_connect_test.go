@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	nostr "github.com/nbd-wtf/go-nostr"
+)
+
+// fakeRelay answers every REQ with the events matching its filters, followed
+// by EOSE. It returns the relay URL and the number of REQs received.
+func fakeRelay(t *testing.T, events ...*nostr.Event) (string, func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	reqs := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _, err := ws.UpgradeHTTP(r, w)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			msg, err := wsutil.ReadClientText(conn)
+			if err != nil {
+				return
+			}
+			var req []json.RawMessage
+			if json.Unmarshal(msg, &req) != nil || len(req) < 2 || string(req[0]) != `"REQ"` {
+				continue
+			}
+			var filters nostr.Filters
+			for _, raw := range req[2:] {
+				var f nostr.Filter
+				if json.Unmarshal(raw, &f) == nil {
+					filters = append(filters, f)
+				}
+			}
+			mu.Lock()
+			reqs++
+			mu.Unlock()
+			for _, ev := range events {
+				if !filters.Match(ev) {
+					continue
+				}
+				out, _ := json.Marshal([]any{"EVENT", req[1], ev})
+				if wsutil.WriteServerText(conn, out) != nil {
+					return
+				}
+			}
+			out, _ := json.Marshal([]any{"EOSE", req[1]})
+			if wsutil.WriteServerText(conn, out) != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return reqs
+	}
+}
+
+// signedEvent returns an event by sk, signed so the client accepts it
+func signedEvent(t *testing.T, sk string, kind int, createdAt int64, tags nostr.Tags, content string) *nostr.Event {
+	t.Helper()
+	ev := &nostr.Event{Kind: kind, CreatedAt: nostr.Timestamp(createdAt), Tags: tags, Content: content}
+	if err := ev.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func TestFetchAuthorEvents(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	note := signedEvent(t, sk, 1, 1, nil, "hi")
+	profile := signedEvent(t, sk, 0, 1, nil, `{"name":"alice"}`)
+	url1, reqs1 := fakeRelay(t, note, profile)
+	url2, reqs2 := fakeRelay(t, profile)
+
+	var got []string
+	batches := [][]string{{pk}, {strings.Repeat("0", 64)}}
+	captureOutput(t, &os.Stderr, func() {
+		fetchAuthorEvents(context.Background(), "Test", []string{url1, url2}, batches, 0, 2, 5*time.Second, func(ev *nostr.Event) {
+			got = append(got, ev.ID)
+		})
+	})
+	if want := []string{profile.ID, profile.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events %v, want the profile from each relay", got)
+	}
+	if reqs1() != len(batches) || reqs2() != len(batches) {
+		t.Errorf("REQs per relay %d and %d, want one per batch", reqs1(), reqs2())
+	}
+
+	// An unreachable relay is reported under the caller's label and skipped
+	stderr := captureOutput(t, &os.Stderr, func() {
+		fetchAuthorEvents(context.Background(), "Test", []string{"ws://127.0.0.1:1"}, batches, 0, 0, time.Second, func(*nostr.Event) {
+			t.Error("event from an unreachable relay")
+		})
+	})
+	if !strings.Contains(stderr, "⚠ Test: cannot connect to ws://127.0.0.1:1") {
+		t.Errorf("warning %q", stderr)
+	}
+}
+
+func TestFetchContactLists(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	older := signedEvent(t, sk, 3, 1, nostr.Tags{{"p", testPubkeyHex}}, "")
+	newer := signedEvent(t, sk, 3, 2, nostr.Tags{
+		{"p", strings.Repeat("b", 64)},
+		{"p", testNpub},
+		{"p", strings.ToUpper(testPubkeyHex)},
+		{"p", "not a pubkey"},
+		{"e", strings.Repeat("c", 64)},
+	}, "")
+	// The newest list wins whichever relay it comes from
+	url1, _ := fakeRelay(t, newer)
+	url2, _ := fakeRelay(t, older)
+
+	lists := fetchContactLists(context.Background(), []string{url1, url2}, [][]string{{pk}}, 2, 5*time.Second)
+	want := map[string][]string{pk: deduplicateAndSort([]string{testPubkeyHex, strings.Repeat("b", 64)})}
+	if !reflect.DeepEqual(lists, want) {
+		t.Errorf("contact lists %v, want %v", lists, want)
+	}
+}
+
+func TestFetchProfileNames(t *testing.T) {
+	sk1, sk2 := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	pk1, _ := nostr.GetPublicKey(sk1)
+	pk2, _ := nostr.GetPublicKey(sk2)
+	url, _ := fakeRelay(t,
+		signedEvent(t, sk1, 0, 1, nil, `{"name":"old"}`),
+		signedEvent(t, sk1, 0, 2, nil, `{"name":"alice","display_name":"Alice A."}`),
+		// A newer profile without a name does not hide the named one
+		signedEvent(t, sk1, 0, 3, nil, `{"about":"no name"}`),
+		signedEvent(t, sk2, 0, 1, nil, `not json`),
+	)
+	names := fetchProfileNames(context.Background(), []string{url}, [][]string{{pk1, pk2}}, 1, 5*time.Second)
+	if want := map[string]string{pk1: "Alice A."}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names %v, want %v", names, want)
+	}
+}
+
+func TestConnectWithRetry(t *testing.T) {
+	url, _ := fakeRelay(t)
+	stderr := captureOutput(t, &os.Stderr, func() {
+		relay, _, err := connectWithRetry(context.Background(), url, 5*time.Second, 2)
+		if err != nil {
+			t.Fatalf("connect to a live relay: %v", err)
+		}
+		relay.Close()
+	})
+	if stderr != "" {
+		t.Errorf("warnings for a first-try connect: %q", stderr)
+	}
+
+	// No retries: one attempt, no warning
+	const dead = "ws://127.0.0.1:1"
+	stderr = captureOutput(t, &os.Stderr, func() {
+		if _, _, err := connectWithRetry(context.Background(), dead, time.Second, 0); err == nil {
+			t.Error("connected to a closed port")
+		}
+	})
+	if stderr != "" {
+		t.Errorf("warnings without retries: %q", stderr)
+	}
+
+	// One retry after the first 1s backoff
+	start := time.Now()
+	stderr = captureOutput(t, &os.Stderr, func() {
+		if _, _, err := connectWithRetry(context.Background(), dead, time.Second, 1); err == nil {
+			t.Error("connected to a closed port")
+		}
+	})
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want a 1s backoff", elapsed)
+	}
+	if want := "warning: connect to " + dead; strings.Count(stderr, want) != 1 || !strings.Contains(stderr, "retry 1/1 in 1s") {
+		t.Errorf("warnings %q", stderr)
+	}
+
+	// Cancelling the context ends the backoff early
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	captureOutput(t, &os.Stderr, func() {
+		if _, _, err := connectWithRetry(ctx, dead, time.Second, 5); err == nil {
+			t.Error("connected to a closed port")
+		}
+	})
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("gave up after %s, want soon after the context ended", elapsed)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	nostr "github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// nip11NoExpiry as a ttl only fetches relays that are not cached at all
+const nip11NoExpiry = time.Duration(1<<63 - 1)
+
+// relayInfoEntry is one cached NIP-11 lookup, plus what collect observed
+// when querying the relay
+type relayInfoEntry struct {
+	FetchedAt int64                           `json:"fetched_at"`
+	Error     string                          `json:"error,omitempty"`
+	Info      *nip11.RelayInformationDocument `json:"info,omitempty"`
+	Stats     *relayStats                     `json:"stats,omitempty"`
+}
+
+// relayStats accumulates collect runs against one relay
+type relayStats struct {
+	Runs              int   `json:"runs"`
+	Connected         int   `json:"connected"`
+	Batches           int   `json:"batches"`
+	BatchesWithEvents int   `json:"batches_with_events"`
+	Events            int   `json:"events"`
+	LastRun           int64 `json:"last_run"`
+}
+
+// minScoredRuns is the number of runs below which a relay is not scored
+const minScoredRuns = 2
+
+// score rates a relay in (0, 1]: the share of runs it accepted a connection,
+// times 0.5 + 0.5 * the share of batches it returned events for. Relays with
+// fewer than minScoredRuns runs score 1 so a single bad run doesn't count.
+func (st *relayStats) score() float64 {
+	if st == nil || st.Runs < minScoredRuns {
+		return 1
+	}
+	connect := float64(st.Connected) / float64(st.Runs)
+	yield := 1.0
+	if st.Batches > 0 {
+		yield = float64(st.BatchesWithEvents) / float64(st.Batches)
+	}
+	if s := connect * (0.5 + 0.5*yield); s > 0 {
+		return s
+	}
+	// Never zero, so gain still decides between relays that always fail
+	return 0.01
+}
+
+// relayInfoCache maps relay URL to its last NIP-11 lookup, persisted as relay_cache.json.
+// URLs are keyed by normalizeURL, so --relays spellings and analyze output agree.
+type relayInfoCache struct {
+	path    string
+	mu      sync.Mutex
+	Entries map[string]*relayInfoEntry `json:"relays"`
+}
+
+// loadRelayInfoCache reads the cache at path; a missing or unreadable file yields an empty cache
+func loadRelayInfoCache(path string) *relayInfoCache {
+	c := &relayInfoCache{path: path, Entries: map[string]*relayInfoEntry{}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: ignoring unreadable %s: %v\n", path, err)
+		c.Entries = map[string]*relayInfoEntry{}
+	}
+	if c.Entries == nil {
+		c.Entries = map[string]*relayInfoEntry{}
+	}
+	// Older caches may hold URLs as typed; the first spelling read wins
+	for url, e := range c.Entries {
+		if key := normalizeURL(url); key != url {
+			delete(c.Entries, url)
+			if c.Entries[key] == nil {
+				c.Entries[key] = e
+			}
+		}
+	}
+	return c
+}
+
+func (c *relayInfoCache) get(url string) *relayInfoEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Entries[normalizeURL(url)]
+}
+
+// fetch refreshes entries older than ttl for the given relays with bounded parallelism.
+// Each lookup is bounded by timeout and cancelled with ctx.
+// It returns the number of relays actually queried.
+func (c *relayInfoCache) fetch(ctx context.Context, urls []string, parallel int, timeout, ttl time.Duration) int {
+	if parallel < 1 {
+		parallel = 1
+	}
+	now := time.Now()
+	var stale []string
+	for _, url := range urls {
+		e := c.get(url)
+		if e == nil || e.FetchedAt == 0 || now.Sub(time.Unix(e.FetchedAt, 0)) > ttl {
+			stale = append(stale, url)
+		}
+	}
+
+	semaphore := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for _, url := range stale {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			info, err := fetchNIP11(ctx, url)
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			// Update the lookup in place: Stats from recordRun must survive a refresh
+			key := normalizeURL(url)
+			entry := c.Entries[key]
+			if entry == nil {
+				entry = &relayInfoEntry{}
+				c.Entries[key] = entry
+			}
+			entry.FetchedAt = time.Now().Unix()
+			entry.Info, entry.Error = nil, ""
+			if err != nil {
+				entry.Error = err.Error()
+			} else {
+				entry.Info = info
+			}
+		}(url)
+	}
+	wg.Wait()
+	return len(stale)
+}
+
+// fetchNIP11 fetches a relay's NIP-11 document through the shared HTTP
+// client, so a stalled server is dropped after --http-timeout
+func fetchNIP11(ctx context.Context, url string) (*nip11.RelayInformationDocument, error) {
+	resp, err := httpGet(ctx, "http"+nostr.NormalizeURL(url)[2:], "application/nostr+json")
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	info := &nip11.RelayInformationDocument{}
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+	return info, nil
+}
+
+// defaultMaxFilters is the number of filters sent in one REQ to relays that
+// advertise no NIP-11 max_filters; many relays enforce 10
+const defaultMaxFilters = 10
+
+// maxFilters returns the cached NIP-11 max_filters of url, or
+// defaultMaxFilters when unknown. c may be nil.
+func (c *relayInfoCache) maxFilters(url string) int {
+	if c == nil {
+		return defaultMaxFilters
+	}
+	if e := c.get(url); e != nil && e.Info != nil && e.Info.Limitation != nil && e.Info.Limitation.MaxFilters > 0 {
+		return e.Info.Limitation.MaxFilters
+	}
+	return defaultMaxFilters
+}
+
+// recordRun adds one collect run against url to its stats
+func (c *relayInfoCache) recordRun(url string, connected bool, batches, batchesWithEvents, events int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := normalizeURL(url)
+	e := c.Entries[key]
+	if e == nil {
+		e = &relayInfoEntry{}
+		c.Entries[key] = e
+	}
+	if e.Stats == nil {
+		e.Stats = &relayStats{}
+	}
+	e.Stats.Runs++
+	if connected {
+		e.Stats.Connected++
+	}
+	e.Stats.Batches += batches
+	e.Stats.BatchesWithEvents += batchesWithEvents
+	e.Stats.Events += events
+	e.Stats.LastRun = time.Now().Unix()
+}
+
+// score returns the observed reliability score of url (1 when unobserved)
+func (c *relayInfoCache) score(url string) float64 {
+	e := c.get(url)
+	if e == nil {
+		return 1
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return e.Stats.score()
+}
+
+// save writes the cache atomically via a temp file and rename
+func (c *relayInfoCache) save() error {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, data)
+}
+
+// nip11Predicate matches a NIP-11 document field against a glob, e.g. "version=1.0.*"
+type nip11Predicate struct {
+	field string
+	glob  string
+}
+
+func (p nip11Predicate) String() string {
+	return p.field + "=" + p.glob
+}
+
+// parseNIP11Predicates parses comma-separated field=glob pairs
+func parseNIP11Predicates(s string) ([]nip11Predicate, error) {
+	var preds []nip11Predicate
+	for _, part := range splitCSV(s) {
+		field, glob, ok := strings.Cut(part, "=")
+		field = strings.ToLower(strings.TrimSpace(field))
+		glob = strings.ToLower(strings.TrimSpace(glob))
+		if !ok || field == "" || glob == "" {
+			return nil, fmt.Errorf("invalid predicate %q (want field=glob)", part)
+		}
+		if _, known := nip11Field(&nip11.RelayInformationDocument{}, field); !known {
+			return nil, fmt.Errorf("unknown NIP-11 field %q", field)
+		}
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", glob, err)
+		}
+		preds = append(preds, nip11Predicate{field: field, glob: glob})
+	}
+	return preds, nil
+}
+
+// nip11Field returns the lowercased string value of a NIP-11 field. Slice
+// fields are joined with commas; limitation flags are "true" or "false".
+func nip11Field(info *nip11.RelayInformationDocument, field string) (string, bool) {
+	lim := info.Limitation
+	if lim == nil {
+		lim = &nip11.RelayLimitationDocument{}
+	}
+	var v string
+	switch field {
+	case "name":
+		v = info.Name
+	case "software":
+		v = info.Software
+	case "version":
+		v = info.Version
+	case "pubkey":
+		v = info.PubKey
+	case "contact":
+		v = info.Contact
+	case "supported_nips":
+		nips := make([]string, len(info.SupportedNIPs))
+		for i, n := range info.SupportedNIPs {
+			nips[i] = strconv.Itoa(n)
+		}
+		v = strings.Join(nips, ",")
+	case "auth_required":
+		v = strconv.FormatBool(lim.AuthRequired)
+	case "payment_required":
+		v = strconv.FormatBool(lim.PaymentRequired)
+	case "restricted_writes":
+		v = strconv.FormatBool(lim.RestrictedWrites)
+	default:
+		return "", false
+	}
+	return strings.ToLower(strings.TrimSpace(v)), true
+}
+
+// softwareName reduces a NIP-11 software URL like
+// "git+https://github.com/hoytech/strfry.git" to "strfry"
+func softwareName(software string) string {
+	s := strings.ToLower(strings.TrimSpace(software))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/"), ".git")
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
+
+// matchNIP11Exclusion returns the first criterion that excludes a relay, or ""
+func matchNIP11Exclusion(info *nip11.RelayInformationDocument, softwareGlobs []string, preds []nip11Predicate) string {
+	if info == nil {
+		return ""
+	}
+	software := strings.ToLower(strings.TrimSpace(info.Software))
+	if software != "" {
+		name := softwareName(software)
+		for _, g := range softwareGlobs {
+			g = strings.ToLower(g)
+			if ok, _ := path.Match(g, software); ok {
+				return "software=" + g
+			}
+			if ok, _ := path.Match(g, name); ok {
+				return "software=" + g
+			}
+		}
+	}
+	for _, p := range preds {
+		v, _ := nip11Field(info, p.field)
+		if ok, _ := path.Match(p.glob, v); ok {
+			return p.String()
+		}
+	}
+	return ""
+}
+
+// excludeByNIP11 drops relays from writeMap whose cached NIP-11 document
+// matches a software glob or predicate, and with dropMissing also relays that
+// served no document. Returns excluded relay -> criterion.
+func excludeByNIP11(writeMap map[string]set, cache *relayInfoCache, softwareGlobs []string, preds []nip11Predicate, dropMissing bool) map[string]string {
+	excluded := map[string]string{}
+	for url := range writeMap {
+		e := cache.get(url)
+		if e == nil || e.Info == nil {
+			if dropMissing {
+				why := "no NIP-11"
+				if e != nil && e.Error != "" {
+					why += ": " + e.Error
+				}
+				excluded[url] = why
+			}
+			continue
+		}
+		if why := matchNIP11Exclusion(e.Info, softwareGlobs, preds); why != "" {
+			excluded[url] = why
+		}
+	}
+	for url := range excluded {
+		delete(writeMap, url)
+	}
+	return excluded
+}
+
+// sortedKeys returns the keys of a string map in sorted order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRelayInfoFetchKeepsStats(t *testing.T) {
+	// Nothing listens on port 1, so the NIP-11 lookup fails fast
+	const url = "ws://127.0.0.1:1"
+	c := loadRelayInfoCache(filepath.Join(t.TempDir(), "relay_cache.json"))
+	c.recordRun(url, true, 4, 3, 10)
+	c.recordRun(url, false, 0, 0, 0)
+
+	// A stats-only entry has never been looked up, so even nip11NoExpiry refreshes it
+	if n := c.fetch(context.Background(), []string{url}, 1, 2*time.Second, nip11NoExpiry); n != 1 {
+		t.Fatalf("fetch queried %d relays, want 1", n)
+	}
+	e := c.get(url)
+	if e.FetchedAt == 0 || e.Error == "" {
+		t.Fatalf("lookup not recorded: %+v", e)
+	}
+	if e.Stats == nil || e.Stats.Runs != 2 || e.Stats.Connected != 1 || e.Stats.Events != 10 {
+		t.Fatalf("stats lost on refresh: %+v", e.Stats)
+	}
+
+	// Once looked up, the entry is fresh and kept as is
+	if n := c.fetch(context.Background(), []string{url}, 1, 2*time.Second, nip11NoExpiry); n != 0 {
+		t.Fatalf("fresh entry refetched (%d)", n)
+	}
+	if got := c.score(url); got != 0.5*(0.5+0.5*0.75) {
+		t.Fatalf("score = %v", got)
+	}
+}
+
+func TestRelayInfoStatsNormalizedURL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay_cache.json")
+	c := loadRelayInfoCache(path)
+
+	// collect records stats under --relays as typed, gen-router looks them up normalized
+	c.recordRun("wss://Relay.Damus.io/", true, 4, 0, 0)
+	c.recordRun("wss://relay.damus.io", true, 4, 0, 0)
+	e := c.get("wss://relay.damus.io")
+	if e == nil || e.Stats == nil || e.Stats.Runs != 2 {
+		t.Fatalf("stats not merged under the normalized URL: %+v", e)
+	}
+	if got, want := c.score(normalizeURL("wss://Relay.Damus.io/")), c.score("wss://Relay.Damus.io/"); got != want || got == 1 {
+		t.Fatalf("score = %v for the normalized URL, %v as typed", got, want)
+	}
+
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+	if e := loadRelayInfoCache(path).get("WSS://RELAY.DAMUS.IO/"); e == nil || e.Stats.Runs != 2 {
+		t.Fatalf("reloaded entry = %+v", e)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// runRestartDelay picks a restart delay in seconds for the router. Configs that
+// fan out to many relays reconnect to all of them on every restart, so they back
+// off longer to avoid tripping relay connection-rate limits.
+func runRestartDelay(relayCount int) int {
+	delay := 5 + relayCount/10
+	if delay > 60 {
+		delay = 60
+	}
+	return delay
+}
+
+// writeRunScripts writes run-router.sh (a restart loop with backoff) and a
+// strfry-router.service systemd unit next to the router config
+func writeRunScripts(configPath string, streams []streamConfig) ([]string, error) {
+	absConfig, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, err
+	}
+	relays := set{}
+	for _, s := range streams {
+		for _, u := range s.URLs {
+			relays.add(u)
+		}
+	}
+	delay := runRestartDelay(len(relays))
+	dir := filepath.Dir(absConfig)
+
+	script := fmt.Sprintf(`#!/bin/sh
+# Generated by feedbuilder gen-router: %d streams across %d relays.
+# Runs the strfry router and restarts it with backoff when it exits.
+# Set STRFRY to the strfry binary and run from the strfry working directory.
+STRFRY="${STRFRY:-./strfry}"
+CONFIG=%q
+DELAY=%d
+MAX_DELAY=300
+
+while true; do
+	"$STRFRY" router "$CONFIG" && DELAY=%d
+	echo "strfry router exited; restarting in ${DELAY}s" >&2
+	sleep "$DELAY"
+	DELAY=$((DELAY * 2))
+	if [ "$DELAY" -gt "$MAX_DELAY" ]; then
+		DELAY=$MAX_DELAY
+	fi
+done
+`, len(streams), len(relays), absConfig, delay, delay)
+
+	unit := fmt.Sprintf(`# Generated by feedbuilder gen-router: %d streams across %d relays.
+# Adjust ExecStart and WorkingDirectory to your strfry install, then copy to
+# /etc/systemd/system/ and run: systemctl enable --now strfry-router
+[Unit]
+Description=strfry router (feedbuilder)
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+WorkingDirectory=%s
+ExecStart=/usr/local/bin/strfry router %s
+Restart=always
+RestartSec=%d
+LimitNOFILE=65536
+
+[Install]
+WantedBy=multi-user.target
+`, len(streams), len(relays), dir, absConfig, delay)
+
+	scriptPath := filepath.Join(dir, "run-router.sh")
+	unitPath := filepath.Join(dir, "strfry-router.service")
+	if err := os.WriteFile(scriptPath, []byte(script), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(unitPath, []byte(unit), 0o644); err != nil {
+		return nil, err
+	}
+	return []string{scriptPath, unitPath}, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Selector picks relays and assigns each author to up to opts.replicas of
+// them. It returns the selected relays and a mapping of relay -> authors.
+type Selector interface {
+	Select(relayAuthors map[string][]string, opts selectOptions) (selected []string, assigned map[string][]string)
+}
+
+// strategies are the built-in selectors for --strategy
+var strategies = map[string]func(seed int64) Selector{
+	"greedy":  func(int64) Selector { return greedySelector{} },
+	"popular": func(int64) Selector { return popularSelector{} },
+	"primary": func(int64) Selector { return primarySelector{} },
+	"random":  func(seed int64) Selector { return randomSelector{seed: seed} },
+}
+
+// strategyNames returns the built-in strategy names, sorted
+func strategyNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectorByName returns the built-in strategy with the given name
+func selectorByName(name string, seed int64) (Selector, error) {
+	mk, ok := strategies[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q (want one of %s)", name, strings.Join(strategyNames(), ", "))
+	}
+	return mk(seed), nil
+}
+
+// greedySelector is the default: greedy set cover, fewest relays first
+type greedySelector struct{}
+
+func (greedySelector) Select(relayAuthors map[string][]string, opts selectOptions) ([]string, map[string][]string) {
+	return greedySelectAndAssignN(relayAuthors, opts)
+}
+
+// popularSelector ranks relays by how many authors list them and assigns
+// each author to the top-ranked relays that cover them
+type popularSelector struct{}
+
+func (popularSelector) Select(relayAuthors map[string][]string, opts selectOptions) ([]string, map[string][]string) {
+	ranking := rankRelaysByPopularity(relayAuthors, opts.tieBreak)
+	return assignByRanking(relayAuthors, ranking, opts, nil)
+}
+
+// primarySelector puts each author on their primary relay (first write relay
+// in their 10002) and fills further replicas, or authors without a primary,
+// from the most popular relays
+type primarySelector struct{}
+
+func (primarySelector) Select(relayAuthors map[string][]string, opts selectOptions) ([]string, map[string][]string) {
+	ranking := rankRelaysByPopularity(relayAuthors, opts.tieBreak)
+	return assignByRanking(relayAuthors, ranking, opts, opts.primary)
+}
+
+// randomSelector assigns each author to random relays among those they list.
+// A fixed seed keeps the output reproducible between runs.
+type randomSelector struct {
+	seed int64
+}
+
+func (s randomSelector) Select(relayAuthors map[string][]string, opts selectOptions) ([]string, map[string][]string) {
+	rng := rand.New(rand.NewSource(s.seed))
+	byAuthor := relaysByAuthor(relayAuthors)
+	authors := make([]string, 0, len(byAuthor))
+	for a := range byAuthor {
+		authors = append(authors, a)
+	}
+	sort.Strings(authors)
+
+	assigned := make(map[string][]string)
+	for _, a := range authors {
+		relays := byAuthor[a]
+		rng.Shuffle(len(relays), func(i, j int) { relays[i], relays[j] = relays[j], relays[i] })
+		for i, n := 0, opts.replicasFor(len(relays)); i < len(relays) && i < n; i++ {
+			assigned[relays[i]] = append(assigned[relays[i]], a)
+		}
+	}
+	return selectedFromAssigned(assigned), assigned
+}
+
+// rankRelaysByPopularity orders relays by author count, then tieBreak score, then URL
+func rankRelaysByPopularity(relayAuthors map[string][]string, tieBreak func(string) int) []string {
+	ranking := make([]string, 0, len(relayAuthors))
+	for relay := range relayAuthors {
+		ranking = append(ranking, relay)
+	}
+	sort.Slice(ranking, func(i, j int) bool {
+		ri, rj := ranking[i], ranking[j]
+		if ni, nj := len(relayAuthors[ri]), len(relayAuthors[rj]); ni != nj {
+			return ni > nj
+		}
+		if tieBreak != nil {
+			if ti, tj := tieBreak(ri), tieBreak(rj); ti != tj {
+				return ti > tj
+			}
+		}
+		return ri < rj
+	})
+	return ranking
+}
+
+// assignByRanking gives each author up to replicas relays: first their entry
+// in first (if it covers them), then the best-ranked relays covering them
+func assignByRanking(relayAuthors map[string][]string, ranking []string, opts selectOptions, first map[string]string) ([]string, map[string][]string) {
+	rank := make(map[string]int, len(ranking))
+	for i, relay := range ranking {
+		rank[relay] = i
+	}
+	assigned := make(map[string][]string)
+	for a, relays := range relaysByAuthor(relayAuthors) {
+		sort.Slice(relays, func(i, j int) bool {
+			if first != nil {
+				if pi, pj := first[a] == relays[i], first[a] == relays[j]; pi != pj {
+					return pi
+				}
+			}
+			return rank[relays[i]] < rank[relays[j]]
+		})
+		for i, n := 0, opts.replicasFor(len(relays)); i < len(relays) && i < n; i++ {
+			assigned[relays[i]] = append(assigned[relays[i]], a)
+		}
+	}
+	var selected []string
+	for _, relay := range ranking {
+		if len(assigned[relay]) > 0 {
+			assigned[relay] = uniqueSorted(assigned[relay])
+			selected = append(selected, normalizeURL(relay))
+		}
+	}
+	return selected, assigned
+}
+
+// relaysByAuthor inverts relayAuthors into author -> distinct relays
+func relaysByAuthor(relayAuthors map[string][]string) map[string][]string {
+	out := make(map[string][]string)
+	seen := make(map[[2]string]bool)
+	for relay, authors := range relayAuthors {
+		for _, a := range authors {
+			k := [2]string{a, relay}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			out[a] = append(out[a], relay)
+		}
+	}
+	for a := range out {
+		sort.Strings(out[a])
+	}
+	return out
+}
+
+// selectedFromAssigned lists relays with assignments, most authors first
+func selectedFromAssigned(assigned map[string][]string) []string {
+	var selected []string
+	for relay := range assigned {
+		assigned[relay] = uniqueSorted(assigned[relay])
+		selected = append(selected, relay)
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if ni, nj := len(assigned[selected[i]]), len(assigned[selected[j]]); ni != nj {
+			return ni > nj
+		}
+		return selected[i] < selected[j]
+	})
+	for i := range selected {
+		selected[i] = normalizeURL(selected[i])
+	}
+	return selected
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// selectorFixture: big is listed by most authors, f can only be reached via
+// small or solo
+func selectorFixture() map[string][]string {
+	return map[string][]string{
+		"wss://big":   {"a", "b", "c", "d"},
+		"wss://mid":   {"a", "b", "e"},
+		"wss://small": {"e", "f"},
+		"wss://solo":  {"f"},
+	}
+}
+
+// sortedAssignment copies assigned with each relay's authors sorted
+func sortedAssignment(assigned map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(assigned))
+	for relay, authors := range assigned {
+		out[relay] = uniqueSorted(authors)
+	}
+	return out
+}
+
+func TestSelectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector Selector
+		opts     selectOptions
+		selected []string
+		assigned map[string][]string
+	}{
+		{
+			name:     "greedy",
+			selector: greedySelector{},
+			opts:     selectOptions{replicas: 1},
+			selected: []string{"wss://big", "wss://small"},
+			assigned: map[string][]string{"wss://big": {"a", "b", "c", "d"}, "wss://small": {"e", "f"}},
+		},
+		{
+			name:     "popular",
+			selector: popularSelector{},
+			opts:     selectOptions{replicas: 1},
+			selected: []string{"wss://big", "wss://mid", "wss://small"},
+			assigned: map[string][]string{"wss://big": {"a", "b", "c", "d"}, "wss://mid": {"e"}, "wss://small": {"f"}},
+		},
+		{
+			name:     "popular two replicas",
+			selector: popularSelector{},
+			opts:     selectOptions{replicas: 2},
+			selected: []string{"wss://big", "wss://mid", "wss://small", "wss://solo"},
+			assigned: map[string][]string{"wss://big": {"a", "b", "c", "d"}, "wss://mid": {"a", "b", "e"}, "wss://small": {"e", "f"}, "wss://solo": {"f"}},
+		},
+		{
+			// a's primary beats the better-ranked big; f's primary solo beats small
+			name:     "primary",
+			selector: primarySelector{},
+			opts:     selectOptions{replicas: 1, primary: map[string]string{"a": "wss://mid", "f": "wss://solo"}},
+			selected: []string{"wss://big", "wss://mid", "wss://solo"},
+			assigned: map[string][]string{"wss://big": {"b", "c", "d"}, "wss://mid": {"a", "e"}, "wss://solo": {"f"}},
+		},
+		{
+			// A primary the author does not list is ignored
+			name:     "primary not listed",
+			selector: primarySelector{},
+			opts:     selectOptions{replicas: 1, primary: map[string]string{"a": "wss://solo"}},
+			selected: []string{"wss://big", "wss://mid", "wss://small"},
+			assigned: map[string][]string{"wss://big": {"a", "b", "c", "d"}, "wss://mid": {"e"}, "wss://small": {"f"}},
+		},
+	}
+	for _, tt := range tests {
+		selected, assigned := tt.selector.Select(selectorFixture(), tt.opts)
+		if !reflect.DeepEqual(selected, tt.selected) {
+			t.Errorf("%s: selected %v, want %v", tt.name, selected, tt.selected)
+		}
+		if got := sortedAssignment(assigned); !reflect.DeepEqual(got, tt.assigned) {
+			t.Errorf("%s: assigned %v, want %v", tt.name, got, tt.assigned)
+		}
+	}
+}
+
+func TestAssignByRankingPrimaryFirst(t *testing.T) {
+	relayAuthors := selectorFixture()
+	ranking := rankRelaysByPopularity(relayAuthors, nil)
+	if want := []string{"wss://big", "wss://mid", "wss://small", "wss://solo"}; !reflect.DeepEqual(ranking, want) {
+		t.Fatalf("ranking %v, want %v", ranking, want)
+	}
+	// With two replicas a gets its primary first, then the best-ranked other relay
+	_, assigned := assignByRanking(relayAuthors, ranking, selectOptions{replicas: 2}, map[string]string{"a": "wss://mid"})
+	var relaysOfA []string
+	for relay, authors := range assigned {
+		for _, au := range authors {
+			if au == "a" {
+				relaysOfA = append(relaysOfA, relay)
+			}
+		}
+	}
+	sort.Strings(relaysOfA)
+	if want := []string{"wss://big", "wss://mid"}; !reflect.DeepEqual(relaysOfA, want) {
+		t.Errorf("a on %v, want %v", relaysOfA, want)
+	}
+	_, assigned = assignByRanking(relayAuthors, ranking, selectOptions{replicas: 1}, map[string]string{"a": "wss://mid"})
+	if got := sortedAssignment(assigned)["wss://mid"]; !reflect.DeepEqual(got, []string{"a", "e"}) {
+		t.Errorf("mid holds %v, want a (primary) and e", got)
+	}
+}
+
+func TestRankRelaysByPopularityTieBreak(t *testing.T) {
+	relayAuthors := map[string][]string{"wss://x": {"a"}, "wss://y": {"b"}, "wss://z": {"c", "d"}}
+	if got := rankRelaysByPopularity(relayAuthors, nil); !reflect.DeepEqual(got, []string{"wss://z", "wss://x", "wss://y"}) {
+		t.Errorf("by URL: %v", got)
+	}
+	preferY := func(relay string) int {
+		if relay == "wss://y" {
+			return 1
+		}
+		return 0
+	}
+	if got := rankRelaysByPopularity(relayAuthors, preferY); !reflect.DeepEqual(got, []string{"wss://z", "wss://y", "wss://x"}) {
+		t.Errorf("with tie break: %v", got)
+	}
+}
+
+func TestRandomSelector(t *testing.T) {
+	opts := selectOptions{replicas: 2}
+	selected1, assigned1 := randomSelector{seed: 42}.Select(selectorFixture(), opts)
+	selected2, assigned2 := randomSelector{seed: 42}.Select(selectorFixture(), opts)
+	if !reflect.DeepEqual(selected1, selected2) || !reflect.DeepEqual(sortedAssignment(assigned1), sortedAssignment(assigned2)) {
+		t.Fatalf("seed 42 is not reproducible: %v / %v", assigned1, assigned2)
+	}
+
+	// Every author lands on min(replicas, relays listed) of its own relays
+	relayAuthors := selectorFixture()
+	if uncovered, short := coverageShortfall(relayAuthors, assigned1, opts); len(uncovered) > 0 || short > 0 {
+		t.Errorf("uncovered %v, %d below target", uncovered, short)
+	}
+	for relay, authors := range assigned1 {
+		listed := set{}
+		for _, a := range relayAuthors[relay] {
+			listed.add(a)
+		}
+		for _, a := range authors {
+			if !listed.has(a) {
+				t.Errorf("%s assigned to %s, which it does not list", a, relay)
+			}
+		}
+	}
+
+	// Some seed picks differently, or the seed would not matter
+	differs := false
+	for seed := int64(0); seed < 20 && !differs; seed++ {
+		_, assigned := randomSelector{seed: seed}.Select(selectorFixture(), selectOptions{replicas: 1})
+		_, other := randomSelector{seed: seed + 100}.Select(selectorFixture(), selectOptions{replicas: 1})
+		differs = !reflect.DeepEqual(sortedAssignment(assigned), sortedAssignment(other))
+	}
+	if !differs {
+		t.Error("all seeds gave the same assignment")
+	}
+}
+
+func TestSelectorByName(t *testing.T) {
+	for _, name := range []string{"greedy", "Popular", "PRIMARY", "random"} {
+		if _, err := selectorByName(name, 1); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := selectorByName("fastest", 1); err == nil {
+		t.Error("unknown strategy accepted")
+	}
+	if s, _ := selectorByName("random", 7); s.(randomSelector).seed != 7 {
+		t.Error("seed not passed to the random strategy")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// softwareBucket groups outbox relays running the same software and version
+type softwareBucket struct {
+	software string
+	version  string
+	relays   []string
+}
+
+// softwareBreakdown buckets relays by the software and version in their cached
+// NIP-11 document. Relays without a document or software field land in the
+// "unknown" bucket. Buckets are sorted by size, then name.
+func softwareBreakdown(relays []string, cache *relayInfoCache) []softwareBucket {
+	byKey := map[[2]string]*softwareBucket{}
+	for _, url := range relays {
+		software, version := "unknown", ""
+		if e := cache.get(url); e != nil && e.Info != nil {
+			if name := softwareName(e.Info.Software); name != "" {
+				software = name
+				version = strings.TrimSpace(e.Info.Version)
+			}
+		}
+		key := [2]string{software, version}
+		b := byKey[key]
+		if b == nil {
+			b = &softwareBucket{software: software, version: version}
+			byKey[key] = b
+		}
+		b.relays = append(b.relays, url)
+	}
+	out := make([]softwareBucket, 0, len(byKey))
+	for _, b := range byKey {
+		sort.Strings(b.relays)
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if len(out[i].relays) != len(out[j].relays) {
+			return len(out[i].relays) > len(out[j].relays)
+		}
+		if out[i].software != out[j].software {
+			return out[i].software < out[j].software
+		}
+		return out[i].version < out[j].version
+	})
+	return out
+}
+
+// writeSoftwareBreakdown writes the buckets with their relays to path
+func writeSoftwareBreakdown(path string, buckets []softwareBucket) error {
+	total := 0
+	for _, b := range buckets {
+		total += len(b.relays)
+	}
+	lines := []string{
+		"# Outbox relays by software (from NIP-11)",
+		"# Format: software | version | relays | percent, followed by the relays",
+		"",
+	}
+	for _, b := range buckets {
+		version := b.version
+		if version == "" {
+			version = "-"
+		}
+		pct := 0.0
+		if total > 0 {
+			pct = float64(len(b.relays)) / float64(total) * 100
+		}
+		lines = append(lines, fmt.Sprintf("%s | %s | %d | %.1f%%", b.software, version, len(b.relays), pct))
+		for _, url := range b.relays {
+			lines = append(lines, "    "+url)
+		}
+	}
+	return writeLines(path, lines)
+}
@@ -1,12 +1,67 @@
 package main
 
-import "strings"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
 
-// normalizeURL normalizes a relay URL by trimming whitespace, converting to lowercase, and removing trailing slashes
+// normalizeURL is the canonical relay key used by every subcommand: trimmed,
+// lowercased, without a trailing slash, and with the host in canonical form
+// (see canonicalHost), so equivalent spellings of a relay share one key
 func normalizeURL(s string) string {
 	s = strings.ToLower(strings.TrimSpace(s))
 	s = strings.TrimSuffix(s, "/")
-	return s
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		return s
+	}
+	host, path := rest, ""
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		host, path = rest[:i], rest[i:]
+	}
+	return scheme + "://" + canonicalHost(host, scheme) + path
+}
+
+// canonicalHost drops the port when it is the scheme's default (443 for wss,
+// or when there is no scheme, and 80 for ws) and converts an internationalized
+// host name to punycode. host must already be lowercased.
+func canonicalHost(host, scheme string) string {
+	name, port := host, ""
+	if strings.HasPrefix(host, "[") {
+		// IPv6 literal: [::1]:443
+		if i := strings.Index(host, "]"); i >= 0 {
+			name, port = host[:i+1], strings.TrimPrefix(host[i+1:], ":")
+		}
+	} else if i := strings.LastIndex(host, ":"); i >= 0 && strings.Count(host, ":") == 1 {
+		name, port = host[:i], host[i+1:]
+	}
+	defaultPort := "443"
+	if scheme == "ws" || scheme == "http" {
+		defaultPort = "80"
+	}
+	name = asciiHostname(name)
+	if port == "" || port == defaultPort {
+		return name
+	}
+	return name + ":" + port
+}
+
+// Relay URL forms accepted by gen-router --url-form
+const (
+	urlFormBare = "bare" // wss://relay.example.com
+	urlFormDTag = "dtag" // wss://relay.example.com/ as in NIP-66 d tags
+)
+
+// formatRelayURL renders a normalized relay URL in the given form. The dtag
+// form adds the trailing slash only to bare host URLs; URLs with a path are
+// left alone since the slash could change what they point at.
+func formatRelayURL(u, form string) string {
+	if form == urlFormDTag && urlPath(u) == "" {
+		return u + "/"
+	}
+	return u
 }
 
 // isValidRelayURL checks if a URL is a valid relay URL
@@ -26,6 +81,64 @@ func isValidRelayURL(s string) bool {
 	return true
 }
 
+// inferScheme prefixes a relay address that has no scheme with wss://.
+// ws:// is never inferred; unencrypted relays must be written out explicitly.
+func inferScheme(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.Contains(s, "://") {
+		return s
+	}
+	return "wss://" + s
+}
+
+// parseRelayList splits a comma-separated relay list, optionally inferring
+// wss:// for scheme-less entries, and drops invalid URLs with a warning
+func parseRelayList(csv string, lenient bool) []string {
+	var out []string
+	for _, r := range splitCSV(csv) {
+		if lenient {
+			r = inferScheme(r)
+		}
+		if !isValidRelayURL(r) {
+			hint := ""
+			if !lenient && !strings.Contains(r, "://") {
+				hint = " (use --infer-scheme to assume wss://)"
+			}
+			fmt.Fprintf(os.Stderr, "warning: skipping invalid relay URL %q%s\n", r, hint)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// parseRelayListOrFile treats arg as a file of relay URLs (one per line, #
+// comments allowed) when such a file exists, otherwise as a comma-separated list
+func parseRelayListOrFile(arg string, lenient bool) ([]string, error) {
+	if st, err := os.Stat(arg); err != nil || st.IsDir() {
+		return parseRelayList(arg, lenient), nil
+	}
+	lines, err := readLines(arg)
+	if err != nil {
+		return nil, err
+	}
+	var entries []string
+	for _, l := range lines {
+		if i := strings.Index(l, "#"); i >= 0 {
+			l = l[:i]
+		}
+		if l = strings.TrimSpace(l); l != "" {
+			entries = append(entries, l)
+		}
+	}
+	return parseRelayList(strings.Join(entries, ","), lenient), nil
+}
+
+// isPlaintextRelay reports whether a relay URL uses unencrypted ws://
+func isPlaintextRelay(s string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(s)), "ws://")
+}
+
 // isHex64 validates that a string is exactly 64 hexadecimal characters
 func isHex64(s string) bool {
 	if len(s) != 64 {
@@ -38,3 +151,29 @@ func isHex64(s string) bool {
 	}
 	return true
 }
+
+// writeFileAtomic writes data to a temp file next to path and renames it into
+// place, so readers never observe a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,118 @@
+package main
+
+import "testing"
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"wss://relay.example.com", "wss://relay.example.com"},
+		{"WSS://Relay.Example.COM", "wss://relay.example.com"},
+		{"  wss://relay.example.com/  ", "wss://relay.example.com"},
+		{"wss://relay.example.com:443", "wss://relay.example.com"},
+		{"wss://relay.example.com:443/", "wss://relay.example.com"},
+		{"ws://relay.example.com:80/", "ws://relay.example.com"},
+		// Only the scheme's own default port is dropped
+		{"ws://relay.example.com:443", "ws://relay.example.com:443"},
+		{"wss://relay.example.com:80", "wss://relay.example.com:80"},
+		{"wss://relay.example.com:7777/Path/", "wss://relay.example.com:7777/path"},
+		{"wss://relay.example.com:443/inbox", "wss://relay.example.com/inbox"},
+		{"wss://[2001:DB8::1]:443/", "wss://[2001:db8::1]"},
+		{"wss://[2001:db8::1]:4848", "wss://[2001:db8::1]:4848"},
+		{"ws://[::1]:80", "ws://[::1]"},
+		{"wss://Bücher.example/", "wss://xn--bcher-kva.example"},
+		{"wss://bücher.example:443", "wss://xn--bcher-kva.example"},
+		{"wss://例え。テスト", "wss://xn--r8jz45g.xn--zckzah"},
+		{"wss://例え．テスト:8443/", "wss://xn--r8jz45g.xn--zckzah:8443"},
+		{"wss://xn--bcher-kva.example", "wss://xn--bcher-kva.example"},
+		// Without a scheme there is nothing to canonicalize against
+		{"relay.example.com/", "relay.example.com"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeURL(tt.in); got != tt.want {
+			t.Errorf("normalizeURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestURLToHost(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"wss://relay.example.com", "relay.example.com"},
+		{"WSS://Relay.Example.com:443/path?x=1", "relay.example.com"},
+		{"ws://relay.example.com:80", "relay.example.com"},
+		{"ws://relay.example.com:443", "relay.example.com:443"},
+		{"wss://relay.example.com:7777/", "relay.example.com:7777"},
+		{"wss://[2001:db8::1]:443", "[2001:db8::1]"},
+		{"wss://[2001:db8::1]:4848/x", "[2001:db8::1]:4848"},
+		{"wss://bücher.example", "xn--bcher-kva.example"},
+		{"relay.example.com:443", "relay.example.com"},
+	}
+	for _, tt := range tests {
+		if got := urlToHost(tt.in); got != tt.want {
+			t.Errorf("urlToHost(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestRelayKeyEquivalence checks that the spellings of one relay share the
+// URL key used by collect, analyze and gen-router and the host key used by
+// the exclude lists, and that the two keys agree with each other.
+func TestRelayKeyEquivalence(t *testing.T) {
+	groups := [][]string{
+		{"wss://relay.example.com", "WSS://RELAY.EXAMPLE.COM/", "wss://relay.example.com:443", " wss://Relay.Example.com:443/ "},
+		{"ws://relay.example.com", "ws://relay.example.com:80", "WS://relay.example.com:80/"},
+		{"wss://relay.example.com:7777/nostr", "wss://Relay.Example.com:7777/Nostr/"},
+		{"wss://[2001:db8::1]", "wss://[2001:DB8::1]:443/"},
+		{"wss://xn--bcher-kva.example", "wss://bücher.example", "wss://BÜCHER.example:443/"},
+		{"wss://xn--r8jz45g.xn--zckzah", "wss://例え.テスト", "wss://例え。テスト/", "wss://例え｡テスト:443"},
+	}
+	for _, g := range groups {
+		url, host := normalizeURL(g[0]), urlToHost(g[0])
+		for _, v := range g[1:] {
+			if got := normalizeURL(v); got != url {
+				t.Errorf("normalizeURL(%q) = %q, want %q", v, got, url)
+			}
+			if got := urlToHost(v); got != host {
+				t.Errorf("urlToHost(%q) = %q, want %q", v, got, host)
+			}
+			if got := urlToHost(normalizeURL(v)); got != host {
+				t.Errorf("urlToHost(normalizeURL(%q)) = %q, want %q", v, got, host)
+			}
+		}
+	}
+	// Different ports or schemes are different relays
+	distinct := []string{"wss://relay.example.com", "ws://relay.example.com", "wss://relay.example.com:7777", "wss://relay.example.com/inbox"}
+	seen := map[string]string{}
+	for _, v := range distinct {
+		key := normalizeURL(v)
+		if prev, ok := seen[key]; ok {
+			t.Errorf("%q and %q share key %q", prev, v, key)
+		}
+		seen[key] = v
+	}
+}
+
+func TestFormatRelayURL(t *testing.T) {
+	tests := []struct {
+		url, form, want string
+	}{
+		{"wss://relay.example.com", urlFormBare, "wss://relay.example.com"},
+		{"wss://relay.example.com", urlFormDTag, "wss://relay.example.com/"},
+		{"ws://relay.example.com:7777", urlFormDTag, "ws://relay.example.com:7777/"},
+		// A path already ends the URL; it gets no extra slash
+		{"wss://relay.example.com/inbox", urlFormBare, "wss://relay.example.com/inbox"},
+		{"wss://relay.example.com/inbox", urlFormDTag, "wss://relay.example.com/inbox"},
+	}
+	for _, tt := range tests {
+		if got := formatRelayURL(tt.url, tt.form); got != tt.want {
+			t.Errorf("formatRelayURL(%q, %s) = %q, want %q", tt.url, tt.form, got, tt.want)
+		}
+		// Both forms read back as the same relay
+		if got := normalizeURL(formatRelayURL(tt.url, tt.form)); got != normalizeURL(tt.url) {
+			t.Errorf("%s form of %q normalizes to %q", tt.form, tt.url, got)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	nostr "github.com/nbd-wtf/go-nostr"
+)
+
+// fetchContactLists asks each relay for the kind 3 follow lists of authors
+// and returns the followed pubkeys of the newest list seen per author
+func fetchContactLists(ctx context.Context, relays []string, batches [][]string, parallel int, timeout time.Duration) map[string][]string {
+	type contacts struct {
+		createdAt nostr.Timestamp
+		pubkeys   []string
+	}
+	newest := make(map[string]contacts)
+	fetchAuthorEvents(ctx, "WoT", relays, batches, 3, parallel, timeout, func(ev *nostr.Event) {
+		pk := strings.ToLower(ev.PubKey)
+		if cur, ok := newest[pk]; !ok || ev.CreatedAt > cur.createdAt {
+			newest[pk] = contacts{createdAt: ev.CreatedAt, pubkeys: deduplicateAndSort(followsFromTags(ev.Tags, nil))}
+		}
+	})
+
+	out := make(map[string][]string, len(newest))
+	for pk, c := range newest {
+		out[pk] = c.pubkeys
+	}
+	return out
+}
+
+// wotCandidate is a follow-of-follow and how many of your follows follow it
+type wotCandidate struct {
+	pubkey    string
+	followers int
+}
+
+// wotCandidates counts, for every pubkey in the follow lists that is not
+// excluded (you, your follows, your mutes), how many follows follow it. It
+// returns those followed by at least minFollowers, most followed first,
+// capped at maxCandidates (0 = no cap), and how many qualified before the cap.
+func wotCandidates(contactLists map[string][]string, exclude set, minFollowers, maxCandidates int) ([]wotCandidate, int) {
+	counts := make(map[string]int)
+	for _, pubkeys := range contactLists {
+		for _, pk := range pubkeys {
+			if !exclude.has(pk) {
+				counts[pk]++
+			}
+		}
+	}
+	var out []wotCandidate
+	for pk, n := range counts {
+		if n >= minFollowers {
+			out = append(out, wotCandidate{pubkey: pk, followers: n})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].followers != out[j].followers {
+			return out[i].followers > out[j].followers
+		}
+		return out[i].pubkey < out[j].pubkey
+	})
+	qualified := len(out)
+	if maxCandidates > 0 && len(out) > maxCandidates {
+		out = out[:maxCandidates]
+	}
+	return out, qualified
+}
+
+// writeWoTCandidates saves wot_candidates.txt: "followers pubkey" per added
+// author, most followed first
+func writeWoTCandidates(path string, candidates []wotCandidate) error {
+	lines := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		lines = append(lines, fmt.Sprintf("%d %s", c.followers, c.pubkey))
+	}
+	return writeLines(path, lines)
+}